@@ -0,0 +1,227 @@
+package boltapi
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+var (
+	ErrDatabaseDecode      = errors.New("error reading database config")
+	ErrDatabasePath        = errors.New("invalid database path")
+	ErrDatabaseFileExists  = errors.New("database file already exists")
+	ErrDatabaseFileMissing = errors.New("database file doesn't exist")
+	ErrDatabaseMount       = errors.New("error mounting database")
+	ErrDatabaseUnmount     = errors.New("error unmounting database")
+)
+
+// adminEndpoints lists the routes of the admin handler.
+func (multi *MultiApi) adminEndpoints() []*endpoint {
+	return []*endpoint{
+		{
+			Method:   "GET",
+			PathExp:  "/v1/admin/dbs",
+			Func:     multi.ListDatabases,
+			Summary:  "List every mounted database",
+			Response: []Database{},
+		},
+		{
+			Method:  "POST",
+			PathExp: "/v1/admin/dbs",
+			Func:    multi.AttachDatabase,
+			Summary: "Mount a database file, its path relative to the served directory",
+			Query: []queryParam{
+				{"create", "boolean", "Create a new empty database file"},
+			},
+			Body:     DatabaseConfig{},
+			Response: Database{},
+		},
+		{
+			Method:  "DELETE",
+			PathExp: "/v1/admin/dbs/#name",
+			Func:    multi.DetachDatabase,
+			Summary: "Unmount database, leaving its file in place",
+		},
+		{
+			Method:   "GET",
+			PathExp:  "/v1/admin/audit",
+			Func:     multi.GetAudit,
+			Summary:  "List the mutations recorded in the audit bucket of a database",
+			Query:    append([]queryParam{{"db", "string", "Name of the database"}}, auditQuery...),
+			Response: []AuditEntry{},
+		},
+	}
+}
+
+// adminHandler serves the endpoints attaching and detaching databases at
+// runtime, behind basic auth checked against AdminUsers.
+func (multi *MultiApi) adminHandler() (http.Handler, error) {
+	routes := []*rest.Route{}
+	for _, e := range multi.adminEndpoints() {
+		routes = append(routes, &rest.Route{
+			HttpMethod: e.Method,
+			PathExp:    e.PathExp,
+			Func:       unescapePathParams(e.Func),
+		})
+	}
+	middlewares, err := newOptions(multi.opts).stack(routes)
+	if err != nil {
+		return nil, err
+	}
+
+	api := rest.NewApi()
+	api.Use(middlewares...)
+	api.Use(&rest.AuthBasicMiddleware{
+		Realm: "boltapi admin",
+		Authenticator: func(user, password string) bool {
+			return checkPassword(multi.AdminUsers, user, password)
+		},
+	})
+
+	router, err := rest.MakeRouter(routes...)
+	if err != nil {
+		return nil, err
+	}
+	api.SetApp(router)
+	return api.MakeHandler(), nil
+}
+
+func (multi *MultiApi) ListDatabases(w rest.ResponseWriter, r *rest.Request) {
+	w.WriteJson(multi.Databases())
+}
+
+// AttachDatabase mounts an existing database file, or creates a new empty
+// one when the create query param is set.
+func (multi *MultiApi) AttachDatabase(w rest.ResponseWriter, r *rest.Request) {
+	fail := func(cusromErr, origErr error) {
+		logError(r, cusromErr, origErr)
+		rest.Error(w, cusromErr.Error(), http.StatusInternalServerError)
+	}
+
+	config := &DatabaseConfig{}
+	if err := r.DecodeJsonPayload(config); err != nil {
+		fail(ErrDatabaseDecode, err)
+		return
+	}
+
+	path, err := multi.resolvePath(config.Path)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	config.Path = path
+
+	create := queryBool(r, "create", false)
+	_, err = os.Stat(path)
+	switch {
+	case create && err == nil:
+		rest.Error(w, ErrDatabaseFileExists.Error(), http.StatusConflict)
+		return
+	case !create && os.IsNotExist(err):
+		rest.Error(w, ErrDatabaseFileMissing.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := multi.Mount(config); err != nil {
+		switch err {
+		case ErrDatabaseMounted:
+			rest.Error(w, err.Error(), http.StatusConflict)
+		case ErrDatabaseInvalidName:
+			rest.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			fail(ErrDatabaseMount, err)
+		}
+		return
+	}
+	requestLogOf(r).logger.Info("mounted database", "name", config.Name, "path", config.Path)
+	w.WriteJson(config.database())
+}
+
+// DetachDatabase unmounts a database, waiting for the requests it's serving
+// to finish. The file is left in place.
+func (multi *MultiApi) DetachDatabase(w rest.ResponseWriter, r *rest.Request) {
+	name := r.PathParam("name")
+	if err := multi.Unmount(name); err != nil {
+		if err == ErrDatabaseMissing {
+			rest.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		logError(r, ErrDatabaseUnmount, err)
+		rest.Error(w, ErrDatabaseUnmount.Error(), http.StatusInternalServerError)
+		return
+	}
+	requestLogOf(r).logger.Info("unmounted database", "name", name)
+}
+
+// GetAudit lists the audit entries of the database named by the db query
+// param, as its own audit endpoint does.
+func (multi *MultiApi) GetAudit(w rest.ResponseWriter, r *rest.Request) {
+	name := r.URL.Query().Get("db")
+	if name == "" {
+		rest.Error(w, ErrDatabaseInvalidName.Error(), http.StatusBadRequest)
+		return
+	}
+	m, ok := multi.acquire(name)
+	if !ok {
+		rest.Error(w, ErrDatabaseMissing.Error(), http.StatusNotFound)
+		return
+	}
+	defer m.inflight.Done()
+	m.restapi.GetAudit(w, r)
+}
+
+// resolvePath makes path relative to Dir, or to the working directory
+// when Dir isn't set, refusing paths escaping it, symlinks included.
+func (multi *MultiApi) resolvePath(path string) (string, error) {
+	if strings.TrimSpace(path) == "" || filepath.IsAbs(path) {
+		return "", ErrDatabasePath
+	}
+
+	dir := multi.Dir
+	if dir == "" {
+		dir = "."
+	}
+	root, err := filepath.Abs(dir)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return "", ErrDatabasePath
+	}
+	target, err := realPath(filepath.Join(root, path))
+	if err != nil {
+		return "", ErrDatabasePath
+	}
+	// Rel rather than a prefix check, for Dir to be / as well
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrDatabasePath
+	}
+
+	if multi.Dir == "" {
+		return filepath.Clean(path), nil
+	}
+	return filepath.Join(filepath.Clean(multi.Dir), path), nil
+}
+
+// realPath resolves the symlinks of path, which may not exist yet when a
+// database is created. Its directory has to, and path can't be a dangling
+// symlink, which would create the file wherever it points to.
+func realPath(path string) (string, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if !os.IsNotExist(err) {
+		return target, err
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return "", ErrDatabasePath
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(path)), nil
+}
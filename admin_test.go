@@ -0,0 +1,173 @@
+package boltapi_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAdminApi(t *testing.T) {
+	Convey("testing database management", t, func() {
+		dir, err := ioutil.TempDir("", "boltapi")
+		So(err, ShouldBeNil)
+
+		multi, err := boltapi.NewMultiApi()
+		So(err, ShouldBeNil)
+		multi.AdminUsers = map[string]string{"admin": "secret"}
+		multi.Dir = dir
+
+		admin := map[string]string{"Authorization": "Basic YWRtaW46c2VjcmV0"}
+
+		Convey("should require admin credentials", func() {
+			response := serve(multi, "GET", "/v1/admin/dbs", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusUnauthorized)
+
+			response = serve(multi, "POST", "/v1/admin/dbs?create=true", strings.NewReader(`{"Name": "db1", "Path": "db1.db"}`), nil)
+			So(response.Code, ShouldEqual, http.StatusUnauthorized)
+			So(multi.Databases(), ShouldBeEmpty)
+		})
+
+		Convey("should create and attach databases", func() {
+			response := serve(multi, "POST", "/v1/admin/dbs?create=true", strings.NewReader(`{"Name": "db1", "Path": "db1.db"}`), admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			database := &boltapi.Database{}
+			So(json.Unmarshal(response.Body.Bytes(), database), ShouldBeNil)
+			So(database.Name, ShouldEqual, "db1")
+			So(database.Path, ShouldEqual, filepath.Join(dir, "db1.db"))
+
+			response = serve(multi, "POST", "/v1/dbs/db1/buckets", strings.NewReader(`{"name": "bucket1"}`), nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			response = serve(multi, "POST", "/v1/admin/dbs?create=true", strings.NewReader(`{"Name": "db2", "Path": "db1.db"}`), admin)
+			So(response.Code, ShouldEqual, http.StatusConflict)
+			So(response.Body.String(), ShouldContainSubstring, boltapi.ErrDatabaseFileExists.Error())
+
+			response = serve(multi, "POST", "/v1/admin/dbs?create=true", strings.NewReader(`{"Name": "db1", "Path": "other.db"}`), admin)
+			So(response.Code, ShouldEqual, http.StatusConflict)
+			So(response.Body.String(), ShouldContainSubstring, boltapi.ErrDatabaseMounted.Error())
+
+			db, err := bolt.Open(filepath.Join(dir, "db3.db"), 0600, &bolt.Options{Timeout: 1 * time.Second})
+			So(err, ShouldBeNil)
+			So(db.Close(), ShouldBeNil)
+
+			response = serve(multi, "POST", "/v1/admin/dbs", strings.NewReader(`{"Name": "db3", "Path": "db3.db", "ReadOnly": true}`), admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			response = serve(multi, "GET", "/v1/admin/dbs", nil, admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			databases := []*boltapi.Database{}
+			So(json.Unmarshal(response.Body.Bytes(), &databases), ShouldBeNil)
+			So(len(databases), ShouldEqual, 2)
+			So(databases[1].ReadOnly, ShouldBeTrue)
+		})
+
+		Convey("should refuse attaching missing files or paths outside of dir", func() {
+			response := serve(multi, "POST", "/v1/admin/dbs", strings.NewReader(`{"Name": "db1", "Path": "db1.db"}`), admin)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+			So(response.Body.String(), ShouldContainSubstring, boltapi.ErrDatabaseFileMissing.Error())
+
+			response = serve(multi, "POST", "/v1/admin/dbs?create=true", strings.NewReader(`{"Name": "db1", "Path": "../db1.db"}`), admin)
+			So(response.Code, ShouldEqual, http.StatusBadRequest)
+
+			response = serve(multi, "POST", "/v1/admin/dbs?create=true", strings.NewReader(`{"Name": "db1", "Path": "/tmp/db1.db"}`), admin)
+			So(response.Code, ShouldEqual, http.StatusBadRequest)
+			So(response.Body.String(), ShouldContainSubstring, boltapi.ErrDatabasePath.Error())
+		})
+
+		Convey("should refuse symlinks pointing outside of dir", func() {
+			outside, err := ioutil.TempDir("", "boltapi")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(outside)
+			So(os.Symlink(outside, filepath.Join(dir, "linked")), ShouldBeNil)
+			So(os.Symlink(filepath.Join(outside, "db1.db"), filepath.Join(dir, "dangling.db")), ShouldBeNil)
+
+			for _, path := range []string{"linked/db1.db", "dangling.db"} {
+				response := serve(multi, "POST", "/v1/admin/dbs?create=true", strings.NewReader(`{"Name": "db1", "Path": "`+path+`"}`), admin)
+				So(response.Code, ShouldEqual, http.StatusBadRequest)
+				So(response.Body.String(), ShouldContainSubstring, boltapi.ErrDatabasePath.Error())
+			}
+			_, err = os.Stat(filepath.Join(outside, "db1.db"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("should accept paths when dir is the root directory", func() {
+			multi.Dir = "/"
+			path := strings.TrimPrefix(filepath.Join(dir, "db1.db"), "/")
+			response := serve(multi, "POST", "/v1/admin/dbs?create=true", strings.NewReader(`{"Name": "db1", "Path": "`+path+`"}`), admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("should keep paths within the working directory without dir", func() {
+			multi.Dir = ""
+			for _, path := range []string{"/tmp/db1.db", "../db1.db", "data/../../db1.db", "."} {
+				response := serve(multi, "POST", "/v1/admin/dbs?create=true", strings.NewReader(`{"Name": "db1", "Path": "`+path+`"}`), admin)
+				So(response.Code, ShouldEqual, http.StatusBadRequest)
+				So(response.Body.String(), ShouldContainSubstring, boltapi.ErrDatabasePath.Error())
+			}
+			So(multi.Databases(), ShouldBeEmpty)
+		})
+
+		Convey("should detach databases", func() {
+			response := serve(multi, "POST", "/v1/admin/dbs?create=true", strings.NewReader(`{"Name": "db1", "Path": "db1.db"}`), admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			response = serve(multi, "DELETE", "/v1/admin/dbs/db1", nil, admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			response = serve(multi, "GET", "/v1/dbs/db1/buckets", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+
+			response = serve(multi, "DELETE", "/v1/admin/dbs/db1", nil, admin)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+
+			// the file is left in place and can be attached again
+			response = serve(multi, "POST", "/v1/admin/dbs", strings.NewReader(`{"Name": "db1", "Path": "db1.db"}`), admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("should list the audit entries of databases", func() {
+			multi, err = boltapi.NewMultiApi(boltapi.Audit(nil, "_audit"))
+			So(err, ShouldBeNil)
+			multi.AdminUsers = map[string]string{"admin": "secret"}
+			multi.Dir = dir
+
+			response := serve(multi, "POST", "/v1/admin/dbs?create=true", strings.NewReader(`{"Name": "db1", "Path": "db1.db"}`), admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			response = serve(multi, "POST", "/v1/dbs/db1/buckets", strings.NewReader(`{"name": "bucket1"}`), nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			response = serve(multi, "GET", "/v1/admin/audit?db=db1", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusUnauthorized)
+			response = serve(multi, "GET", "/v1/dbs/db1/admin/audit", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+
+			response = serve(multi, "GET", "/v1/admin/audit?db=db1", nil, admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			entries := []*boltapi.AuditEntry{}
+			So(json.Unmarshal(response.Body.Bytes(), &entries), ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Database, ShouldEqual, "db1")
+			So(entries[0].Bucket, ShouldEqual, "bucket1")
+
+			response = serve(multi, "GET", "/v1/admin/audit?db=db2", nil, admin)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+			response = serve(multi, "GET", "/v1/admin/audit", nil, admin)
+			So(response.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Reset(func() {
+			multi.Close()
+			os.RemoveAll(dir)
+		})
+	})
+}
@@ -0,0 +1,459 @@
+package boltapi
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/boltdb/bolt"
+)
+
+// Audited operations, the batch ones keep their name.
+const (
+	AuditCreateBucket = BatchCreateBucket
+	AuditDeleteBucket = BatchDeleteBucket
+	AuditPut          = BatchPut
+	AuditDelete       = BatchDelete
+	AuditRenameBucket = "renameBucket"
+	AuditCopyBucket   = "copyBucket"
+	AuditSetSequence  = "setSequence"
+	AuditReencrypt    = "reencrypt"
+	AuditRecompress   = "recompress"
+)
+
+// DefaultAuditLimit is the number of entries returned by the audit endpoint
+// when the request doesn't set a limit.
+const DefaultAuditLimit = 100
+
+// RequestIdHeader identifies requests, it's recorded in the audit log.
+const RequestIdHeader = "X-Request-ID"
+
+var (
+	ErrAuditBucket   = errors.New("audit bucket is only read through the audit endpoint")
+	ErrAuditDisabled = errors.New("audit bucket isn't configured")
+	ErrAuditQuery    = errors.New("invalid audit query")
+	ErrAuditRead     = errors.New("error reading audit log")
+	ErrAuditWrite    = errors.New("error writing audit log")
+)
+
+// AuditEntry records a mutation. Items are put and deleted with the SHA-256
+// of the values stored before and after, as stored, so after encoding,
+// compression and encryption. Nested buckets are deleted by key, and blobs
+// whose chunks were re-encrypted are recorded by key too, without hashes.
+type AuditEntry struct {
+	// Id orders the entries of the audit bucket, it's not set in the file
+	Id          uint64 `json:",omitempty"`
+	Time        time.Time
+	RequestId   string `json:",omitempty"`
+	Database    string `json:",omitempty"`
+	Principal   string `json:",omitempty"`
+	Op          string
+	Bucket      string
+	Key         string `json:",omitempty"`
+	Destination string `json:",omitempty"`
+	OldHash     string `json:",omitempty"`
+	NewHash     string `json:",omitempty"`
+}
+
+// auditLog is shared by the apis built with the same Audit option, so lines
+// written by several databases don't interleave.
+type auditLog struct {
+	mu     sync.Mutex
+	writer io.Writer
+	bucket string
+}
+
+// within tells whether the bucket path is the audit bucket or one nested in
+// it.
+func (audit *auditLog) within(path string) bool {
+	return path == audit.bucket || strings.HasPrefix(path, audit.bucket+"/")
+}
+
+// write writes the entries once their transaction is committed. Failing
+// can't undo the write anymore, it's logged.
+func (audit *auditLog) write(logger *slog.Logger, entries []*AuditEntry) {
+	if audit.writer == nil || len(entries) == 0 {
+		return
+	}
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err == nil {
+			_, err = audit.writer.Write(append(line, '\n'))
+		}
+		if err != nil {
+			logger.Error(ErrAuditWrite.Error(), "error", err.Error())
+		}
+	}
+}
+
+// auditTrail collects the mutations of a transaction. Its methods do
+// nothing on a nil trail, when auditing is off.
+type auditTrail struct {
+	audit     *auditLog
+	logger    *slog.Logger
+	requestId string
+	database  string
+	principal string
+	entries   []*AuditEntry
+}
+
+func (restapi *RestApi) auditTrail(r *rest.Request) *auditTrail {
+	principal, _ := r.Env["REMOTE_USER"].(string)
+	return restapi.options.auditTrail(requestLogOf(r).logger, r.Header.Get(RequestIdHeader), principal)
+}
+
+func (o *options) auditTrail(logger *slog.Logger, requestId, principal string) *auditTrail {
+	if o.audit == nil {
+		return nil
+	}
+	return &auditTrail{
+		audit:     o.audit,
+		logger:    logger,
+		requestId: requestId,
+		database:  o.database,
+		principal: principal,
+	}
+}
+
+// fork returns an empty trail recording mutations on behalf of the same
+// request, for the transactions of work it started.
+func (trail *auditTrail) fork() *auditTrail {
+	if trail == nil {
+		return nil
+	}
+	return &auditTrail{
+		audit:     trail.audit,
+		logger:    trail.logger,
+		requestId: trail.requestId,
+		database:  trail.database,
+		principal: trail.principal,
+	}
+}
+
+func (trail *auditTrail) add(entry *AuditEntry) {
+	entry.Time = time.Now().UTC()
+	entry.RequestId = trail.requestId
+	entry.Database = trail.database
+	entry.Principal = trail.principal
+	trail.entries = append(trail.entries, entry)
+}
+
+// item records an item put or deleted, hashing the values right away as
+// they're only valid until the item changes.
+func (trail *auditTrail) item(op, bucket string, key, oldValue, newValue []byte) {
+	if trail == nil {
+		return
+	}
+	trail.add(&AuditEntry{
+		Op:      op,
+		Bucket:  bucket,
+		Key:     string(key),
+		OldHash: valueHash(oldValue),
+		NewHash: valueHash(newValue),
+	})
+}
+
+// bucket records an operation on a whole bucket.
+func (trail *auditTrail) bucket(op, bucket, destination string) {
+	if trail == nil {
+		return
+	}
+	trail.add(&AuditEntry{Op: op, Bucket: bucket, Destination: destination})
+}
+
+// store adds the entries to the audit bucket in the transaction of the
+// mutations, refusing those of the audit bucket itself.
+func (trail *auditTrail) store(tx *bolt.Tx) error {
+	if trail == nil || trail.audit.bucket == "" || len(trail.entries) == 0 {
+		return nil
+	}
+	for _, entry := range trail.entries {
+		if trail.audit.within(entry.Bucket) || trail.audit.within(entry.Destination) {
+			return ErrAuditBucket
+		}
+	}
+
+	bucket, err := tx.CreateBucketIfNotExists([]byte(trail.audit.bucket))
+	if err != nil {
+		return err
+	}
+	for _, entry := range trail.entries {
+		if entry.Id, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(auditKey(entry.Id), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (trail *auditTrail) write() {
+	if trail != nil {
+		trail.audit.write(trail.logger, trail.entries)
+	}
+}
+
+// update runs fn in a write transaction, auditing the mutations it records
+// on the trail, timed for the slow request log and traced.
+func (restapi *RestApi) update(r *rest.Request, fn func(tx *bolt.Tx, trail *auditTrail) error) error {
+	trail := restapi.auditTrail(r)
+	start := time.Now()
+	err := traced(r, "bolt.Update", func() error {
+		return restapi.db.Update(func(tx *bolt.Tx) error {
+			if err := fn(tx, trail); err != nil {
+				return err
+			}
+			return trail.store(tx)
+		})
+	})
+	requestLogOf(r).time(true, start)
+	if err != nil {
+		return err
+	}
+	trail.write()
+	return nil
+}
+
+// protectAudit answers 403 Forbidden to the requests going through the
+// audit bucket, which is only read through the audit endpoint.
+func (restapi *RestApi) protectAudit(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		if strings.TrimSpace(r.PathParam("name")) == restapi.options.audit.bucket {
+			logError(r, ErrAuditBucket, nil)
+			rest.Error(w, ErrAuditBucket.Error(), http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func valueHash(value []byte) string {
+	if value == nil {
+		return ""
+	}
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+func auditKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// auditFilter selects the entries returned by the audit endpoint, empty
+// fields match anything.
+type auditFilter struct {
+	bucket, key, principal, op string
+	since, until               time.Time
+	start                      uint64
+	limit                      int
+}
+
+func parseAuditFilter(r *rest.Request) (*auditFilter, error) {
+	query := r.URL.Query()
+	filter := &auditFilter{
+		bucket:    query.Get("bucket"),
+		key:       query.Get("key"),
+		principal: query.Get("principal"),
+		op:        query.Get("op"),
+		limit:     DefaultAuditLimit,
+	}
+
+	var err error
+	if value := query.Get("since"); value != "" {
+		if filter.since, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, err
+		}
+	}
+	if value := query.Get("until"); value != "" {
+		if filter.until, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, err
+		}
+	}
+	if value := query.Get("start"); value != "" {
+		if filter.start, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.limit, err = strconv.Atoi(value); err != nil {
+			return nil, err
+		}
+		if filter.limit <= 0 {
+			return nil, ErrBucketPageLimit
+		}
+	}
+	return filter, nil
+}
+
+func (filter *auditFilter) match(entry *AuditEntry) bool {
+	switch {
+	case filter.bucket != "" && entry.Bucket != filter.bucket && entry.Destination != filter.bucket:
+		return false
+	case filter.key != "" && entry.Key != filter.key:
+		return false
+	case filter.principal != "" && entry.Principal != filter.principal:
+		return false
+	case filter.op != "" && entry.Op != filter.op:
+		return false
+	case !filter.since.IsZero() && entry.Time.Before(filter.since):
+		return false
+	case !filter.until.IsZero() && !entry.Time.Before(filter.until):
+		return false
+	}
+	return true
+}
+
+// GetAudit lists the entries of the audit bucket from the oldest, a page
+// at a time, the NextKeyHeader holding the id of the next page.
+func (restapi *RestApi) GetAudit(w rest.ResponseWriter, r *rest.Request) {
+	if restapi.options.audit == nil || restapi.options.audit.bucket == "" {
+		logError(r, ErrAuditDisabled, nil)
+		rest.Error(w, ErrAuditDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		logError(r, ErrAuditQuery, err)
+		rest.Error(w, ErrAuditQuery.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries := []*AuditEntry{}
+	next := uint64(0)
+	if err := restapi.view(r, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(restapi.options.audit.bucket))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek(auditKey(filter.start)); k != nil; k, v = c.Next() {
+			entry := new(AuditEntry)
+			if err := json.Unmarshal(v, entry); err != nil {
+				return err
+			}
+			if !filter.match(entry) {
+				continue
+			}
+			if len(entries) == filter.limit {
+				next = entry.Id
+				break
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	}); err != nil {
+		logError(r, ErrAuditRead, err)
+		rest.Error(w, ErrAuditRead.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if next > 0 {
+		w.Header().Set(NextKeyHeader, strconv.FormatUint(next, 10))
+	}
+	w.WriteJson(entries)
+}
+
+// RotatingFile appends to a file, moving it aside once it grows past
+// maxSize bytes. Rotated files are named after it with a .1, .2, ...
+// suffix, .1 being the latest, and only maxBackups of them are kept.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens path for appending, rotating it past maxSize
+// bytes unless maxSize isn't positive.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends p, rotating the file first if p would take it past its
+// maximum size. A line is never split across files.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups by one, dropping the oldest, and starts over
+// with an empty file.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	backup := func(i int) string {
+		return fmt.Sprintf("%s.%d", f.path, i)
+	}
+	for i := f.maxBackups; i > 1; i-- {
+		if err := os.Rename(backup(i-1), backup(i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	var err error
+	if f.maxBackups > 0 {
+		err = os.Rename(f.path, backup(1))
+	} else {
+		err = os.Remove(f.path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,232 @@
+package boltapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAudit(t *testing.T) {
+	Convey("testing the audit log", t, func() {
+		_, db := prepDB(t)
+		So(db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucket([]byte("bucket1"))
+			return err
+		}), ShouldBeNil)
+
+		logged := new(bytes.Buffer)
+		restapi, err := boltapi.NewRestApi(db, boltapi.Audit(logged, "_audit"))
+		So(err, ShouldBeNil)
+		restapi.Use(boltapi.BasicAuth("boltapi", map[string]string{"admin": "secret"}))
+		handler := restapi.GetHandler()
+
+		admin := map[string]string{"Authorization": "Basic YWRtaW46c2VjcmV0"}
+		query := func(url string) []*boltapi.AuditEntry {
+			response := serve(handler, "GET", url, nil, admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			entries := []*boltapi.AuditEntry{}
+			So(json.Unmarshal(response.Body.Bytes(), &entries), ShouldBeNil)
+			return entries
+		}
+
+		Convey("should record mutations in the file and the bucket", func() {
+			So(serve(handler, "POST", "/v1/buckets", strings.NewReader(`{"name": "bucket2"}`), admin).Code, ShouldEqual, http.StatusOK)
+			withId := map[string]string{"Authorization": admin["Authorization"], boltapi.RequestIdHeader: "req-1"}
+			So(serve(handler, "PUT", "/v1/buckets/bucket1/item1", strings.NewReader(`"apple"`), withId).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "PUT", "/v1/buckets/bucket1/item1", strings.NewReader(`"banana"`), admin).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "DELETE", "/v1/buckets/bucket1/item1", nil, admin).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "DELETE", "/v1/buckets/bucket2", nil, admin).Code, ShouldEqual, http.StatusOK)
+
+			// failed and no-op writes aren't recorded
+			So(serve(handler, "POST", "/v1/buckets/missing", strings.NewReader(`{"Key": "item1", "Value": 1}`), admin).Code, ShouldEqual, http.StatusInternalServerError)
+			So(serve(handler, "DELETE", "/v1/buckets/bucket1/item1", nil, admin).Code, ShouldEqual, http.StatusOK)
+
+			entries := query("/v1/admin/audit")
+			So(len(entries), ShouldEqual, 5)
+			ops := []string{}
+			for _, entry := range entries {
+				ops = append(ops, entry.Op)
+				So(entry.Principal, ShouldEqual, "admin")
+				So(entry.Time.IsZero(), ShouldBeFalse)
+			}
+			So(ops, ShouldResemble, []string{
+				boltapi.AuditCreateBucket, boltapi.AuditPut, boltapi.AuditPut,
+				boltapi.AuditDelete, boltapi.AuditDeleteBucket,
+			})
+			So(entries[0].Bucket, ShouldEqual, "bucket2")
+			So(entries[1].RequestId, ShouldEqual, "req-1")
+			So(entries[1].Key, ShouldEqual, "item1")
+			So(entries[1].OldHash, ShouldBeEmpty)
+			So(entries[1].NewHash, ShouldNotBeEmpty)
+			So(entries[2].OldHash, ShouldEqual, entries[1].NewHash)
+			So(entries[3].OldHash, ShouldEqual, entries[2].NewHash)
+			So(entries[3].NewHash, ShouldBeEmpty)
+
+			lines := strings.Split(strings.TrimSpace(logged.String()), "\n")
+			So(len(lines), ShouldEqual, 5)
+			for i, line := range lines {
+				entry := new(boltapi.AuditEntry)
+				So(json.Unmarshal([]byte(line), entry), ShouldBeNil)
+				So(entry.Op, ShouldEqual, ops[i])
+				So(entry.Id, ShouldEqual, entries[i].Id)
+			}
+		})
+
+		Convey("should record batches and transfers item by item", func() {
+			response := serve(handler, "POST", "/v1/batch", strings.NewReader(`{"ops": [
+				{"op": "put", "bucket": "bucket1", "key": "a", "value": "MQ=="},
+				{"op": "put", "bucket": "bucket1", "key": "b", "value": "Mg=="}
+			]}`), admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "POST", "/v1/buckets/bucket1/move", strings.NewReader(`{"name": "bucket3"}`), admin).Code, ShouldEqual, http.StatusOK)
+
+			entries := query("/v1/admin/audit?key=a")
+			So(len(entries), ShouldEqual, 3)
+			So(entries[1].Op, ShouldEqual, boltapi.AuditDelete)
+			So(entries[1].Bucket, ShouldEqual, "bucket1")
+			So(entries[2].Op, ShouldEqual, boltapi.AuditPut)
+			So(entries[2].Bucket, ShouldEqual, "bucket3")
+			So(entries[2].NewHash, ShouldEqual, entries[0].NewHash)
+
+			So(len(query("/v1/admin/audit?bucket=bucket3")), ShouldEqual, 2)
+			So(len(query("/v1/admin/audit?op=delete")), ShouldEqual, 2)
+			So(len(query("/v1/admin/audit?principal=nobody")), ShouldEqual, 0)
+			So(len(query("/v1/admin/audit?since=2000-01-01T00:00:00Z&until=2100-01-01T00:00:00Z")), ShouldEqual, 6)
+			So(len(query("/v1/admin/audit?until=2000-01-01T00:00:00Z")), ShouldEqual, 0)
+
+			response = serve(handler, "GET", "/v1/admin/audit?limit=4", nil, admin)
+			So(response.Header().Get(boltapi.NextKeyHeader), ShouldEqual, "5")
+			So(len(query("/v1/admin/audit?start=5")), ShouldEqual, 2)
+
+			So(serve(handler, "GET", "/v1/admin/audit?since=yesterday", nil, admin).Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("should record values rewritten in place", func() {
+			So(db.Update(func(tx *bolt.Tx) error {
+				bucket := tx.Bucket([]byte("bucket1"))
+				for _, key := range []string{"a", "b", "c"} {
+					if err := bucket.Put([]byte(key), []byte(`"`+strings.Repeat(key, 100)+`"`)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}), ShouldBeNil)
+
+			_, err := boltapi.Recompress(db, "bucket1", boltapi.GzipCompression, 2, boltapi.Audit(logged, "_audit"))
+			So(err, ShouldBeNil)
+			entries := query("/v1/admin/audit?op=" + boltapi.AuditRecompress)
+			So(len(entries), ShouldEqual, 3)
+			So(entries[0].Key, ShouldEqual, "a")
+			So(entries[0].OldHash, ShouldNotEqual, entries[0].NewHash)
+
+			keyring, err := boltapi.NewKeyring("1", map[string][]byte{"1": bytes.Repeat([]byte{1}, 32)})
+			So(err, ShouldBeNil)
+			encrypted, err := boltapi.NewRestApi(db, boltapi.Audit(logged, "_audit"), boltapi.Encryption(keyring, "bucket1"))
+			So(err, ShouldBeNil)
+			withId := map[string]string{boltapi.RequestIdHeader: "req-2"}
+			So(serve(encrypted.GetHandler(), "POST", "/v1/buckets/bucket1/reencrypt", nil, withId).Code, ShouldEqual, http.StatusAccepted)
+
+			entries = nil
+			for i := 0; i < 100 && len(entries) < 3; i++ {
+				time.Sleep(10 * time.Millisecond)
+				entries = query("/v1/admin/audit?op=" + boltapi.AuditReencrypt)
+			}
+			So(len(entries), ShouldEqual, 3)
+			So(entries[2].Key, ShouldEqual, "c")
+			So(entries[2].RequestId, ShouldEqual, "req-2")
+			So(entries[2].NewHash, ShouldNotBeEmpty)
+			So(logged.String(), ShouldContainSubstring, `"Op":"reencrypt"`)
+		})
+
+		Convey("should refuse reads of the audit bucket", func() {
+			So(serve(handler, "PUT", "/v1/buckets/bucket1/item1", strings.NewReader(`"apple"`), admin).Code, ShouldEqual, http.StatusOK)
+
+			So(serve(handler, "GET", "/v1/buckets/_audit", nil, admin).Code, ShouldEqual, http.StatusForbidden)
+			So(serve(handler, "GET", "/v1/buckets/_audit/"+strings.Repeat("%00", 8), nil, admin).Code, ShouldEqual, http.StatusForbidden)
+			So(serve(handler, "POST", "/v1/buckets/_audit/mget", strings.NewReader(`{"keys": ["a"]}`), admin).Code, ShouldEqual, http.StatusForbidden)
+
+			response := serve(handler, "GET", "/v1/buckets?full=true", nil, admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldNotContainSubstring, "_audit")
+
+			response = serve(handler, "POST", "/v1/mget", strings.NewReader(`{"items": [{"bucket": "_audit", "key": "a"}]}`), admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldContainSubstring, `"Missing": true`)
+		})
+
+		Convey("should refuse writes of the audit bucket", func() {
+			So(serve(handler, "PUT", "/v1/buckets/bucket1/item1", strings.NewReader(`"apple"`), admin).Code, ShouldEqual, http.StatusOK)
+
+			So(serve(handler, "PUT", "/v1/buckets/_audit/item1", strings.NewReader(`"apple"`), admin).Code, ShouldEqual, http.StatusForbidden)
+			So(serve(handler, "DELETE", "/v1/buckets/_audit", nil, admin).Code, ShouldEqual, http.StatusForbidden)
+			So(serve(handler, "POST", "/v1/buckets/_audit/truncate", nil, admin).Code, ShouldEqual, http.StatusForbidden)
+			So(serve(handler, "POST", "/v1/batch", strings.NewReader(`{"ops": [{"op": "put", "bucket": "_audit", "key": "item1", "value": "MQ=="}]}`), admin).Code, ShouldEqual, http.StatusForbidden)
+			So(serve(handler, "POST", "/v1/buckets/bucket1/copy", strings.NewReader(`{"path": ["_audit", "copy"]}`), admin).Code, ShouldEqual, http.StatusForbidden)
+			So(serve(handler, "POST", "/v1/buckets/bucket1/move", strings.NewReader(`{"path": ["_audit", "moved"]}`), admin).Code, ShouldEqual, http.StatusForbidden)
+
+			So(len(query("/v1/admin/audit")), ShouldEqual, 1)
+		})
+
+		Convey("should answer 404 without audit bucket", func() {
+			restapi, err := boltapi.NewRestApi(db, boltapi.Audit(logged, ""))
+			So(err, ShouldBeNil)
+			handler = restapi.GetHandler()
+			So(serve(handler, "PUT", "/v1/buckets/bucket1/item1", strings.NewReader(`"apple"`), admin).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "GET", "/v1/admin/audit", nil, admin).Code, ShouldEqual, http.StatusNotFound)
+			So(logged.String(), ShouldContainSubstring, `"Op":"put"`)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
+
+func TestRotatingFile(t *testing.T) {
+	Convey("testing rotating files", t, func() {
+		dir, err := ioutil.TempDir("", "boltapi")
+		So(err, ShouldBeNil)
+		path := filepath.Join(dir, "audit.log")
+
+		f, err := boltapi.OpenRotatingFile(path, 10, 2)
+		So(err, ShouldBeNil)
+		for _, line := range []string{"line1\n", "line2\n", "line3\n", "line4\n"} {
+			_, err := io.WriteString(f, line)
+			So(err, ShouldBeNil)
+		}
+		So(f.Close(), ShouldBeNil)
+
+		read := func(name string) string {
+			content, err := ioutil.ReadFile(filepath.Join(dir, name))
+			So(err, ShouldBeNil)
+			return string(content)
+		}
+		So(read("audit.log"), ShouldEqual, "line4\n")
+		So(read("audit.log.1"), ShouldEqual, "line3\n")
+		So(read("audit.log.2"), ShouldEqual, "line2\n")
+		_, err = os.Stat(filepath.Join(dir, "audit.log.3"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		// appends to the file left
+		f, err = boltapi.OpenRotatingFile(path, 100, 2)
+		So(err, ShouldBeNil)
+		io.WriteString(f, "line5\n")
+		So(f.Close(), ShouldBeNil)
+		So(read("audit.log"), ShouldEqual, "line4\nline5\n")
+
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+	})
+}
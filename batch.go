@@ -0,0 +1,159 @@
+package boltapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/boltdb/bolt"
+)
+
+const (
+	BatchPut          = "put"
+	BatchDelete       = "delete"
+	BatchCreateBucket = "createBucket"
+	BatchDeleteBucket = "deleteBucket"
+)
+
+// BatchOp is a single write of a batch. Json values are stored like the
+// item endpoints store them, with the codec, compression and encryption of
+// the bucket. Raw values are stored as given, which only buckets storing
+// plain JSON accept.
+type BatchOp struct {
+	Op     string
+	Bucket string
+	Key    string          `json:",omitempty"`
+	Value  []byte          `json:",omitempty"`
+	Json   json.RawMessage `json:",omitempty"`
+}
+
+type BatchResult struct {
+	Ops int
+}
+
+// RawBucketItem is a bucket item whose value is returned as stored, base64
+// encoded in JSON.
+type RawBucketItem struct {
+	Key   string
+	Value []byte
+}
+
+func (restapi *RestApi) ApplyBatch(w rest.ResponseWriter, r *rest.Request) {
+	fail := func(cusromErr, origErr error) {
+		logError(r, cusromErr, origErr)
+		rest.Error(w, cusromErr.Error(), http.StatusInternalServerError)
+	}
+
+	payload := struct{ Ops []*BatchOp }{}
+	if err := r.DecodeJsonPayload(&payload); err != nil {
+		fail(ErrBatchDecode, err)
+		return
+	}
+	for _, op := range payload.Ops {
+		if isBlobBucket(op.Bucket) {
+			logError(r, ErrBucketBlobName, nil)
+			rest.Error(w, ErrBucketBlobName.Error(), http.StatusBadRequest)
+			return
+		}
+		if op.Op != BatchPut {
+			continue
+		}
+		if restapi.reserved[op.Key] {
+			logError(r, ErrBucketKeyReserved, nil)
+			rest.Error(w, ErrBucketKeyReserved.Error(), http.StatusBadRequest)
+			return
+		}
+		if op.Json == nil {
+			if !restapi.options.plainStorage(op.Bucket) {
+				logError(r, ErrBatchValue, nil)
+				rest.Error(w, ErrBatchValue.Error(), http.StatusBadRequest)
+				return
+			}
+			continue
+		}
+
+		// encoded up front, applyBatchOp stores op.Value as is
+		item := &BucketItem{Key: op.Key}
+		if err := item.DecodeValue(op.Json); err != nil {
+			fail(ErrBatchDecode, err)
+			return
+		}
+		encoded, err := restapi.options.encodeStored(op.Bucket, item)
+		if err != nil {
+			fail(err, nil)
+			return
+		}
+		op.Value = encoded
+	}
+
+	if err := restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
+		written := 0
+		for _, op := range payload.Ops {
+			if err := applyBatchOp(tx, trail, op); err != nil {
+				return err
+			}
+			written += len(op.Value)
+		}
+		// the ops may go through several buckets
+		traceTx(r, "", len(payload.Ops), written)
+		return nil
+	}); err != nil {
+		switch err {
+		case ErrBatchOp, bolt.ErrBucketNameRequired, bolt.ErrKeyRequired, bolt.ErrKeyTooLarge, bolt.ErrValueTooLarge:
+			logError(r, err, nil)
+			rest.Error(w, err.Error(), http.StatusBadRequest)
+		case ErrBucketMissing, bolt.ErrBucketNotFound:
+			logError(r, err, nil)
+			rest.Error(w, err.Error(), http.StatusNotFound)
+		case bolt.ErrBucketExists, bolt.ErrIncompatibleValue:
+			logError(r, err, nil)
+			rest.Error(w, err.Error(), http.StatusConflict)
+		case ErrAuditBucket:
+			logError(r, err, nil)
+			rest.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			logError(r, ErrBatch, err)
+			rest.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteJson(&BatchResult{Ops: len(payload.Ops)})
+}
+
+func applyBatchOp(tx *bolt.Tx, trail *auditTrail, op *BatchOp) error {
+	switch op.Op {
+	case BatchCreateBucket:
+		trail.bucket(op.Op, op.Bucket, "")
+		_, err := tx.CreateBucket([]byte(op.Bucket))
+		return err
+	case BatchDeleteBucket:
+		trail.bucket(op.Op, op.Bucket, "")
+		if err := tx.DeleteBucket([]byte(op.Bucket)); err != nil {
+			return err
+		}
+		return deleteBlobBuckets(tx, op.Bucket)
+	case BatchPut, BatchDelete:
+	default:
+		return ErrBatchOp
+	}
+
+	bucket := tx.Bucket([]byte(op.Bucket))
+	if bucket == nil {
+		return ErrBucketMissing
+	}
+	existing := bucket.Get([]byte(op.Key))
+	if op.Op == BatchPut {
+		trail.item(op.Op, op.Bucket, []byte(op.Key), existing, op.Value)
+		if err := bucket.Put([]byte(op.Key), op.Value); err != nil {
+			return err
+		}
+	} else {
+		if existing != nil {
+			trail.item(op.Op, op.Bucket, []byte(op.Key), existing, nil)
+		}
+		if err := bucket.Delete([]byte(op.Key)); err != nil {
+			return err
+		}
+	}
+	return deleteBlobChunks(tx, op.Bucket, op.Key)
+}
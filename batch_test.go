@@ -0,0 +1,134 @@
+package boltapi_test
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBatchEndpoint(t *testing.T) {
+	Convey("testing batch endpoint", t, func() {
+		restapi, db := prepDB(t)
+
+		Convey("should apply every operation of a batch", func() {
+			payload := map[string]interface{}{
+				"ops": []map[string]interface{}{
+					{"op": "createBucket", "bucket": "bucket1"},
+					{"op": "put", "bucket": "bucket1", "key": "item1", "value": []byte("raw value")},
+					{"op": "put", "bucket": "bucket1", "key": "item2", "value": []byte("raw value")},
+					{"op": "delete", "bucket": "bucket1", "key": "item2"},
+				},
+			}
+			request := createRequest("POST", "/api/v1/batch", payload, nil)
+			response := NewRecorder()
+			restapi.ApplyBatch(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `{"Ops":4}`)
+
+			request = createRequest("GET", "/api/v1/buckets/bucket1?raw=true", nil, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.GetBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `[{"Key":"item1","Value":"cmF3IHZhbHVl"}]`)
+		})
+
+		Convey("should not apply anything from a failed batch", func() {
+			payload := map[string]interface{}{
+				"ops": []map[string]interface{}{
+					{"op": "createBucket", "bucket": "bucket1"},
+					{"op": "put", "bucket": "bucket2", "key": "item1", "value": []byte("raw value")},
+				},
+			}
+			request := createRequest("POST", "/api/v1/batch", payload, nil)
+			response := NewRecorder()
+			restapi.ApplyBatch(response, request)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+			So(response.Body.String(), ShouldEqual, `{"Error":"bucket doesn't exist"}`)
+
+			request = createRequest("GET", "/api/v1/buckets", nil, nil)
+			response = NewRecorder()
+			restapi.ListBuckets(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `[]`)
+		})
+
+		Convey("should report failed operations with their status", func() {
+			batches := []struct {
+				code int
+				ops  []map[string]interface{}
+			}{
+				{http.StatusBadRequest, []map[string]interface{}{
+					{"op": "rename", "bucket": "bucket1"},
+				}},
+				{http.StatusBadRequest, []map[string]interface{}{
+					{"op": "createBucket", "bucket": "bucket1"},
+					{"op": "put", "bucket": "bucket1", "key": "", "value": []byte("raw value")},
+				}},
+				{http.StatusNotFound, []map[string]interface{}{
+					{"op": "deleteBucket", "bucket": "bucket1"},
+				}},
+				{http.StatusConflict, []map[string]interface{}{
+					{"op": "createBucket", "bucket": "bucket1"},
+					{"op": "createBucket", "bucket": "bucket1"},
+				}},
+			}
+			for _, batch := range batches {
+				request := createRequest("POST", "/api/v1/batch", map[string]interface{}{"ops": batch.ops}, nil)
+				response := NewRecorder()
+				restapi.ApplyBatch(response, request)
+				So(response.Code, ShouldEqual, batch.code)
+			}
+		})
+
+		Convey("should store json values the way their bucket does", func() {
+			keyring, err := boltapi.NewKeyring("key1", map[string][]byte{"key1": bytes.Repeat([]byte{1}, 32)})
+			So(err, ShouldBeNil)
+			restapi, err := boltapi.NewRestApi(db, boltapi.Encryption(keyring, "pii"))
+			So(err, ShouldBeNil)
+
+			payload := map[string]interface{}{
+				"ops": []map[string]interface{}{
+					{"op": "createBucket", "bucket": "pii"},
+					{"op": "put", "bucket": "pii", "key": "item1", "json": map[string]string{"name": "apple"}},
+				},
+			}
+			request := createRequest("POST", "/api/v1/batch", payload, nil)
+			response := NewRecorder()
+			restapi.ApplyBatch(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			var stored []byte
+			So(db.View(func(tx *bolt.Tx) error {
+				stored = append(stored, tx.Bucket([]byte("pii")).Get([]byte("item1"))...)
+				return nil
+			}), ShouldBeNil)
+			So(string(stored), ShouldNotContainSubstring, "apple")
+
+			request = createRequest("GET", "/api/v1/buckets/pii/item1", nil, map[string]string{"name": "pii", "key": "item1"})
+			response = NewRecorder()
+			restapi.GetBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `{"name":"apple"}`)
+
+			// raw values would be stored in the clear
+			payload = map[string]interface{}{
+				"ops": []map[string]interface{}{
+					{"op": "put", "bucket": "pii", "key": "item2", "value": []byte(`"apple"`)},
+				},
+			}
+			request = createRequest("POST", "/api/v1/batch", payload, nil)
+			response = NewRecorder()
+			restapi.ApplyBatch(response, request)
+			So(response.Code, ShouldEqual, http.StatusBadRequest)
+			So(response.Body.String(), ShouldContainSubstring, boltapi.ErrBatchValue.Error())
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
@@ -0,0 +1,758 @@
+package boltapi
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/boltdb/bolt"
+)
+
+// BlobBucket names the bucket holding the chunks of blobs, in a bucket per
+// bucket of items and then per key. The leading NUL byte keeps it clear of
+// the names buckets are given.
+const BlobBucket = "\x00blobs"
+
+// DefaultBlobChunkSize is the size of the chunks blobs are split into
+// unless BlobChunkSize says otherwise.
+const DefaultBlobChunkSize = 256 << 10
+
+// blobChunksPerTx is the number of chunks written per transaction while
+// uploading, bounding what's lost when an upload is interrupted.
+const blobChunksPerTx = 16
+
+// BlobHashHeader holds the hex SHA-256 of blobs, checked on uploads.
+const BlobHashHeader = "X-Content-Sha256"
+
+var (
+	ErrBlobMissing      = errors.New("blob doesn't exist")
+	ErrBlobIncomplete   = errors.New("blob upload isn't complete")
+	ErrBlobRange        = errors.New("invalid Content-Range")
+	ErrBlobOffset       = errors.New("upload doesn't start where the stored blob ends")
+	ErrBlobHash         = errors.New("blob doesn't match its hash")
+	ErrBlobTruncated    = errors.New("blob upload ended before its Content-Length")
+	ErrBlobChunkMissing = errors.New("blob chunk is missing")
+	ErrBlobChanged      = errors.New("blob changed while it was read")
+	ErrBlobStore        = errors.New("error storing blob")
+	ErrBlobGet          = errors.New("error retrieving blob")
+	ErrBlobDelete       = errors.New("error deleting blob")
+)
+
+// BlobManifest is stored as the value of blob items, so listings show it
+// instead of the content, which is split into chunks of ChunkSize bytes.
+type BlobManifest struct {
+	// Blob tells manifests apart from other values
+	Blob        bool
+	Size        int64
+	ChunkSize   int
+	ContentType string
+	Complete    bool
+	// Sha256 is the hex hash of the content once complete
+	Sha256 string `json:",omitempty"`
+	// Encrypted chunks are encrypted with the bucket's keyring
+	Encrypted bool `json:",omitempty"`
+	Updated   time.Time
+}
+
+// blobStateKey holds the hash state of incomplete uploads next to their
+// chunks, so hashing resumes where they stopped. It's shorter than the
+// chunk keys.
+var blobStateKey = []byte("state")
+
+// isBlobBucket tells whether name is the one of the chunk bucket, which
+// users can't create or reach.
+func isBlobBucket(name string) bool {
+	return name == BlobBucket
+}
+
+// protectBlobs answers 400 Bad Request to the requests going through the
+// chunk bucket.
+func protectBlobs(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		if isBlobBucket(strings.TrimSpace(r.PathParam("name"))) {
+			logError(r, ErrBucketBlobName, nil)
+			rest.Error(w, ErrBucketBlobName.Error(), http.StatusBadRequest)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// blobBuckets returns the bucket holding the chunks of the blobs of a
+// bucket, nil when there's none.
+func blobBuckets(tx *bolt.Tx, bucketName string) *bolt.Bucket {
+	blobs := tx.Bucket([]byte(BlobBucket))
+	if blobs == nil {
+		return nil
+	}
+	return blobs.Bucket([]byte(bucketName))
+}
+
+// createBlobBuckets returns the bucket holding the chunks of the blobs of a
+// bucket, creating it when needed.
+func createBlobBuckets(tx *bolt.Tx, bucketName string) (*bolt.Bucket, error) {
+	blobs, err := tx.CreateBucketIfNotExists([]byte(BlobBucket))
+	if err != nil {
+		return nil, err
+	}
+	return blobs.CreateBucketIfNotExists([]byte(bucketName))
+}
+
+// deleteBlobBuckets deletes the chunks of the blobs of a bucket, if any.
+func deleteBlobBuckets(tx *bolt.Tx, bucketName string) error {
+	blobs := tx.Bucket([]byte(BlobBucket))
+	if blobs == nil || blobs.Bucket([]byte(bucketName)) == nil {
+		return nil
+	}
+	return blobs.DeleteBucket([]byte(bucketName))
+}
+
+func blobChunkKey(index int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(index))
+	return key
+}
+
+// blobChunks returns the bucket holding the chunks of a blob, nil when
+// there's none.
+func blobChunks(tx *bolt.Tx, bucketName, key string) *bolt.Bucket {
+	blobs := blobBuckets(tx, bucketName)
+	if blobs == nil {
+		return nil
+	}
+	return blobs.Bucket([]byte(key))
+}
+
+// deleteBlobChunks deletes the chunks of a blob, if any.
+func deleteBlobChunks(tx *bolt.Tx, bucketName, key string) error {
+	blobs := blobBuckets(tx, bucketName)
+	if blobs == nil || blobs.Bucket([]byte(key)) == nil {
+		return nil
+	}
+	return blobs.DeleteBucket([]byte(key))
+}
+
+// loadManifest reads the manifest stored under key, failing with
+// ErrBlobMissing when the item isn't a blob.
+func (o *options) loadManifest(bucket *bolt.Bucket, bucketName, key string) (*BlobManifest, error) {
+	value := bucket.Get([]byte(key))
+	if value == nil {
+		return nil, ErrBlobMissing
+	}
+	item := new(BucketItem)
+	if err := o.decodeStored(bucketName, item, value); err != nil {
+		return nil, ErrBlobMissing
+	}
+	content, err := json.Marshal(item.Value)
+	if err != nil {
+		return nil, err
+	}
+	manifest := new(BlobManifest)
+	if err := json.Unmarshal(content, manifest); err != nil || !manifest.Blob {
+		return nil, ErrBlobMissing
+	}
+	return manifest, nil
+}
+
+// storeManifest stores the manifest under key like any other value, going
+// through the bucket's codec, compression and encryption.
+func (o *options) storeManifest(bucket *bolt.Bucket, trail *auditTrail, bucketName, key string, manifest *BlobManifest) error {
+	manifest.Updated = time.Now().UTC()
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	item := &BucketItem{Key: key}
+	if err := json.Unmarshal(content, &item.Value); err != nil {
+		return err
+	}
+	encoded, err := o.encodeStored(bucketName, item)
+	if err != nil {
+		return err
+	}
+	trail.item(AuditPut, bucketName, item.EncodeKey(), bucket.Get(item.EncodeKey()), encoded)
+	return bucket.Put(item.EncodeKey(), encoded)
+}
+
+// contentRange is a parsed Content-Range header, total is -1 when unknown.
+type contentRange struct {
+	start, end, total int64
+}
+
+// parseContentRange reads headers like "bytes 0-99/200" or "bytes 0-99/*",
+// returning nil when there's none.
+func parseContentRange(header string) (*contentRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(header, "bytes ") {
+		return nil, ErrBlobRange
+	}
+	parts := strings.SplitN(strings.TrimPrefix(header, "bytes "), "/", 2)
+	bounds := strings.SplitN(parts[0], "-", 2)
+	if len(parts) != 2 || len(bounds) != 2 {
+		return nil, ErrBlobRange
+	}
+
+	var err error
+	result := &contentRange{total: -1}
+	if result.start, err = strconv.ParseInt(bounds[0], 10, 64); err != nil {
+		return nil, ErrBlobRange
+	}
+	if result.end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil {
+		return nil, ErrBlobRange
+	}
+	if parts[1] != "*" {
+		if result.total, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return nil, ErrBlobRange
+		}
+	}
+	if result.start < 0 || result.end < result.start || (result.total >= 0 && result.end >= result.total) {
+		return nil, ErrBlobRange
+	}
+	return result, nil
+}
+
+// blobChunk is a chunk read from an upload, waiting to be stored.
+type blobChunk struct {
+	index int64
+	data  []byte
+}
+
+// PutBlob stores the request body as a blob. Uploads are resumed with a
+// Content-Range starting where the stored blob ends, and are complete once
+// the body of a request without Content-Range or the last range has been
+// received.
+func (restapi *RestApi) PutBlob(w rest.ResponseWriter, r *rest.Request) {
+	fail := func(cusromErr, origErr error) {
+		logError(r, cusromErr, origErr)
+		rest.Error(w, cusromErr.Error(), http.StatusInternalServerError)
+	}
+	failWith := func(err error) {
+		switch err {
+		case ErrBucketMissing:
+			logError(r, err, nil)
+			rest.Error(w, err.Error(), http.StatusNotFound)
+		case ErrBlobOffset:
+			logError(r, err, nil)
+			rest.Error(w, err.Error(), http.StatusConflict)
+		default:
+			fail(ErrBlobStore, err)
+		}
+	}
+
+	bucketName := r.PathParam("name")
+	key := r.PathParam("key")
+	uploadRange, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		logError(r, err, nil)
+		rest.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// starts a new blob or picks up the one being uploaded
+	var manifest *BlobManifest
+	if err := restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil {
+			return ErrBucketMissing
+		}
+		if uploadRange != nil && uploadRange.start > 0 {
+			existing, err := restapi.options.loadManifest(bucket, bucketName, key)
+			if err != nil || existing.Complete || existing.Size != uploadRange.start {
+				return ErrBlobOffset
+			}
+			manifest = existing
+			return nil
+		}
+
+		if err := deleteBlobChunks(tx, bucketName, key); err != nil {
+			return err
+		}
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		manifest = &BlobManifest{
+			Blob:        true,
+			ChunkSize:   restapi.options.blobChunkSize,
+			ContentType: contentType,
+			Encrypted:   restapi.options.encrypted[bucketName],
+		}
+		return restapi.options.storeManifest(bucket, trail, bucketName, key, manifest)
+	}); err != nil {
+		failWith(err)
+		return
+	}
+
+	received := &uploadReader{body: r.Body}
+	body := io.Reader(received)
+	if uploadRange != nil {
+		body = io.LimitReader(received, uploadRange.end-uploadRange.start+1)
+	}
+
+	// resumed uploads pick up the hash where it stopped, and fill up the
+	// last chunk stored first when it's partial
+	hash := sha256.New()
+	chunkSize := int64(manifest.ChunkSize)
+	index := manifest.Size / chunkSize
+	chunk := make([]byte, 0, chunkSize)
+	if manifest.Size > 0 {
+		if err := restapi.view(r, func(tx *bolt.Tx) error {
+			chunks := blobChunks(tx, bucketName, key)
+			if chunks == nil || chunks.Get(blobStateKey) == nil {
+				return ErrBlobChunkMissing
+			}
+			state, err := decryptChunk(manifest, restapi.options.keyring, chunks.Get(blobStateKey))
+			if err != nil {
+				return err
+			}
+			if err := hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+				return err
+			}
+			if manifest.Size%chunkSize == 0 {
+				return nil
+			}
+			if chunks.Get(blobChunkKey(index)) == nil {
+				return ErrBlobChunkMissing
+			}
+			data, err := decryptChunk(manifest, restapi.options.keyring, chunks.Get(blobChunkKey(index)))
+			chunk = append(chunk, data...)
+			return err
+		}); err != nil {
+			fail(ErrBlobStore, err)
+			return
+		}
+	}
+
+	storedSize := manifest.Size
+	pending := []blobChunk{}
+	for {
+		n, readErr := io.ReadFull(body, chunk[len(chunk):cap(chunk)])
+		hash.Write(chunk[len(chunk) : len(chunk)+n])
+		chunk = chunk[:len(chunk)+n]
+		manifest.Size += int64(n)
+
+		ended := readErr == io.EOF || readErr == io.ErrUnexpectedEOF
+		if readErr != nil && !ended {
+			logError(r, ErrBlobStore, readErr)
+		}
+		if len(chunk) == cap(chunk) || (readErr != nil && len(chunk) > 0) {
+			pending = append(pending, blobChunk{index, append([]byte(nil), chunk...)})
+		}
+		if len(chunk) == cap(chunk) {
+			chunk = chunk[:0]
+			index++
+		}
+
+		if ended {
+			// bodies cut short end the same way, they leave the blob
+			// incomplete for the client to resume
+			complete := uploadRange == nil || manifest.Size == uploadRange.total
+			if received.truncated(r.ContentLength) {
+				logError(r, ErrBlobTruncated, received.err)
+				complete = false
+			}
+			if complete {
+				manifest.Complete = true
+				manifest.Sha256 = hex.EncodeToString(hash.Sum(nil))
+			}
+			if expected := r.Header.Get(BlobHashHeader); complete && expected != "" && !strings.EqualFold(expected, manifest.Sha256) {
+				restapi.deleteBlob(r, bucketName, key)
+				logError(r, ErrBlobHash, nil)
+				rest.Error(w, ErrBlobHash.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if len(pending) == blobChunksPerTx || readErr != nil {
+			if err := restapi.storeBlobChunks(r, bucketName, key, manifest, hash, storedSize, pending); err != nil {
+				failWith(err)
+				return
+			}
+			storedSize = manifest.Size
+			pending = pending[:0]
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	w.WriteJson(manifest)
+}
+
+// uploadReader counts the bytes of an upload body, keeping the error it
+// failed with besides io.EOF.
+type uploadReader struct {
+	body io.Reader
+	n    int64
+	err  error
+}
+
+func (reader *uploadReader) Read(p []byte) (int, error) {
+	n, err := reader.body.Read(p)
+	reader.n += int64(n)
+	if err != nil && err != io.EOF && reader.err == nil {
+		reader.err = err
+	}
+	return n, err
+}
+
+// truncated tells whether the body ended before its Content-Length, or on
+// an error like a client disconnecting.
+func (reader *uploadReader) truncated(contentLength int64) bool {
+	return reader.err != nil || (contentLength >= 0 && reader.n < contentLength)
+}
+
+// storeBlobChunks writes chunks along with the manifest, making sure no
+// other upload went on with the blob since storedSize was stored.
+func (restapi *RestApi) storeBlobChunks(r *rest.Request, bucketName, key string, manifest *BlobManifest, hash hash.Hash, storedSize int64, chunks []blobChunk) error {
+	var state []byte
+	if !manifest.Complete {
+		var err error
+		if state, err = hash.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+			return err
+		}
+	}
+
+	return restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil {
+			return ErrBucketMissing
+		}
+		stored, err := restapi.options.loadManifest(bucket, bucketName, key)
+		if err != nil || stored.Complete || stored.Size != storedSize {
+			return ErrBlobOffset
+		}
+
+		blobs, err := createBlobBuckets(tx, bucketName)
+		if err != nil {
+			return err
+		}
+		blob, err := blobs.CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			data, err := encryptChunk(manifest, restapi.options.keyring, chunk.data)
+			if err != nil {
+				return err
+			}
+			if err := blob.Put(blobChunkKey(chunk.index), data); err != nil {
+				return err
+			}
+		}
+		if manifest.Complete {
+			err = blob.Delete(blobStateKey)
+		} else if state, err = encryptChunk(manifest, restapi.options.keyring, state); err == nil {
+			err = blob.Put(blobStateKey, state)
+		}
+		if err != nil {
+			return err
+		}
+		return restapi.options.storeManifest(bucket, trail, bucketName, key, manifest)
+	})
+}
+
+func encryptChunk(manifest *BlobManifest, keyring *Keyring, data []byte) ([]byte, error) {
+	if !manifest.Encrypted {
+		return data, nil
+	}
+	if keyring == nil {
+		return nil, ErrKeyMissing
+	}
+	return keyring.encrypt(data)
+}
+
+func decryptChunk(manifest *BlobManifest, keyring *Keyring, data []byte) ([]byte, error) {
+	if !manifest.Encrypted {
+		return data, nil
+	}
+	return decrypt(keyring, data)
+}
+
+// reencryptBlobs encrypts the chunks of the blobs of the bucket with the
+// primary key, batchSize chunks per transaction, calling batch after each
+// with the number of chunks rewritten. The chunks of blobs stored before
+// the bucket was encrypted are all encrypted in one transaction, along with
+// their manifest.
+func (o *options) reencryptBlobs(db *bolt.DB, bucketName string, batchSize int, trail *auditTrail, batch func(chunks int)) error {
+	if batchSize <= 0 {
+		batchSize = DefaultTruncateBatchSize
+	}
+	var keys [][]byte
+	if err := db.View(func(tx *bolt.Tx) error {
+		if blobs := blobBuckets(tx, bucketName); blobs != nil {
+			return blobs.ForEach(func(k, v []byte) error {
+				if v == nil {
+					keys = append(keys, append([]byte(nil), k...))
+				}
+				return nil
+			})
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		var next []byte
+		for done := false; !done; {
+			rewritten := 0
+			batchTrail := trail.fork()
+			if err := db.Update(func(tx *bolt.Tx) error {
+				batchTrail = trail.fork()
+				bucket := tx.Bucket([]byte(bucketName))
+				if bucket == nil {
+					return ErrBucketMissing
+				}
+				chunks := blobChunks(tx, bucketName, string(key))
+				manifest, err := o.loadManifest(bucket, bucketName, string(key))
+				if chunks == nil || err == ErrBlobMissing {
+					done = true
+					return nil
+				} else if err != nil {
+					return err
+				}
+
+				var indexes, values [][]byte
+				c := chunks.Cursor()
+				k, v := c.First()
+				if next != nil {
+					k, v = c.Seek(next)
+				}
+				for ; k != nil; k, v = c.Next() {
+					if manifest.Encrypted && len(indexes) == batchSize {
+						next = append([]byte(nil), k...)
+						break
+					}
+					if id, _ := encryptionKeyId(v); manifest.Encrypted && id == o.keyring.primary {
+						continue
+					}
+					data, err := decryptChunk(manifest, o.keyring, v)
+					if err != nil {
+						return err
+					}
+					if data, err = o.keyring.encrypt(data); err != nil {
+						return err
+					}
+					indexes = append(indexes, append([]byte(nil), k...))
+					values = append(values, data)
+				}
+				done = k == nil
+
+				for i, k := range indexes {
+					if err := chunks.Put(k, values[i]); err != nil {
+						return err
+					}
+				}
+				rewritten = len(indexes)
+				if rewritten > 0 {
+					batchTrail.item(AuditReencrypt, bucketName, key, nil, nil)
+				}
+				if !manifest.Encrypted {
+					manifest.Encrypted = true
+					if err := o.storeManifest(bucket, batchTrail, bucketName, string(key), manifest); err != nil {
+						return err
+					}
+				}
+				return batchTrail.store(tx)
+			}); err != nil {
+				return err
+			}
+			batchTrail.write()
+			if rewritten > 0 {
+				batch(rewritten)
+			}
+		}
+	}
+	return nil
+}
+
+// blobReader reads the chunks of a blob a transaction at a time, so slow
+// clients don't keep one open for the whole download. The manifest is read
+// again with every chunk, failing with ErrBlobChanged once the blob was
+// uploaded again.
+type blobReader struct {
+	view       func(fn func(tx *bolt.Tx) error) error
+	options    *options
+	bucketName string
+	key        string
+	manifest   *BlobManifest
+	offset     int64
+
+	// the last chunk read, copied out of the transaction
+	index int64
+	chunk []byte
+}
+
+func (reader *blobReader) Read(p []byte) (int, error) {
+	if reader.offset >= reader.manifest.Size {
+		return 0, io.EOF
+	}
+	chunkSize := int64(reader.manifest.ChunkSize)
+	index := reader.offset / chunkSize
+	if reader.chunk == nil || reader.index != index {
+		if err := reader.view(func(tx *bolt.Tx) error {
+			return reader.load(tx, index)
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	start := reader.offset % chunkSize
+	if start >= int64(len(reader.chunk)) {
+		return 0, ErrBlobChunkMissing
+	}
+	n := copy(p, reader.chunk[start:])
+	reader.offset += int64(n)
+	return n, nil
+}
+
+// load reads the chunk at index, provided the blob is still the one whose
+// manifest the reader has.
+func (reader *blobReader) load(tx *bolt.Tx, index int64) error {
+	bucket := tx.Bucket([]byte(reader.bucketName))
+	if bucket == nil {
+		return ErrBlobChanged
+	}
+	manifest, err := reader.options.loadManifest(bucket, reader.bucketName, reader.key)
+	if err != nil || manifest.Sha256 != reader.manifest.Sha256 || !manifest.Updated.Equal(reader.manifest.Updated) {
+		return ErrBlobChanged
+	}
+	chunks := blobChunks(tx, reader.bucketName, reader.key)
+	if chunks == nil || chunks.Get(blobChunkKey(index)) == nil {
+		return ErrBlobChunkMissing
+	}
+	data, err := decryptChunk(manifest, reader.options.keyring, chunks.Get(blobChunkKey(index)))
+	if err != nil {
+		return err
+	}
+	reader.index, reader.chunk = index, append(reader.chunk[:0], data...)
+	return nil
+}
+
+// checkBlobChunks makes sure every chunk of the blob is there, so a
+// response isn't cut short once its headers are sent.
+func checkBlobChunks(chunks *bolt.Bucket, manifest *BlobManifest) error {
+	chunkSize := int64(manifest.ChunkSize)
+	count := (manifest.Size + chunkSize - 1) / chunkSize
+	for index := int64(0); index < count; index++ {
+		if chunks == nil || chunks.Get(blobChunkKey(index)) == nil {
+			return ErrBlobChunkMissing
+		}
+	}
+	return nil
+}
+
+func (reader *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.manifest.Size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	reader.offset = offset
+	return offset, nil
+}
+
+// GetBlob writes the content of a complete blob, honoring Range headers.
+// Chunks are read as the client receives them, each in its own
+// transaction.
+func (restapi *RestApi) GetBlob(w rest.ResponseWriter, r *rest.Request) {
+	bucketName := r.PathParam("name")
+	key := r.PathParam("key")
+	var manifest *BlobManifest
+	if err := restapi.view(r, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil {
+			return ErrBucketMissing
+		}
+		var err error
+		if manifest, err = restapi.options.loadManifest(bucket, bucketName, key); err != nil {
+			return err
+		}
+		if !manifest.Complete {
+			return ErrBlobIncomplete
+		}
+		return checkBlobChunks(blobChunks(tx, bucketName, key), manifest)
+	}); err != nil {
+		logError(r, err, nil)
+		switch err {
+		case ErrBucketMissing, ErrBlobMissing:
+			rest.Error(w, err.Error(), http.StatusNotFound)
+		case ErrBlobIncomplete:
+			rest.Error(w, err.Error(), http.StatusConflict)
+		default:
+			rest.Error(w, ErrBlobGet.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", manifest.ContentType)
+	w.Header().Set("ETag", `"`+manifest.Sha256+`"`)
+	w.Header().Set(BlobHashHeader, manifest.Sha256)
+	http.ServeContent(w.(http.ResponseWriter), r.Request, "", manifest.Updated, &blobReader{
+		view: func(fn func(tx *bolt.Tx) error) error {
+			if err := restapi.view(r, fn); err != nil {
+				// too late to report it, the response is cut short
+				logError(r, ErrBlobGet, err)
+				return err
+			}
+			return nil
+		},
+		options:    restapi.options,
+		bucketName: bucketName,
+		key:        key,
+		manifest:   manifest,
+	})
+}
+
+// DeleteBlob deletes a blob along with its chunks.
+func (restapi *RestApi) DeleteBlob(w rest.ResponseWriter, r *rest.Request) {
+	if err := restapi.deleteBlob(r, r.PathParam("name"), r.PathParam("key")); err != nil {
+		logError(r, ErrBlobDelete, err)
+		switch err {
+		case ErrBucketMissing, ErrBlobMissing:
+			rest.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			rest.Error(w, ErrBlobDelete.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func (restapi *RestApi) deleteBlob(r *rest.Request, bucketName, key string) error {
+	return restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil {
+			return ErrBucketMissing
+		}
+		if _, err := restapi.options.loadManifest(bucket, bucketName, key); err != nil {
+			return err
+		}
+		trail.item(AuditDelete, bucketName, []byte(key), bucket.Get([]byte(key)), nil)
+		if err := bucket.Delete([]byte(key)); err != nil {
+			return err
+		}
+		return deleteBlobChunks(tx, bucketName, key)
+	})
+}
+
+// isBlobUpload tells whether the request uploads a blob, which isn't held
+// to the body size limit as it's stored chunk by chunk.
+func isBlobUpload(r *rest.Request) bool {
+	segments := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	return r.Method == "PUT" && len(segments) == 5 &&
+		segments[0] == "v1" && segments[1] == "buckets" && segments[4] == "blob"
+}
@@ -0,0 +1,357 @@
+package boltapi_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBlobs(t *testing.T) {
+	Convey("testing blob storage", t, func() {
+		_, db := prepDB(t)
+		So(db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucket([]byte("files"))
+			return err
+		}), ShouldBeNil)
+
+		newHandler := func(opts ...boltapi.Option) http.Handler {
+			opts = append([]boltapi.Option{boltapi.BlobChunkSize(10), boltapi.MaxBodySize(16)}, opts...)
+			restapi, err := boltapi.NewRestApi(db, opts...)
+			So(err, ShouldBeNil)
+			return restapi.GetHandler()
+		}
+		handler := newHandler()
+		manifest := func(response *httptest.ResponseRecorder) *boltapi.BlobManifest {
+			manifest := new(boltapi.BlobManifest)
+			So(json.Unmarshal(response.Body.Bytes(), manifest), ShouldBeNil)
+			return manifest
+		}
+		chunkBucket := func(tx *bolt.Tx) *bolt.Bucket {
+			if blobs := tx.Bucket([]byte(boltapi.BlobBucket)); blobs != nil {
+				return blobs.Bucket([]byte("files"))
+			}
+			return nil
+		}
+		chunks := func(key string) int {
+			count := 0
+			db.View(func(tx *bolt.Tx) error {
+				if blobs := chunkBucket(tx); blobs != nil && blobs.Bucket([]byte(key)) != nil {
+					count = blobs.Bucket([]byte(key)).Stats().KeyN
+				}
+				return nil
+			})
+			return count
+		}
+
+		content := []byte(strings.Repeat("0123456789abcdefghijklmnopqrstuvwxyz", 5))
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:])
+
+		Convey("should store blobs in chunks", func() {
+			response := serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), map[string]string{
+				"Content-Type":         "text/plain",
+				boltapi.BlobHashHeader: hash,
+			})
+			So(response.Code, ShouldEqual, http.StatusOK)
+			uploaded := manifest(response)
+			So(uploaded.Complete, ShouldBeTrue)
+			So(uploaded.Size, ShouldEqual, len(content))
+			So(uploaded.Sha256, ShouldEqual, hash)
+			So(chunks("letters"), ShouldEqual, 18)
+
+			response = serve(handler, "GET", "/v1/buckets/files/letters/blob", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Header().Get("Content-Type"), ShouldEqual, "text/plain")
+			So(response.Header().Get("ETag"), ShouldEqual, `"`+hash+`"`)
+			So(response.Body.Bytes(), ShouldResemble, content)
+
+			// listings show the manifest only
+			response = serve(handler, "GET", "/v1/buckets/files", nil, nil)
+			So(response.Body.String(), ShouldContainSubstring, hash)
+			So(response.Body.String(), ShouldNotContainSubstring, "0123456789")
+		})
+
+		Convey("should serve byte ranges", func() {
+			So(serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), nil).Code, ShouldEqual, http.StatusOK)
+
+			response := serve(handler, "GET", "/v1/buckets/files/letters/blob", nil, map[string]string{"Range": "bytes=5-24"})
+			So(response.Code, ShouldEqual, http.StatusPartialContent)
+			So(response.Header().Get("Content-Range"), ShouldEqual, fmt.Sprintf("bytes 5-24/%d", len(content)))
+			So(response.Body.Bytes(), ShouldResemble, content[5:25])
+
+			response = serve(handler, "GET", "/v1/buckets/files/letters/blob", nil, map[string]string{"Range": "bytes=-3"})
+			So(response.Body.Bytes(), ShouldResemble, content[len(content)-3:])
+
+			response = serve(handler, "GET", "/v1/buckets/files/letters/blob", nil, map[string]string{"Range": "bytes=1000-"})
+			So(response.Code, ShouldEqual, http.StatusRequestedRangeNotSatisfiable)
+		})
+
+		Convey("should leave uploads cut short incomplete", func() {
+			request := newRequest("PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content[:25]), nil)
+			request.ContentLength = int64(len(content))
+			response := serveRequest(handler, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(manifest(response).Complete, ShouldBeFalse)
+			So(manifest(response).Size, ShouldEqual, 25)
+
+			response = serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content[25:]), map[string]string{
+				"Content-Range": fmt.Sprintf("bytes 25-%d/%d", len(content)-1, len(content)),
+			})
+			So(manifest(response).Complete, ShouldBeTrue)
+			So(manifest(response).Sha256, ShouldEqual, hash)
+		})
+
+		Convey("should resume uploads", func() {
+			total := len(content)
+			response := serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content[:25]), map[string]string{
+				"Content-Range": fmt.Sprintf("bytes 0-24/%d", total),
+			})
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(manifest(response).Complete, ShouldBeFalse)
+			So(manifest(response).Size, ShouldEqual, 25)
+
+			response = serve(handler, "GET", "/v1/buckets/files/letters/blob", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusConflict)
+			response = serve(handler, "GET", "/v1/buckets/files/letters", nil, nil)
+			So(response.Body.String(), ShouldNotContainSubstring, "State")
+
+			// not where the stored blob ends
+			response = serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content[30:]), map[string]string{
+				"Content-Range": fmt.Sprintf("bytes 30-%d/%d", total-1, total),
+			})
+			So(response.Code, ShouldEqual, http.StatusConflict)
+
+			response = serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content[25:]), map[string]string{
+				"Content-Range":        fmt.Sprintf("bytes 25-%d/%d", total-1, total),
+				boltapi.BlobHashHeader: hash,
+			})
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(manifest(response).Complete, ShouldBeTrue)
+			So(manifest(response).Sha256, ShouldEqual, hash)
+
+			response = serve(handler, "GET", "/v1/buckets/files/letters/blob", nil, nil)
+			So(response.Body.Bytes(), ShouldResemble, content)
+
+			response = serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), map[string]string{
+				"Content-Range": "bytes 10-5/*",
+			})
+			So(response.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("should reject blobs not matching their hash", func() {
+			response := serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), map[string]string{
+				boltapi.BlobHashHeader: strings.Repeat("0", 64),
+			})
+			So(response.Code, ShouldEqual, http.StatusBadRequest)
+			So(serve(handler, "GET", "/v1/buckets/files/letters/blob", nil, nil).Code, ShouldEqual, http.StatusNotFound)
+			So(chunks("letters"), ShouldEqual, 0)
+		})
+
+		Convey("should delete blobs with their chunks", func() {
+			So(serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), nil).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "DELETE", "/v1/buckets/files/letters/blob", nil, nil).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "GET", "/v1/buckets/files/letters", nil, nil).Code, ShouldEqual, http.StatusInternalServerError)
+			So(chunks("letters"), ShouldEqual, 0)
+			So(serve(handler, "DELETE", "/v1/buckets/files/letters/blob", nil, nil).Code, ShouldEqual, http.StatusNotFound)
+
+			So(serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), nil).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "DELETE", "/v1/buckets/files/letters", nil, nil).Code, ShouldEqual, http.StatusOK)
+			So(chunks("letters"), ShouldEqual, 0)
+
+			So(serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), nil).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "DELETE", "/v1/buckets/files", nil, nil).Code, ShouldEqual, http.StatusOK)
+			So(db.View(func(tx *bolt.Tx) error {
+				if chunkBucket(tx) != nil {
+					return fmt.Errorf("chunks left")
+				}
+				return nil
+			}), ShouldBeNil)
+		})
+
+		Convey("should drop the chunks of blobs overwritten or truncated", func() {
+			handler = newHandler(boltapi.MaxBodySize(128))
+			put := func() {
+				So(serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), nil).Code, ShouldEqual, http.StatusOK)
+				So(chunks("letters"), ShouldEqual, len(content)/10)
+			}
+
+			put()
+			So(serve(handler, "PUT", "/v1/buckets/files/letters", strings.NewReader(`"apple"`), nil).Code, ShouldEqual, http.StatusOK)
+			So(chunks("letters"), ShouldEqual, 0)
+
+			put()
+			So(serve(handler, "POST", "/v1/buckets/files?upsert=true", strings.NewReader(`{"Key": "letters", "Value": "apple"}`), nil).Code, ShouldEqual, http.StatusOK)
+			So(chunks("letters"), ShouldEqual, 0)
+
+			put()
+			So(serve(handler, "POST", "/v1/batch", strings.NewReader(`{"Ops": [{"Op": "put", "Bucket": "files", "Key": "letters", "Json": "apple"}]}`), nil).Code, ShouldEqual, http.StatusOK)
+			So(chunks("letters"), ShouldEqual, 0)
+
+			put()
+			So(serve(handler, "POST", "/v1/buckets/files/truncate", nil, nil).Code, ShouldEqual, http.StatusOK)
+			So(chunks("letters"), ShouldEqual, 0)
+		})
+
+		Convey("should keep chunk buckets out of reach", func() {
+			handler = newHandler(boltapi.MaxBodySize(128))
+			So(serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), nil).Code, ShouldEqual, http.StatusOK)
+			names := []string{}
+			So(json.Unmarshal(serve(handler, "GET", "/v1/buckets", nil, nil).Body.Bytes(), &names), ShouldBeNil)
+			So(names, ShouldResemble, []string{"files"})
+			So(serve(handler, "GET", "/v1/buckets?full=true", nil, nil).Body.String(), ShouldNotContainSubstring, "blobs")
+
+			for _, response := range []*httptest.ResponseRecorder{
+				serve(handler, "GET", "/v1/buckets/%00blobs", nil, nil),
+				serve(handler, "PUT", "/v1/buckets/%00blobs/letters", strings.NewReader(`"apple"`), nil),
+				serve(handler, "POST", "/v1/buckets", strings.NewReader(`{"name": "\u0000blobs"}`), nil),
+				serve(handler, "POST", "/v1/batch", strings.NewReader(`{"Ops": [{"Op": "createBucket", "Bucket": "\u0000blobs"}]}`), nil),
+			} {
+				So(response.Code, ShouldEqual, http.StatusBadRequest)
+				So(response.Body.String(), ShouldContainSubstring, boltapi.ErrBucketBlobName.Error())
+			}
+			response := serve(handler, "POST", "/v1/buckets/files/copy", strings.NewReader(`{"name": "\u0000blobs"}`), nil)
+			So(response.Body.String(), ShouldContainSubstring, boltapi.ErrBucketDestination.Error())
+
+			// names that used to be reserved are fine
+			So(serve(handler, "POST", "/v1/buckets", strings.NewReader(`{"name": "docs.blobs"}`), nil).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "PUT", "/v1/buckets/docs.blobs/letters", strings.NewReader(`"apple"`), nil).Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("should transfer blobs with their bucket", func() {
+			handler = newHandler(boltapi.MaxBodySize(64))
+			So(serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), nil).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "POST", "/v1/buckets/files/rename", strings.NewReader(`{"name": "docs"}`), nil).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "GET", "/v1/buckets/docs/letters/blob", nil, nil).Body.Bytes(), ShouldResemble, content)
+			So(chunks("letters"), ShouldEqual, 0)
+
+			So(serve(handler, "POST", "/v1/buckets/docs/copy", strings.NewReader(`{"name": "files"}`), nil).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "GET", "/v1/buckets/files/letters/blob", nil, nil).Body.Bytes(), ShouldResemble, content)
+			So(serve(handler, "GET", "/v1/buckets/docs/letters/blob", nil, nil).Body.Bytes(), ShouldResemble, content)
+
+			So(serve(handler, "POST", "/v1/buckets/docs/move", strings.NewReader(`{"name": "archive"}`), nil).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "GET", "/v1/buckets/archive/letters/blob", nil, nil).Body.Bytes(), ShouldResemble, content)
+			So(serve(handler, "GET", "/v1/buckets/docs/letters/blob", nil, nil).Code, ShouldEqual, http.StatusNotFound)
+
+			// overwriting a blob drops its chunks
+			So(serve(handler, "POST", "/v1/buckets/archive/move", strings.NewReader(`{"name": "files"}`), nil).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "GET", "/v1/buckets/files/letters/blob", nil, nil).Body.Bytes(), ShouldResemble, content)
+			So(chunks("letters"), ShouldEqual, len(content)/10)
+
+			response := serve(handler, "POST", "/v1/buckets/files/copy", strings.NewReader(`{"path": ["parent", "files"]}`), nil)
+			So(response.Code, ShouldEqual, http.StatusConflict)
+			So(response.Body.String(), ShouldContainSubstring, boltapi.ErrBucketBlobs.Error())
+		})
+
+		Convey("should not hold a transaction while sending blobs", func() {
+			So(serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), nil).Code, ShouldEqual, http.StatusOK)
+			blocked := &blockingRecorder{
+				ResponseRecorder: httptest.NewRecorder(),
+				started:          make(chan struct{}),
+				release:          make(chan struct{}),
+			}
+			done := make(chan struct{})
+			go func() {
+				handler.ServeHTTP(blocked, httptest.NewRequest("GET", "/v1/buckets/files/letters/blob", nil))
+				close(done)
+			}()
+			<-blocked.started
+
+			// growing the file remaps it, which open transactions hold up
+			grown := make(chan error)
+			go func() {
+				grown <- db.Update(func(tx *bolt.Tx) error {
+					return tx.Bucket([]byte("files")).Put([]byte("large"), make([]byte, 8<<20))
+				})
+			}()
+			select {
+			case err := <-grown:
+				So(err, ShouldBeNil)
+			case <-time.After(5 * time.Second):
+				t.Fatal("write blocked by the download")
+			}
+
+			// the blob uploaded again cuts the download short
+			So(serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content[1:]), nil).Code, ShouldEqual, http.StatusOK)
+			close(blocked.release)
+			<-done
+			So(blocked.Body.Len(), ShouldBeLessThan, len(content))
+		})
+
+		Convey("should fail before sending blobs missing chunks", func() {
+			So(serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), nil).Code, ShouldEqual, http.StatusOK)
+			So(db.Update(func(tx *bolt.Tx) error {
+				return chunkBucket(tx).Bucket([]byte("letters")).Delete(make([]byte, 8))
+			}), ShouldBeNil)
+
+			response := serve(handler, "GET", "/v1/buckets/files/letters/blob", nil, map[string]string{"Range": "bytes=15-34"})
+			So(response.Code, ShouldEqual, http.StatusInternalServerError)
+			So(response.Header().Get(boltapi.BlobHashHeader), ShouldBeEmpty)
+		})
+
+		Convey("should re-encrypt the chunks of blobs", func() {
+			So(serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), nil).Code, ShouldEqual, http.StatusOK)
+			stored := func() []byte {
+				var chunk []byte
+				So(db.View(func(tx *bolt.Tx) error {
+					chunk = append(chunk, chunkBucket(tx).Bucket([]byte("letters")).Get(make([]byte, 8))...)
+					return nil
+				}), ShouldBeNil)
+				return chunk
+			}
+
+			key1, key2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+			keyring, err := boltapi.NewKeyring("1", map[string][]byte{"1": key1})
+			So(err, ShouldBeNil)
+			result, err := boltapi.Reencrypt(db, "files", keyring, 4, nil)
+			So(err, ShouldBeNil)
+			So(result.Chunks, ShouldEqual, len(content)/10)
+			So(bytes.Contains(stored(), content[:10]), ShouldBeFalse)
+
+			keyring, err = boltapi.NewKeyring("2", map[string][]byte{"1": key1, "2": key2})
+			So(err, ShouldBeNil)
+			result, err = boltapi.Reencrypt(db, "files", keyring, 4, nil)
+			So(err, ShouldBeNil)
+			So(result.Chunks, ShouldEqual, len(content)/10)
+
+			keyring, err = boltapi.NewKeyring("2", map[string][]byte{"2": key2})
+			So(err, ShouldBeNil)
+			handler = newHandler(boltapi.Encryption(keyring, "files"))
+			response := serve(handler, "GET", "/v1/buckets/files/letters/blob", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.Bytes(), ShouldResemble, content)
+		})
+
+		Convey("should encrypt the chunks of encrypted buckets", func() {
+			keyring, err := boltapi.NewKeyring("1", map[string][]byte{"1": bytes.Repeat([]byte{1}, 32)})
+			So(err, ShouldBeNil)
+			handler = newHandler(boltapi.Encryption(keyring, "files"))
+
+			So(serve(handler, "PUT", "/v1/buckets/files/letters/blob", bytes.NewReader(content), nil).Code, ShouldEqual, http.StatusOK)
+			So(db.View(func(tx *bolt.Tx) error {
+				chunk := chunkBucket(tx).Bucket([]byte("letters")).Get(make([]byte, 8))
+				if bytes.Contains(chunk, content[:10]) {
+					return fmt.Errorf("chunk stored in clear")
+				}
+				return nil
+			}), ShouldBeNil)
+
+			response := serve(handler, "GET", "/v1/buckets/files/letters/blob", nil, map[string]string{"Range": "bytes=15-34"})
+			So(response.Body.Bytes(), ShouldResemble, content[15:35])
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
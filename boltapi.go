@@ -1,29 +1,25 @@
 package boltapi
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/boltdb/bolt"
 )
 
-var (
-	middlewares = []rest.Middleware{
-		&rest.AccessLogApacheMiddleware{},
-		&rest.TimerMiddleware{},
-		&rest.RecorderMiddleware{},
-		&rest.RecoverMiddleware{
-			EnableResponseStackTrace: true,
-		},
-		&rest.JsonIndentMiddleware{},
-		&rest.ContentTypeCheckerMiddleware{},
-	}
+// NextKeyHeader is set on paginated bucket listings to the url-encoded key
+// the next page starts at.
+const NextKeyHeader = "X-Next-Key"
 
+var (
 	ErrBucketList        = errors.New("error listing buckets")
 	ErrBucketGet         = errors.New("error retrieving bucket")
 	ErrBucketMissing     = errors.New("bucket doesn't exist")
@@ -31,11 +27,40 @@ var (
 	ErrBucketDelete      = errors.New("error deleting bucket")
 	ErrBucketDecodeName  = errors.New("error reading bucket name")
 	ErrBucketInvalidName = errors.New("invalid bucket name")
+	ErrBucketBlobName    = errors.New("bucket name is reserved for blobs")
 	ErrBucketItemDecode  = errors.New("error reading bucket item")
 	ErrBucketItemEncode  = errors.New("error encoding bucket item")
 	ErrBucketItemCreate  = errors.New("error creating bucket item")
 	ErrBucketItemUpdate  = errors.New("error updating bucket item")
 	ErrBucketItemDelete  = errors.New("error deleting bucket item")
+	ErrBucketItemExists  = errors.New("bucket item already exists")
+	ErrBucketItemMissing = errors.New("bucket item doesn't exist")
+	ErrBucketKeysDecode  = errors.New("error reading item keys")
+	ErrBucketPageLimit   = errors.New("invalid page limit")
+	ErrBucketKeyFormat   = errors.New("invalid key format")
+	ErrBucketKeyReserved = errors.New("key is reserved for bucket operations")
+
+	ErrBucketRename      = errors.New("error renaming bucket")
+	ErrBucketCopy        = errors.New("error copying bucket")
+	ErrBucketMove        = errors.New("error moving bucket items")
+	ErrBucketDestination = errors.New("invalid destination bucket")
+	ErrBucketStorage     = errors.New("destination bucket stores values with another codec or encryption")
+	ErrBucketBlobs       = errors.New("blobs can't be transferred to nested buckets")
+
+	ErrBucketTruncate       = errors.New("error truncating bucket")
+	ErrBucketTruncateDecode = errors.New("error reading truncate range")
+	ErrTruncateRunning      = errors.New("bucket is already being truncated")
+	ErrTruncateMissing      = errors.New("bucket hasn't been truncated")
+
+	ErrBucketSequenceDecode = errors.New("error reading bucket sequence")
+	ErrBucketSequenceUpdate = errors.New("error updating bucket sequence")
+
+	ErrStats = errors.New("error collecting stats")
+
+	ErrBatch       = errors.New("error applying batch")
+	ErrBatchDecode = errors.New("error reading batch")
+	ErrBatchOp     = errors.New("invalid batch operation")
+	ErrBatchValue  = errors.New("bucket encodes its values, send them as json")
 )
 
 type ApiError struct {
@@ -53,15 +78,30 @@ func (item *BucketItem) EncodeKey() []byte {
 }
 
 func (item *BucketItem) EncodeValue() ([]byte, error) {
-	buf, err := json.Marshal(item.Value)
+	return item.EncodeValueWith(JsonCodec)
+}
+
+func (item *BucketItem) DecodeValue(rawValue []byte) error {
+	return item.DecodeValueWith(JsonCodec, rawValue)
+}
+
+func (item *BucketItem) EncodeValueWith(codec Codec) ([]byte, error) {
+	buf, err := codec.Marshal(item.Value)
 	if err != nil {
 		return nil, ErrBucketItemEncode
 	}
 	return buf, nil
 }
 
-func (item *BucketItem) DecodeValue(rawValue []byte) error {
-	if err := json.Unmarshal(rawValue, &item.Value); err != nil {
+// DecodeValueWith decodes a value encoded with codec, decompressing it
+// first if it was compressed.
+func (item *BucketItem) DecodeValueWith(codec Codec, rawValue []byte) error {
+	rawValue, err := decompress(rawValue)
+	if err != nil {
+		slog.Error(ErrDecompress.Error(), "error", err.Error())
+		return ErrBucketItemDecode
+	}
+	if err := codec.Unmarshal(rawValue, &item.Value); err != nil {
 		return ErrBucketItemDecode
 	}
 	return nil
@@ -75,25 +115,42 @@ func (err ApiError) Error() string {
 }
 
 type RestApi struct {
-	db  *bolt.DB
-	api *rest.Api
+	db      *bolt.DB
+	api     *rest.Api
+	options *options
+
+	reencryptions reencryptions
+	truncations   truncations
+	limiter       *rateLimiter
+	scans         chan struct{}
+	reserved      map[string]bool
 }
 
-func NewRestApi(db *bolt.DB) (*RestApi, error) {
-	restapi := &RestApi{db: db}
+// NewRestApi serves db with indented JSON responses and a colored access
+// log on stderr unless options say otherwise.
+func NewRestApi(db *bolt.DB, opts ...Option) (*RestApi, error) {
+	restapi := &RestApi{db: db, options: newOptions(opts)}
+	restapi.reserved = reservedKeys(restapi.endpoints())
+	if restapi.options.rateLimits != nil {
+		limiter, err := newRateLimiter(*restapi.options.rateLimits)
+		if err != nil {
+			return nil, err
+		}
+		restapi.limiter = limiter
+	}
+	if restapi.options.maxScans > 0 {
+		restapi.scans = make(chan struct{}, restapi.options.maxScans)
+	}
+
+	routes := restapi.routes()
+	middlewares, err := restapi.options.stack(routes)
+	if err != nil {
+		return nil, err
+	}
 
 	api := rest.NewApi()
 	api.Use(middlewares...)
-	router, err := rest.MakeRouter(
-		rest.Get("/v1/buckets", restapi.ListBuckets),
-		rest.Post("/v1/buckets", restapi.AddBucket),
-		rest.Get("/v1/buckets/:name", restapi.GetBucket),
-		rest.Delete("/v1/buckets/:name", restapi.DeleteBucket),
-		rest.Post("/v1/buckets/:name", restapi.AddBucketItem),
-		rest.Get("/v1/buckets/:name/:key", restapi.GetBucketItem),
-		rest.Put("/v1/buckets/:name/:key", restapi.UpdateBucketItem),
-		rest.Delete("/v1/buckets/:name/:key", restapi.DeleteBucketItem),
-	)
+	router, err := rest.MakeRouter(routes...)
 	if err != nil {
 		return nil, err
 	}
@@ -108,62 +165,105 @@ func Serve(db *bolt.DB, port int) error {
 	if err != nil {
 		return err
 	}
+	return http.ListenAndServe(fmt.Sprintf(":%d", port), restapi.ServeMux())
+}
+
+// ServeMux routes /api/ to the api and /ui/ to the admin UI, as Serve does.
+func (restapi *RestApi) ServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/api/", http.StripPrefix("/api", restapi.GetHandler()))
+	mux.Handle("/ui/", http.StripPrefix("/ui", UIHandler()))
+	return mux
+}
+
+// Use adds middlewares after the default ones. It has to be called before
+// GetHandler.
+func (restapi *RestApi) Use(middlewares ...rest.Middleware) {
+	restapi.api.Use(middlewares...)
+}
+
+// BasicAuth returns a middleware only letting through users authenticating
+// with the password users maps their name to.
+func BasicAuth(realm string, users map[string]string) rest.Middleware {
+	return &rest.AuthBasicMiddleware{
+		Realm: realm,
+		Authenticator: func(user, password string) bool {
+			return checkPassword(users, user, password)
+		},
+	}
+}
 
-	http.Handle("/api/", http.StripPrefix("/api", restapi.api.MakeHandler()))
-	return http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+func checkPassword(users map[string]string, user, password string) bool {
+	expected, ok := users[user]
+	return ok && subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
 }
 
 func (restapi *RestApi) GetHandler() http.Handler {
 	return restapi.api.MakeHandler()
 }
 
+// ListBuckets streams the bucket names, or the buckets with their items
+// when full, as it goes through them.
 func (restapi *RestApi) ListBuckets(w rest.ResponseWriter, r *rest.Request) {
-	fullParam := r.URL.Query().Get("full")
-	full := fullParam == "1" || fullParam == "true"
-
-	bucketNames := []string{}
-	buckets := []map[string]interface{}{}
-
-	if err := restapi.db.View(func(tx *bolt.Tx) error {
-		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
-
-			if full {
-				items := []*BucketItem{}
-				if err := bucket.ForEach(func(k, v []byte) error {
-					bucketItem := &BucketItem{Key: string(k)}
-					bucketItem.DecodeValue(v)
-					items = append(items, bucketItem)
-					return nil
-				}); err != nil {
-					return err
-				}
+	full := queryBool(r, "full", false)
 
-				buckets = append(buckets, map[string]interface{}{
-					"name":  string(name),
-					"items": items,
-				})
-			} else {
-				bucketNames = append(bucketNames, string(name))
+	stream := newJsonStream(w)
+	if err := restapi.view(r, func(tx *bolt.Tx) error {
+		if err := tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			if restapi.options.hiddenBucket(string(name)) {
+				return nil
+			}
+			if !full {
+				return stream.Encode(string(name))
 			}
 
-			return nil
-		})
+			// written as {"items": [...], "name": "..."}, the way WriteJson
+			// orders the keys of a map
+			if err := stream.begin(); err != nil {
+				return err
+			}
+			if err := stream.write("{" + stream.newline(2) + `"items"` + stream.colon()); err != nil {
+				return err
+			}
+			items := stream.nested(2)
+			if err := bucket.ForEach(func(k, v []byte) error {
+				bucketItem := &BucketItem{Key: string(k)}
+				restapi.options.decodeStored(string(name), bucketItem, v)
+				return items.Encode(bucketItem)
+			}); err != nil {
+				return err
+			}
+			if err := items.Close(); err != nil {
+				return err
+			}
+			encodedName, err := json.Marshal(string(name))
+			if err != nil {
+				return err
+			}
+			return stream.write("," + stream.newline(2) + `"name"` + stream.colon() + string(encodedName) + stream.newline(1) + "}")
+		}); err != nil {
+			return err
+		}
+		return stream.Close()
 	}); err != nil {
-		log.Println(ApiError{ErrBucketList, err})
-		rest.Error(w, ErrBucketList.Error(), http.StatusInternalServerError)
+		logError(r, ErrBucketList, err)
+		// too late to report it once the listing started
+		if !stream.written {
+			rest.Error(w, ErrBucketList.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
+}
 
-	if full {
-		w.WriteJson(buckets)
-	} else {
-		w.WriteJson(bucketNames)
-	}
+// hiddenBucket tells whether the bucket is kept out of listings, like the
+// chunk buckets of blobs and the audit bucket.
+func (o *options) hiddenBucket(name string) bool {
+	return isBlobBucket(name) || (o.audit != nil && o.audit.bucket != "" && name == o.audit.bucket)
 }
 
 func (restapi *RestApi) AddBucket(w rest.ResponseWriter, r *rest.Request) {
 	fail := func(cusromErr, origErr error) {
-		log.Println(ApiError{cusromErr, origErr})
+		logError(r, cusromErr, origErr)
 		rest.Error(w, cusromErr.Error(), http.StatusInternalServerError)
 	}
 
@@ -179,11 +279,18 @@ func (restapi *RestApi) AddBucket(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 
-	if err := restapi.db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucket([]byte(strings.TrimSpace(bucketName)))
+	bucketName = strings.TrimSpace(bucketName)
+	if isBlobBucket(bucketName) {
+		logError(r, ErrBucketBlobName, nil)
+		rest.Error(w, ErrBucketBlobName.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
+		trail.bucket(AuditCreateBucket, bucketName, "")
+		_, err := tx.CreateBucket([]byte(bucketName))
 		return err
 	}); err != nil {
-		log.Println(ApiError{ErrBucketCreate, err})
+		logError(r, ErrBucketCreate, err)
 		rest.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -191,38 +298,110 @@ func (restapi *RestApi) AddBucket(w rest.ResponseWriter, r *rest.Request) {
 
 func (restapi *RestApi) GetBucket(w rest.ResponseWriter, r *rest.Request) {
 	bucketName := r.PathParam("name")
-	items := []*BucketItem{}
-	if err := restapi.db.View(func(tx *bolt.Tx) error {
+	query := r.URL.Query()
+	keyRange := &KeyRange{
+		Prefix: query.Get("prefix"),
+		Start:  query.Get("start"),
+		End:    query.Get("end"),
+	}
+
+	limit := 0
+	if query.Get("limit") != "" {
+		var err error
+		if limit, err = strconv.Atoi(query.Get("limit")); err != nil || limit < 0 {
+			logError(r, ErrBucketPageLimit, err)
+			rest.Error(w, ErrBucketPageLimit.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// raw listings return values as stored instead of decoding them
+	raw := queryBool(r, "raw", false)
+	stream := newJsonStream(w)
+	if err := restapi.view(r, func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(bucketName))
 		if bucket == nil {
 			return ErrBucketMissing
 		}
 
-		return bucket.ForEach(func(k, v []byte) error {
-			bucketItem := &BucketItem{Key: string(k)}
-			bucketItem.DecodeValue(v)
-			items = append(items, bucketItem)
-			return nil
-		})
+		// the next page starts at this key, which has to be known before
+		// streaming the items
+		c := bucket.Cursor()
+		if limit > 0 {
+			count := 0
+			for k, _ := keyRange.seek(c); !keyRange.done(k); k, _ = c.Next() {
+				if count == limit {
+					w.Header().Set(NextKeyHeader, url.QueryEscape(string(k)))
+					break
+				}
+				count++
+			}
+		}
+
+		count, read := 0, 0
+		for k, v := keyRange.seek(c); !keyRange.done(k); k, v = c.Next() {
+			if limit > 0 && count == limit {
+				break
+			}
+			count++
+			read += len(v)
+
+			var err error
+			if raw {
+				err = stream.Encode(&RawBucketItem{Key: string(k), Value: v})
+			} else {
+				bucketItem := &BucketItem{Key: string(k)}
+				restapi.options.decodeStored(bucketName, bucketItem, v)
+				err = stream.Encode(bucketItem)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		traceTx(r, bucketName, count, read)
+		return stream.Close()
 	}); err != nil {
-		log.Println(ApiError{ErrBucketGet, err})
-		switch err {
-		case ErrBucketGet:
-			rest.Error(w, ErrBucketGet.Error(), http.StatusInternalServerError)
-		case ErrBucketMissing:
+		logError(r, ErrBucketGet, err)
+		switch {
+		case stream.written:
+			// too late to report it once the listing started
+		case err == ErrBucketMissing:
 			rest.Error(w, ErrBucketMissing.Error(), http.StatusInternalServerError)
+		default:
+			rest.Error(w, ErrBucketGet.Error(), http.StatusInternalServerError)
 		}
 		return
 	}
-	w.WriteJson(items)
+}
+
+func (restapi *RestApi) HeadBucket(w rest.ResponseWriter, r *rest.Request) {
+	bucketName := strings.TrimSpace(r.PathParam("name"))
+	if err := restapi.view(r, func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(bucketName)) == nil {
+			return ErrBucketMissing
+		}
+		return nil
+	}); err != nil {
+		if err == ErrBucketMissing {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logError(r, ErrBucketGet, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
 }
 
 func (restapi *RestApi) DeleteBucket(w rest.ResponseWriter, r *rest.Request) {
 	bucketName := r.PathParam("name")
-	if err := restapi.db.Update(func(tx *bolt.Tx) error {
-		return tx.DeleteBucket([]byte(bucketName))
+	if err := restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
+		trail.bucket(AuditDeleteBucket, bucketName, "")
+		if err := tx.DeleteBucket([]byte(bucketName)); err != nil {
+			return err
+		}
+		// along with the chunks of its blobs
+		return deleteBlobBuckets(tx, bucketName)
 	}); err != nil {
-		log.Println(ApiError{ErrBucketDelete, err})
+		logError(r, ErrBucketDelete, err)
 		rest.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -230,101 +409,250 @@ func (restapi *RestApi) DeleteBucket(w rest.ResponseWriter, r *rest.Request) {
 
 func (restapi *RestApi) AddBucketItem(w rest.ResponseWriter, r *rest.Request) {
 	fail := func(cusromErr, origErr error) {
-		log.Println(ApiError{cusromErr, origErr})
+		logError(r, cusromErr, origErr)
 		rest.Error(w, cusromErr.Error(), http.StatusInternalServerError)
 	}
 
 	bucketName := r.PathParam("name")
+	requestCodec, responseCodec, ok := restapi.negotiate(w, r, bucketName)
+	if !ok {
+		return
+	}
+
+	// bodies other than JSON hold the value alone, with the key in the
+	// query string
 	payload := new(BucketItem)
-	if err := r.DecodeJsonPayload(payload); err != nil {
+	decoded := interface{}(payload)
+	if requestCodec != JsonCodec {
+		payload.Key = r.URL.Query().Get("key")
+		decoded = &payload.Value
+	}
+	if err := decodeValue(r, requestCodec, decoded); err != nil {
 		fail(ErrBucketItemDecode, err)
 		return
 	}
 
-	encodedValue, err := payload.EncodeValue()
+	if restapi.reserved[payload.Key] {
+		logError(r, ErrBucketKeyReserved, nil)
+		rest.Error(w, ErrBucketKeyReserved.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// items posted without a key get the bucket's next sequence
+	generateKey := payload.Key == ""
+	upsert := queryBool(r, "upsert", false)
+	keyFormat := r.URL.Query().Get("keyformat")
+	if generateKey && !validKeyFormat(keyFormat) {
+		fail(ErrBucketKeyFormat, nil)
+		return
+	}
+
+	encodedValue, err := restapi.options.encodeStored(bucketName, payload)
 	if err != nil {
 		fail(err, nil)
 		return
 	}
 
-	if err := restapi.db.Update(func(tx *bolt.Tx) error {
+	if err := restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
 		bucket := tx.Bucket([]byte(strings.TrimSpace(bucketName)))
 		if bucket == nil {
 			return ErrBucketMissing
 		}
-		return bucket.Put(payload.EncodeKey(), encodedValue)
+		if generateKey {
+			key, err := nextSequenceKey(bucket, keyFormat)
+			if err != nil {
+				return err
+			}
+			payload.Key = string(key)
+		}
+		existing := bucket.Get(payload.EncodeKey())
+		if !upsert && existing != nil {
+			return ErrBucketItemExists
+		}
+		traceTx(r, bucketName, 1, len(encodedValue))
+		trail.item(AuditPut, strings.TrimSpace(bucketName), payload.EncodeKey(), existing, encodedValue)
+		if err := bucket.Put(payload.EncodeKey(), encodedValue); err != nil {
+			return err
+		}
+		// the value replaces the blob stored under the key, if any
+		return deleteBlobChunks(tx, strings.TrimSpace(bucketName), string(payload.EncodeKey()))
 	}); err != nil {
-		fail(ErrBucketItemCreate, err)
+		switch err {
+		case ErrBucketItemExists:
+			logError(r, err, nil)
+			rest.Error(w, err.Error(), http.StatusConflict)
+		default:
+			fail(ErrBucketItemCreate, err)
+		}
 		return
 	}
 
-	w.WriteJson(payload.Value)
+	status := 0
+	if generateKey {
+		w.Header().Set("Location", itemLocation(r, payload.EncodeKey()))
+		status = http.StatusCreated
+	}
+	writeValue(w, responseCodec, status, payload.Value)
 }
 
 func (restapi *RestApi) GetBucketItem(w rest.ResponseWriter, r *rest.Request) {
 	bucketName := r.PathParam("name")
 	bucketItemKey := r.PathParam("key")
+	if queryBool(r, "raw", false) {
+		restapi.getRawBucketItem(w, r, bucketName, bucketItemKey)
+		return
+	}
+	_, responseCodec, ok := restapi.negotiate(w, r, bucketName)
+	if !ok {
+		return
+	}
+
 	bucketItem := new(BucketItem)
-	if err := restapi.db.View(func(tx *bolt.Tx) error {
+	if err := restapi.view(r, func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte(strings.TrimSpace(bucketName)))
 		if bucket == nil {
 			return ErrBucketMissing
 		}
 		itemValue := bucket.Get([]byte(bucketItemKey))
-		return bucketItem.DecodeValue(itemValue)
+		traceTx(r, bucketName, 1, len(itemValue))
+		return restapi.options.decodeStored(bucketName, bucketItem, itemValue)
 	}); err != nil {
-		log.Println(ApiError{err, nil})
+		logError(r, err, nil)
 		rest.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.WriteJson(bucketItem.Value)
+	writeValue(w, responseCodec, 0, bucketItem.Value)
+}
+
+// getRawBucketItem writes the value of an item as stored, without copying
+// it out of the transaction.
+func (restapi *RestApi) getRawBucketItem(w rest.ResponseWriter, r *rest.Request, bucketName, key string) {
+	if err := restapi.view(r, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(strings.TrimSpace(bucketName)))
+		if bucket == nil {
+			return ErrBucketMissing
+		}
+		value := bucket.Get([]byte(key))
+		if value == nil {
+			return ErrBucketItemMissing
+		}
+		traceTx(r, bucketName, 1, len(value))
+		writeRawValue(w, value)
+		return nil
+	}); err != nil {
+		logError(r, err, nil)
+		rest.Error(w, err.Error(), http.StatusNotFound)
+	}
+}
+
+func (restapi *RestApi) HeadBucketItem(w rest.ResponseWriter, r *rest.Request) {
+	bucketName := r.PathParam("name")
+	bucketItemKey := r.PathParam("key")
+	if err := restapi.view(r, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(strings.TrimSpace(bucketName)))
+		if bucket == nil {
+			return ErrBucketMissing
+		}
+		if bucket.Get([]byte(bucketItemKey)) == nil {
+			return ErrBucketItemMissing
+		}
+		return nil
+	}); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+	}
 }
 
 func (restapi *RestApi) UpdateBucketItem(w rest.ResponseWriter, r *rest.Request) {
 	fail := func(cusromErr, origErr error) {
-		log.Println(ApiError{cusromErr, origErr})
+		logError(r, cusromErr, origErr)
 		rest.Error(w, cusromErr.Error(), http.StatusInternalServerError)
 	}
 
 	bucketName := r.PathParam("name")
 	bucketItemKey := r.PathParam("key")
+	create := queryBool(r, "create", true)
+	if restapi.reserved[bucketItemKey] {
+		logError(r, ErrBucketKeyReserved, nil)
+		rest.Error(w, ErrBucketKeyReserved.Error(), http.StatusBadRequest)
+		return
+	}
+	requestCodec, responseCodec, ok := restapi.negotiate(w, r, bucketName)
+	if !ok {
+		return
+	}
+
 	payload := &BucketItem{Key: bucketItemKey}
-	if err := r.DecodeJsonPayload(&payload.Value); err != nil {
+	if err := decodeValue(r, requestCodec, &payload.Value); err != nil {
 		fail(ErrBucketItemDecode, err)
 		return
 	}
 
-	encodedValue, err := payload.EncodeValue()
+	encodedValue, err := restapi.options.encodeStored(bucketName, payload)
 	if err != nil {
 		fail(err, nil)
 		return
 	}
 
-	if err := restapi.db.Update(func(tx *bolt.Tx) error {
+	if err := restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
 		bucket := tx.Bucket([]byte(strings.TrimSpace(bucketName)))
 		if bucket == nil {
 			return ErrBucketMissing
 		}
-		return bucket.Put(payload.EncodeKey(), encodedValue)
+		existing := bucket.Get(payload.EncodeKey())
+		if !create && existing == nil {
+			return ErrBucketItemMissing
+		}
+		traceTx(r, bucketName, 1, len(encodedValue))
+		trail.item(AuditPut, strings.TrimSpace(bucketName), payload.EncodeKey(), existing, encodedValue)
+		if err := bucket.Put(payload.EncodeKey(), encodedValue); err != nil {
+			return err
+		}
+		// the value replaces the blob stored under the key, if any
+		return deleteBlobChunks(tx, strings.TrimSpace(bucketName), string(payload.EncodeKey()))
 	}); err != nil {
-		fail(ErrBucketItemUpdate, err)
+		switch err {
+		case ErrBucketItemMissing:
+			logError(r, err, nil)
+			rest.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			fail(ErrBucketItemUpdate, err)
+		}
 		return
 	}
-	w.WriteJson(payload.Value)
+	writeValue(w, responseCodec, 0, payload.Value)
 }
 
 func (restapi *RestApi) DeleteBucketItem(w rest.ResponseWriter, r *rest.Request) {
 	bucketName := r.PathParam("name")
 	bucketItemKey := r.PathParam("key")
-	if err := restapi.db.Update(func(tx *bolt.Tx) error {
+	if err := restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
 		bucket := tx.Bucket([]byte(strings.TrimSpace(bucketName)))
 		if bucket == nil {
 			return ErrBucketMissing
 		}
-		return bucket.Delete([]byte(bucketItemKey))
+		if existing := bucket.Get([]byte(bucketItemKey)); existing != nil {
+			trail.item(AuditDelete, strings.TrimSpace(bucketName), []byte(bucketItemKey), existing, nil)
+		}
+		traceTx(r, bucketName, 1, 0)
+		if err := bucket.Delete([]byte(bucketItemKey)); err != nil {
+			return err
+		}
+		return deleteBlobChunks(tx, bucketName, bucketItemKey)
 	}); err != nil {
-		log.Println(ApiError{ErrBucketItemDelete, err})
+		logError(r, ErrBucketItemDelete, err)
 		rest.Error(w, ErrBucketItemDelete.Error(), http.StatusInternalServerError)
 		return
 	}
 }
+
+// queryBool reads a boolean query param, falling back to def when it's
+// missing or not recognized.
+func queryBool(r *rest.Request, name string, def bool) bool {
+	switch r.URL.Query().Get(name) {
+	case "1", "true":
+		return true
+	case "0", "false":
+		return false
+	}
+	return def
+}
@@ -2,6 +2,7 @@ package boltapi_test
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os/exec"
@@ -163,6 +164,11 @@ func TestBucketEndpoint(t *testing.T) {
 			restapi.AddBucket(response, request)
 			So(response.Code, ShouldEqual, http.StatusOK)
 
+			request = createRequest("HEAD", "/api/v1/buckets/%20bucket1%20", nil, map[string]string{"name": " bucket1 "})
+			response = NewRecorder()
+			restapi.HeadBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
 			request = createRequest("DELETE", "/api/v1/buckets/bucket1", nil, map[string]string{"name": "bucket1"})
 			response = NewRecorder()
 			restapi.DeleteBucket(response, request)
@@ -173,6 +179,17 @@ func TestBucketEndpoint(t *testing.T) {
 			restapi.GetBucket(response, request)
 			So(response.Code, ShouldEqual, http.StatusInternalServerError)
 			So(response.Body.String(), ShouldEqual, `{"Error":"bucket doesn't exist"}`)
+
+			request = createRequest("HEAD", "/api/v1/buckets/bucket1", nil, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.HeadBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+
+			db.Close()
+			request = createRequest("HEAD", "/api/v1/buckets/bucket1", nil, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.HeadBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusInternalServerError)
 		})
 
 		Reset(func() {
@@ -285,6 +302,65 @@ func TestBucketItemEndpoint(t *testing.T) {
 			So(response.Body.String(), ShouldEqual, `{"isRipe":true,"name":"mango","price":4.5}`)
 		})
 
+		Convey("should not overwrite existing bucket item on add", func() {
+			request := createRequest("POST", "/api/v1/buckets", map[string]string{"name": "bucket1"}, nil)
+			response := NewRecorder()
+			restapi.AddBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			payload := map[string]interface{}{"key": "item1", "value": "apple"}
+			request = createRequest("POST", "/api/v1/buckets/bucket1", payload, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.AddBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			payload = map[string]interface{}{"key": "item1", "value": "mango"}
+			request = createRequest("POST", "/api/v1/buckets/bucket1", payload, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.AddBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusConflict)
+			So(response.Body.String(), ShouldEqual, `{"Error":"bucket item already exists"}`)
+
+			request = createRequest("POST", "/api/v1/buckets/bucket1?upsert=true", payload, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.AddBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			request = createRequest("GET", "/api/v1/buckets/bucket1/item1", nil, map[string]string{"name": "bucket1", "key": "item1"})
+			response = NewRecorder()
+			restapi.GetBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `"mango"`)
+		})
+
+		Convey("should not create missing bucket item on update-only", func() {
+			request := createRequest("POST", "/api/v1/buckets", map[string]string{"name": "bucket1"}, nil)
+			response := NewRecorder()
+			restapi.AddBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			request = createRequest("PUT", "/api/v1/buckets/bucket1/item1?create=false", "apple", map[string]string{"name": "bucket1", "key": "item1"})
+			response = NewRecorder()
+			restapi.UpdateBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+			So(response.Body.String(), ShouldEqual, `{"Error":"bucket item doesn't exist"}`)
+
+			request = createRequest("HEAD", "/api/v1/buckets/bucket1/item1", nil, map[string]string{"name": "bucket1", "key": "item1"})
+			response = NewRecorder()
+			restapi.HeadBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+
+			request = createRequest("PUT", "/api/v1/buckets/bucket1/item1", "apple", map[string]string{"name": "bucket1", "key": "item1"})
+			response = NewRecorder()
+			restapi.UpdateBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			request = createRequest("HEAD", "/api/v1/buckets/bucket1/item1", nil, map[string]string{"name": "bucket1", "key": "item1"})
+			response = NewRecorder()
+			restapi.HeadBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+		})
+
 		Reset(func() {
 			db.Close()
 		})
@@ -313,3 +389,27 @@ func createRequest(method, urlStr string, body interface{}, pathParams map[strin
 	request := test.MakeSimpleRequest(method, urlStr, body)
 	return &rest.Request{Request: request, PathParams: pathParams}
 }
+
+// newRequest builds a request going through a handler, its body sent as
+// JSON unless headers set another Content-Type.
+func newRequest(method, url string, body io.Reader, headers map[string]string) *http.Request {
+	request := httptest.NewRequest(method, url, body)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	return request
+}
+
+// serve runs the request newRequest builds through handler.
+func serve(handler http.Handler, method, url string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+	return serveRequest(handler, newRequest(method, url, body, headers))
+}
+
+func serveRequest(handler http.Handler, request *http.Request) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	return response
+}
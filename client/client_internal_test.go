@@ -0,0 +1,71 @@
+package client
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// boltapiErrors reads the messages of the exported errors declared by the
+// boltapi package, by name.
+func boltapiErrors(t *testing.T) map[string]string {
+	pkgs, err := parser.ParseDir(token.NewFileSet(), "..", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := map[string]string{}
+	for _, file := range pkgs["boltapi"].Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				value := spec.(*ast.ValueSpec)
+				for i, name := range value.Names {
+					if !name.IsExported() || !strings.HasPrefix(name.Name, "Err") || i >= len(value.Values) {
+						continue
+					}
+					call, ok := value.Values[i].(*ast.CallExpr)
+					if !ok || len(call.Args) != 1 {
+						continue
+					}
+					lit, ok := call.Args[0].(*ast.BasicLit)
+					if !ok || lit.Kind != token.STRING {
+						continue
+					}
+					message, err := strconv.Unquote(lit.Value)
+					if err != nil {
+						t.Fatal(err)
+					}
+					messages[name.Name] = message
+				}
+			}
+		}
+	}
+	return messages
+}
+
+func TestKnownErrors(t *testing.T) {
+	Convey("knownErrors should list every boltapi error", t, func() {
+		messages := boltapiErrors(t)
+		So(messages, ShouldContainKey, "ErrBucketMissing")
+
+		known := map[string]bool{}
+		for _, err := range knownErrors {
+			known[err.Error()] = true
+		}
+		for name, message := range messages {
+			So(name+": "+strconv.FormatBool(known[message]), ShouldEqual, name+": true")
+		}
+	})
+}
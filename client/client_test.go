@@ -0,0 +1,185 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	"github.com/marconi/boltapi/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClient(t *testing.T) {
+	Convey("testing client", t, func() {
+		ctx := context.Background()
+		c, server, cleanup := prepServer(t)
+
+		So(c.CreateBucket(ctx, "bucket1"), ShouldBeNil)
+
+		Convey("should be able to manage buckets", func() {
+			names, err := c.ListBuckets(ctx)
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"bucket1"})
+
+			err = c.CreateBucket(ctx, "bucket1")
+			So(errors.Is(err, bolt.ErrBucketExists), ShouldBeTrue)
+
+			So(c.DeleteBucket(ctx, "bucket1"), ShouldBeNil)
+			exists, err := c.BucketExists(ctx, "bucket1")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+		})
+
+		Convey("should be able to manage items", func() {
+			So(c.Add(ctx, "bucket1", "fruit/apple.1", map[string]interface{}{"price": 2.5}), ShouldBeNil)
+
+			err := c.Add(ctx, "bucket1", "fruit/apple.1", map[string]interface{}{"price": 3.5})
+			So(errors.Is(err, boltapi.ErrBucketItemExists), ShouldBeTrue)
+
+			value := map[string]interface{}{}
+			So(c.Get(ctx, "bucket1", "fruit/apple.1", &value), ShouldBeNil)
+			So(value, ShouldResemble, map[string]interface{}{"price": 2.5})
+
+			err = c.Update(ctx, "bucket1", "fruit/orange.1", value)
+			So(errors.Is(err, boltapi.ErrBucketItemMissing), ShouldBeTrue)
+
+			So(c.Put(ctx, "bucket1", "fruit/orange.1", value), ShouldBeNil)
+			items, err := c.MultiGet(ctx, "bucket1", []string{"fruit/orange.1", "fruit/mango.1"})
+			So(err, ShouldBeNil)
+			So(len(items), ShouldEqual, 2)
+			So(items[0].Missing, ShouldBeFalse)
+			So(items[1].Missing, ShouldBeTrue)
+
+			So(c.Delete(ctx, "bucket1", "fruit/orange.1"), ShouldBeNil)
+			exists, err := c.Exists(ctx, "bucket1", "fruit/orange.1")
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+
+			err = c.Get(ctx, "bucket2", "fruit/apple.1", &value)
+			So(errors.Is(err, boltapi.ErrBucketMissing), ShouldBeTrue)
+		})
+
+		Convey("should be able to scan bucket items", func() {
+			for i := 0; i < 25; i++ {
+				So(c.Add(ctx, "bucket1", fmt.Sprintf("item%02d", i), i), ShouldBeNil)
+			}
+
+			keys := []string{}
+			scanner := c.Scan(ctx, "bucket1", client.ScanOptions{Start: "item05", PageSize: 7})
+			for scanner.Next() {
+				keys = append(keys, scanner.Item().Key)
+			}
+			So(scanner.Err(), ShouldBeNil)
+			So(len(keys), ShouldEqual, 20)
+			So(keys[0], ShouldEqual, "item05")
+			So(keys[19], ShouldEqual, "item24")
+		})
+
+		Convey("should retry server errors", func() {
+			failures := int32(2)
+			flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&failures, -1) >= 0 {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				server.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer flaky.Close()
+
+			retrying := client.New(flaky.URL)
+			retrying.Backoff = time.Millisecond
+			names, err := retrying.ListBuckets(ctx)
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"bucket1"})
+		})
+
+		Reset(cleanup)
+	})
+}
+
+func prepServer(t *testing.T, opts ...boltapi.Option) (*client.Client, *httptest.Server, func()) {
+	dir, err := ioutil.TempDir("", "boltapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restapi, err := boltapi.NewRestApi(db, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(restapi.GetHandler())
+	return client.New(server.URL), server, func() {
+		server.Close()
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestClientDatabases(t *testing.T) {
+	Convey("testing client against multiple databases", t, func() {
+		ctx := context.Background()
+		dir, err := ioutil.TempDir("", "boltapi")
+		So(err, ShouldBeNil)
+
+		multi, err := boltapi.NewMultiApi()
+		So(err, ShouldBeNil)
+		So(multi.Mount(&boltapi.DatabaseConfig{Name: "db1", Path: filepath.Join(dir, "db1.db")}), ShouldBeNil)
+		So(multi.Mount(&boltapi.DatabaseConfig{
+			Name:  "db2",
+			Path:  filepath.Join(dir, "db2.db"),
+			Users: map[string]string{"admin": "secret"},
+		}), ShouldBeNil)
+		server := httptest.NewServer(multi)
+
+		Convey("should list databases", func() {
+			databases, err := client.New(server.URL).Databases(ctx)
+			So(err, ShouldBeNil)
+			So(len(databases), ShouldEqual, 1)
+			So(databases[0].Name, ShouldEqual, "db1")
+
+			databases, err = client.New("http://admin:secret@" + server.Listener.Addr().String()).Databases(ctx)
+			So(err, ShouldBeNil)
+			So(len(databases), ShouldEqual, 2)
+			So(databases[1].Name, ShouldEqual, "db2")
+		})
+
+		Convey("should select a database", func() {
+			c := client.New(server.URL)
+			c.Database = "db1"
+			So(c.CreateBucket(ctx, "bucket1"), ShouldBeNil)
+			So(c.Put(ctx, "bucket1", "key1", "value1"), ShouldBeNil)
+
+			protected := client.New("http://admin:secret@" + server.Listener.Addr().String())
+			protected.Database = "db2"
+			names, err := protected.ListBuckets(ctx)
+			So(err, ShouldBeNil)
+			So(names, ShouldBeEmpty)
+
+			c.Database = "db3"
+			_, err = c.ListBuckets(ctx)
+			So(errors.Is(err, boltapi.ErrDatabaseMissing), ShouldBeTrue)
+		})
+
+		Reset(func() {
+			server.Close()
+			multi.Close()
+			os.RemoveAll(dir)
+		})
+	})
+}
@@ -0,0 +1,330 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+)
+
+// ErrValueNotJson is returned when putting a value a DB can't send.
+var ErrValueNotJson = errors.New("value isn't valid json")
+
+// DB mimics the subset of *bolt.DB needed by most code reading and writing
+// top-level buckets, on top of a remote boltapi server.
+//
+// Values are JSON documents, stored with the codec, compression and
+// encryption of their bucket the way the item endpoints store them, and
+// read back decoded, compacted.
+//
+// Writes made within Update are buffered and sent as a single batch once the
+// function returns, so they are applied atomically or not at all. Reads are
+// sent to the server as they're made: a Tx doesn't see a consistent
+// snapshot, though it does see its own pending writes, from Get and from
+// cursors alike.
+type DB struct {
+	client *Client
+	ctx    context.Context
+}
+
+// DB returns a remote database whose requests use ctx.
+func (c *Client) DB(ctx context.Context) *DB {
+	return &DB{client: c, ctx: ctx}
+}
+
+func (db *DB) View(fn func(*Tx) error) error {
+	tx := db.begin(false)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.err
+}
+
+func (db *DB) Update(fn func(*Tx) error) error {
+	tx := db.begin(true)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if tx.err != nil {
+		return tx.err
+	}
+	return tx.commit()
+}
+
+func (db *DB) begin(writable bool) *Tx {
+	return &Tx{
+		db:       db,
+		writable: writable,
+		buckets:  map[string]bool{},
+		pending:  map[string]map[string][]byte{},
+	}
+}
+
+type Tx struct {
+	db       *DB
+	writable bool
+	ops      []*boltapi.BatchOp
+
+	// buckets created or deleted in this transaction, and the values
+	// written to them, nil for deleted keys
+	buckets map[string]bool
+	pending map[string]map[string][]byte
+
+	// err holds the first read error, since bolt's Get can't report one
+	err error
+}
+
+func (tx *Tx) Writable() bool {
+	return tx.writable
+}
+
+// Bucket returns the bucket with the given name, or nil if it doesn't exist.
+func (tx *Tx) Bucket(name []byte) *Bucket {
+	exists, ok := tx.buckets[string(name)]
+	if !ok {
+		var err error
+		if exists, err = tx.db.client.BucketExists(tx.db.ctx, string(name)); err != nil {
+			tx.fail(err)
+			return nil
+		}
+	}
+	if !exists {
+		return nil
+	}
+	return &Bucket{tx: tx, name: string(name)}
+}
+
+func (tx *Tx) CreateBucket(name []byte) (*Bucket, error) {
+	if !tx.writable {
+		return nil, bolt.ErrTxNotWritable
+	}
+	if len(name) == 0 {
+		return nil, bolt.ErrBucketNameRequired
+	}
+	if tx.Bucket(name) != nil {
+		return nil, bolt.ErrBucketExists
+	}
+	if tx.err != nil {
+		return nil, tx.err
+	}
+
+	tx.ops = append(tx.ops, &boltapi.BatchOp{Op: boltapi.BatchCreateBucket, Bucket: string(name)})
+	tx.buckets[string(name)] = true
+	tx.pending[string(name)] = map[string][]byte{}
+	return &Bucket{tx: tx, name: string(name)}, nil
+}
+
+func (tx *Tx) CreateBucketIfNotExists(name []byte) (*Bucket, error) {
+	if bucket := tx.Bucket(name); bucket != nil {
+		return bucket, nil
+	}
+	return tx.CreateBucket(name)
+}
+
+func (tx *Tx) DeleteBucket(name []byte) error {
+	if !tx.writable {
+		return bolt.ErrTxNotWritable
+	}
+	if tx.Bucket(name) == nil {
+		if tx.err != nil {
+			return tx.err
+		}
+		return bolt.ErrBucketNotFound
+	}
+
+	tx.ops = append(tx.ops, &boltapi.BatchOp{Op: boltapi.BatchDeleteBucket, Bucket: string(name)})
+	tx.buckets[string(name)] = false
+	delete(tx.pending, string(name))
+	return nil
+}
+
+func (tx *Tx) commit() error {
+	if len(tx.ops) == 0 {
+		return nil
+	}
+	return tx.db.client.Batch(tx.db.ctx, tx.ops)
+}
+
+func (tx *Tx) fail(err error) {
+	if tx.err == nil {
+		tx.err = err
+	}
+}
+
+type Bucket struct {
+	tx   *Tx
+	name string
+}
+
+// Get returns the value stored under key, or nil if it doesn't exist.
+func (b *Bucket) Get(key []byte) []byte {
+	if value, ok := b.tx.pending[b.name][string(key)]; ok {
+		return value
+	}
+	// buckets created in this transaction only hold pending writes
+	if b.tx.buckets[b.name] {
+		return nil
+	}
+
+	items, _, err := b.tx.db.client.page(b.tx.db.ctx, b.name, string(key), 1)
+	if err != nil {
+		b.tx.fail(err)
+		return nil
+	}
+	if len(items) == 0 || items[0].Key != string(key) {
+		return nil
+	}
+	return items[0].value()
+}
+
+func (b *Bucket) Put(key, value []byte) error {
+	if !b.tx.writable {
+		return bolt.ErrTxNotWritable
+	}
+	if len(key) == 0 {
+		return bolt.ErrKeyRequired
+	}
+	if !json.Valid(value) {
+		return ErrValueNotJson
+	}
+	b.write(&boltapi.BatchOp{Op: boltapi.BatchPut, Bucket: b.name, Key: string(key), Json: value})
+	return nil
+}
+
+func (b *Bucket) Delete(key []byte) error {
+	if !b.tx.writable {
+		return bolt.ErrTxNotWritable
+	}
+	b.write(&boltapi.BatchOp{Op: boltapi.BatchDelete, Bucket: b.name, Key: string(key)})
+	return nil
+}
+
+func (b *Bucket) write(op *boltapi.BatchOp) {
+	b.tx.ops = append(b.tx.ops, op)
+	if b.tx.pending[b.name] == nil {
+		b.tx.pending[b.name] = map[string][]byte{}
+	}
+	b.tx.pending[b.name][op.Key] = op.Json
+}
+
+func (b *Bucket) Cursor() *Cursor {
+	return &Cursor{bucket: b}
+}
+
+func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return b.tx.err
+}
+
+// Cursor walks the items of a bucket in key order, fetching the committed
+// ones a page at a time and merging in the pending writes of its
+// transaction. Nested buckets are returned with a nil value, as bolt does,
+// and so are items holding null.
+type Cursor struct {
+	bucket *Bucket
+	page   []*dbItem
+	next   string
+	last   bool
+
+	// pending lists the keys written in the transaction that are left to
+	// walk through, sorted
+	pending []string
+}
+
+func (c *Cursor) First() ([]byte, []byte) {
+	return c.Seek(nil)
+}
+
+func (c *Cursor) Seek(seek []byte) ([]byte, []byte) {
+	c.page, c.next = nil, string(seek)
+	// buckets created in this transaction only hold pending writes
+	c.last = c.bucket.tx.buckets[c.bucket.name]
+
+	c.pending = nil
+	for key := range c.bucket.tx.pending[c.bucket.name] {
+		if key >= string(seek) {
+			c.pending = append(c.pending, key)
+		}
+	}
+	sort.Strings(c.pending)
+	return c.Next()
+}
+
+func (c *Cursor) Next() ([]byte, []byte) {
+	for {
+		if len(c.page) == 0 && !c.last {
+			page, next, err := c.bucket.tx.db.client.page(c.bucket.tx.db.ctx, c.bucket.name, c.next, DefaultPageSize)
+			if err != nil {
+				c.bucket.tx.fail(err)
+				return nil, nil
+			}
+			c.page, c.next, c.last = page, next, next == ""
+		}
+
+		switch {
+		case len(c.pending) > 0 && (len(c.page) == 0 || c.pending[0] <= c.page[0].Key):
+			key := c.pending[0]
+			c.pending = c.pending[1:]
+			if len(c.page) > 0 && c.page[0].Key == key {
+				c.page = c.page[1:]
+			}
+			// deleted in this transaction
+			value := c.bucket.tx.pending[c.bucket.name][key]
+			if value == nil {
+				continue
+			}
+			return []byte(key), value
+		case len(c.page) > 0:
+			item := c.page[0]
+			c.page = c.page[1:]
+			return []byte(item.Key), item.value()
+		default:
+			return nil, nil
+		}
+	}
+}
+
+// dbItem is a bucket item with its value decoded by the server.
+type dbItem struct {
+	Key   string
+	Value json.RawMessage
+}
+
+func (item *dbItem) value() []byte {
+	if item.Value == nil || string(item.Value) == "null" {
+		return nil
+	}
+	compacted := &bytes.Buffer{}
+	if err := json.Compact(compacted, item.Value); err != nil {
+		return item.Value
+	}
+	return compacted.Bytes()
+}
+
+// page lists up to limit items of a bucket starting at start, returning
+// the key the next page starts at, if any.
+func (c *Client) page(ctx context.Context, bucket, start string, limit int) ([]*dbItem, string, error) {
+	query := url.Values{"limit": {strconv.Itoa(limit)}}
+	if start != "" {
+		query.Set("start", start)
+	}
+
+	items := []*dbItem{}
+	header, err := c.do(ctx, "GET", bucketPath(bucket), query, nil, &items, true)
+	if err != nil {
+		return nil, "", err
+	}
+	next, _ := nextKey(header)
+	return items, next, nil
+}
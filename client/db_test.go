@@ -0,0 +1,130 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	"github.com/marconi/boltapi/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRemoteDB(t *testing.T) {
+	Convey("testing remote db", t, func() {
+		ctx := context.Background()
+		c, _, cleanup := prepServer(t, boltapi.BucketCodec("packed", boltapi.MsgpackCodec))
+		db := c.DB(ctx)
+
+		So(db.Update(func(tx *client.Tx) error {
+			bucket, err := tx.CreateBucket([]byte("bucket1"))
+			if err != nil {
+				return err
+			}
+			for _, key := range []string{"item1", "item2", "item3"} {
+				if err := bucket.Put([]byte(key), []byte(`"value of `+key+`"`)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}), ShouldBeNil)
+
+		Convey("should be able to read committed writes", func() {
+			So(db.View(func(tx *client.Tx) error {
+				bucket := tx.Bucket([]byte("bucket1"))
+				So(bucket, ShouldNotBeNil)
+				So(string(bucket.Get([]byte("item2"))), ShouldEqual, `"value of item2"`)
+				So(bucket.Get([]byte("item4")), ShouldBeNil)
+				So(tx.Bucket([]byte("bucket2")), ShouldBeNil)
+
+				keys := []string{}
+				c := bucket.Cursor()
+				for k, _ := c.Seek([]byte("item2")); k != nil; k, _ = c.Next() {
+					keys = append(keys, string(k))
+				}
+				So(keys, ShouldResemble, []string{"item2", "item3"})
+
+				err := bucket.Put([]byte("item4"), []byte(`"value of item4"`))
+				So(err, ShouldEqual, bolt.ErrTxNotWritable)
+				return nil
+			}), ShouldBeNil)
+		})
+
+		Convey("should see pending writes within the transaction", func() {
+			So(db.Update(func(tx *client.Tx) error {
+				bucket := tx.Bucket([]byte("bucket1"))
+				So(bucket.Delete([]byte("item1")), ShouldBeNil)
+				So(bucket.Put([]byte("item4"), []byte(`"value of item4"`)), ShouldBeNil)
+				So(bucket.Put([]byte("item2"), []byte(`{"changed": true}`)), ShouldBeNil)
+				So(bucket.Get([]byte("item1")), ShouldBeNil)
+				So(string(bucket.Get([]byte("item4"))), ShouldEqual, `"value of item4"`)
+
+				items := map[string]string{}
+				keys := []string{}
+				c := bucket.Cursor()
+				for k, v := c.First(); k != nil; k, v = c.Next() {
+					keys = append(keys, string(k))
+					items[string(k)] = string(v)
+				}
+				So(keys, ShouldResemble, []string{"item2", "item3", "item4"})
+				So(items["item2"], ShouldEqual, `{"changed": true}`)
+				So(items["item3"], ShouldEqual, `"value of item3"`)
+
+				created, err := tx.CreateBucket([]byte("bucket2"))
+				So(err, ShouldBeNil)
+				So(created.Put([]byte("item1"), []byte("1")), ShouldBeNil)
+				k, v := created.Cursor().First()
+				So(string(k), ShouldEqual, "item1")
+				So(string(v), ShouldEqual, "1")
+
+				So(bucket.Put([]byte("item5"), []byte("not json")), ShouldEqual, client.ErrValueNotJson)
+				return nil
+			}), ShouldBeNil)
+
+			So(db.View(func(tx *client.Tx) error {
+				So(string(tx.Bucket([]byte("bucket1")).Get([]byte("item2"))), ShouldEqual, `{"changed":true}`)
+				return nil
+			}), ShouldBeNil)
+		})
+
+		Convey("should read values through the codec of their bucket", func() {
+			So(db.Update(func(tx *client.Tx) error {
+				bucket, err := tx.CreateBucket([]byte("packed"))
+				So(err, ShouldBeNil)
+				return bucket.Put([]byte("item1"), []byte(`{"name": "item1"}`))
+			}), ShouldBeNil)
+
+			So(db.View(func(tx *client.Tx) error {
+				So(string(tx.Bucket([]byte("packed")).Get([]byte("item1"))), ShouldEqual, `{"name":"item1"}`)
+				return nil
+			}), ShouldBeNil)
+		})
+
+		Convey("should discard every write of a failed batch", func() {
+			err := db.Update(func(tx *client.Tx) error {
+				bucket, err := tx.CreateBucket([]byte("bucket2"))
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put([]byte("item1"), []byte(`"value"`)); err != nil {
+					return err
+				}
+				if err := tx.Bucket([]byte("bucket1")).Delete([]byte("item1")); err != nil {
+					return err
+				}
+				// someone else creates the bucket before the batch is sent
+				return c.CreateBucket(ctx, "bucket2")
+			})
+			So(errors.Is(err, bolt.ErrBucketExists), ShouldBeTrue)
+
+			So(db.View(func(tx *client.Tx) error {
+				So(tx.Bucket([]byte("bucket1")).Get([]byte("item1")), ShouldNotBeNil)
+				So(tx.Bucket([]byte("bucket2")).Get([]byte("item1")), ShouldBeNil)
+				return nil
+			}), ShouldBeNil)
+		})
+
+		Reset(cleanup)
+	})
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/marconi/boltapi"
+)
+
+// DefaultPageSize is the number of items fetched per request when scanning.
+const DefaultPageSize = 100
+
+// ScanOptions restricts a scan to part of a bucket, see boltapi.KeyRange.
+type ScanOptions struct {
+	Prefix   string
+	Start    string
+	End      string
+	PageSize int
+}
+
+// Scanner iterates over the items of a bucket, fetching them a page at a
+// time:
+//
+//	scanner := c.Scan(ctx, "bucket1", client.ScanOptions{Prefix: "user:"})
+//	for scanner.Next() {
+//		item := scanner.Item()
+//	}
+//	if err := scanner.Err(); err != nil {
+//		...
+//	}
+type Scanner struct {
+	client *Client
+	ctx    context.Context
+	bucket string
+	opts   ScanOptions
+
+	page []*boltapi.BucketItem
+	item *boltapi.BucketItem
+	last bool
+	err  error
+}
+
+func (c *Client) Scan(ctx context.Context, bucket string, opts ScanOptions) *Scanner {
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultPageSize
+	}
+	return &Scanner{client: c, ctx: ctx, bucket: bucket, opts: opts}
+}
+
+// Next advances to the next item, returning false when there are no more
+// items or an error occurred.
+func (s *Scanner) Next() bool {
+	if s.err != nil {
+		return false
+	}
+	if len(s.page) == 0 {
+		if s.last {
+			return false
+		}
+		s.fetch()
+		if s.err != nil || len(s.page) == 0 {
+			return false
+		}
+	}
+
+	s.item, s.page = s.page[0], s.page[1:]
+	return true
+}
+
+func (s *Scanner) Item() *boltapi.BucketItem {
+	return s.item
+}
+
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+func (s *Scanner) fetch() {
+	query := url.Values{"limit": {strconv.Itoa(s.opts.PageSize)}}
+	for name, value := range map[string]string{"prefix": s.opts.Prefix, "start": s.opts.Start, "end": s.opts.End} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+
+	page := []*boltapi.BucketItem{}
+	header, err := s.client.do(s.ctx, "GET", bucketPath(s.bucket), query, nil, &page, true)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	s.page = page
+	next, ok := nextKey(header)
+	s.last = !ok
+	s.opts.Start = next
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/marconi/boltapi"
+)
+
+var errUsage = errors.New("invalid arguments")
+
+type command struct {
+	usage    string
+	minArgs  int
+	maxArgs  int
+	readOnly bool
+	run      func(s store, out *output, args []string) error
+}
+
+var commands = map[string]*command{
+	"ls":         {"ls [bucket]", 0, 1, true, runLs},
+	"get":        {"get <bucket> <key>", 2, 2, true, runGet},
+	"put":        {"put <bucket> <key> <value>", 3, 3, false, runPut},
+	"rm":         {"rm <bucket> [key]", 1, 2, false, runRm},
+	"mkbucket":   {"mkbucket <name>", 1, 1, false, runMkbucket},
+	"export":     {"export [file]", 0, 1, true, runExport},
+	"import":     {"import [file]", 0, 1, false, runImport},
+	"stats":      {"stats", 0, 0, true, runStats},
+	"recompress": {"recompress <bucket> <gzip|zstd|snappy|none>", 2, 2, false, runRecompress},
+}
+
+// runCommand runs the named command against the remote server when -url is
+// given, or against the -dbpath file otherwise.
+func runCommand(name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	if len(args) < cmd.minArgs || len(args) > cmd.maxArgs {
+		return fmt.Errorf("%s, usage: boltapi %s", errUsage, cmd.usage)
+	}
+
+	out := &output{w: os.Stdout, format: *format}
+	if out.format != formatTable && out.format != formatJson {
+		return fmt.Errorf("unknown output format %q", out.format)
+	}
+
+	var s store
+	switch {
+	case *apiurl != "":
+		s = openRemoteStore(*apiurl, *database)
+	case *dbpath != "":
+		values, err := loadValues()
+		if err != nil {
+			return err
+		}
+		local, err := openLocalStore(*dbpath, cmd.readOnly || *readonly, values)
+		if err != nil {
+			return err
+		}
+		s = local
+	default:
+		return errors.New("either -dbpath or -url param is required")
+	}
+	defer s.Close()
+
+	return cmd.run(s, out, args)
+}
+
+func runLs(s store, out *output, args []string) error {
+	if len(args) == 0 {
+		names, err := s.ListBuckets()
+		if err != nil {
+			return err
+		}
+		return out.list(names)
+	}
+
+	items, err := s.ListItems(args[0])
+	if err != nil {
+		return err
+	}
+	return out.items(items)
+}
+
+func runGet(s store, out *output, args []string) error {
+	value, err := s.Get(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return out.value(value)
+}
+
+// runPut stores the value as JSON, falling back to a plain string when it
+// isn't valid JSON.
+func runPut(s store, out *output, args []string) error {
+	var value interface{}
+	if err := json.Unmarshal([]byte(args[2]), &value); err != nil {
+		value = args[2]
+	}
+	return s.Put(args[0], args[1], value)
+}
+
+func runRm(s store, out *output, args []string) error {
+	if len(args) == 1 {
+		return s.DeleteBucket(args[0])
+	}
+	return s.Delete(args[0], args[1])
+}
+
+func runMkbucket(s store, out *output, args []string) error {
+	return s.CreateBucket(args[0])
+}
+
+// runExport dumps every bucket as a JSON object mapping bucket names to
+// their items, the same format runImport reads.
+func runExport(s store, out *output, args []string) error {
+	names, err := s.ListBuckets()
+	if err != nil {
+		return err
+	}
+
+	buckets := map[string][]*boltapi.BucketItem{}
+	for _, name := range names {
+		if buckets[name], err = s.ListItems(name); err != nil {
+			return err
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if len(args) == 1 {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(buckets)
+}
+
+func runImport(s store, out *output, args []string) error {
+	r := io.Reader(os.Stdin)
+	if len(args) == 1 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	buckets := map[string][]*boltapi.BucketItem{}
+	if err := json.NewDecoder(r).Decode(&buckets); err != nil {
+		return err
+	}
+	return s.Import(buckets)
+}
+
+func runStats(s store, out *output, args []string) error {
+	stats, err := s.Stats()
+	if err != nil {
+		return err
+	}
+	return out.stats(stats)
+}
+
+// runRecompress rewrites the values of a bucket with another compression,
+// meant to run against a local file while the server is stopped.
+func runRecompress(s store, out *output, args []string) error {
+	compression, err := boltapi.LookupCompression(args[1])
+	if err != nil {
+		return fmt.Errorf("%s %q", err, args[1])
+	}
+	result, err := s.Recompress(args[0], compression)
+	if err != nil {
+		return err
+	}
+	if out.format == formatJson {
+		return out.json(result)
+	}
+	_, err = fmt.Fprintf(out.w, "%d keys rewritten in %d batches\n", result.Keys, result.Batches)
+	return err
+}
+
+const (
+	formatTable = "table"
+	formatJson  = "json"
+)
+
+type output struct {
+	w      io.Writer
+	format string
+}
+
+func (out *output) json(v interface{}) error {
+	encoder := json.NewEncoder(out.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (out *output) list(names []string) error {
+	if out.format == formatJson {
+		return out.json(names)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(out.w, name)
+	}
+	return nil
+}
+
+func (out *output) items(items []*boltapi.BucketItem) error {
+	if out.format == formatJson {
+		return out.json(items)
+	}
+
+	tw := tabwriter.NewWriter(out.w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE")
+	for _, item := range items {
+		value, err := json.Marshal(item.Value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", item.Key, value)
+	}
+	return tw.Flush()
+}
+
+func (out *output) value(value interface{}) error {
+	if s, ok := value.(string); ok && out.format == formatTable {
+		_, err := fmt.Fprintln(out.w, s)
+		return err
+	}
+	return out.json(value)
+}
+
+func (out *output) stats(stats *boltapi.Stats) error {
+	if out.format == formatJson {
+		return out.json(stats)
+	}
+
+	tw := tabwriter.NewWriter(out.w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Size:\t%d\n", stats.Size)
+	fmt.Fprintf(tw, "Free pages:\t%d\n", stats.FreePageN)
+	fmt.Fprintf(tw, "Pending pages:\t%d\n", stats.PendingPageN)
+	fmt.Fprintf(tw, "Read transactions:\t%d (%d open)\n", stats.TxN, stats.OpenTxN)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "BUCKET\tKEYS\tDEPTH\tLEAF PAGES\tLEAF INUSE\tBUCKETS")
+	for _, bucket := range stats.Buckets {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", bucket.Name, bucket.KeyN, bucket.Depth,
+			bucket.LeafPageN, bucket.LeafInuse, bucket.BucketN)
+	}
+	return tw.Flush()
+}
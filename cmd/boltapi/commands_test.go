@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/marconi/boltapi"
+)
+
+func TestCommands(t *testing.T) {
+	Convey("testing commands", t, func() {
+		dir, err := ioutil.TempDir("", "boltapi")
+		So(err, ShouldBeNil)
+		path := filepath.Join(dir, "app.db")
+
+		write := func(name, content string) string {
+			path := filepath.Join(dir, name)
+			So(ioutil.WriteFile(path, []byte(content), 0600), ShouldBeNil)
+			return path
+		}
+		key, err := json.Marshal(bytes.Repeat([]byte{1}, 32))
+		So(err, ShouldBeNil)
+		keyFile := write("keys.json", `{"Primary": "k1", "Keys": {"k1": `+string(key)+`}}`)
+		config := write("boltapi.yaml", `
+codecs:
+  buckets:
+    fruits: msgpack
+compression:
+  notes: gzip
+encryption:
+  keyFile: `+keyFile+`
+  buckets: [pii]
+`)
+
+		*dbpath, *configpath = path, config
+		run := func(name string, args ...string) error {
+			return runCommand(name, args)
+		}
+		stored := func(bucket, key string) string {
+			db, err := bolt.Open(path, 0600, nil)
+			So(err, ShouldBeNil)
+			defer db.Close()
+			var value []byte
+			So(db.View(func(tx *bolt.Tx) error {
+				value = append(value, tx.Bucket([]byte(bucket)).Get([]byte(key))...)
+				return nil
+			}), ShouldBeNil)
+			return string(value)
+		}
+		exported := func() map[string][]*boltapi.BucketItem {
+			So(run("export", filepath.Join(dir, "export.json")), ShouldBeNil)
+			content, err := ioutil.ReadFile(filepath.Join(dir, "export.json"))
+			So(err, ShouldBeNil)
+			buckets := map[string][]*boltapi.BucketItem{}
+			So(json.Unmarshal(content, &buckets), ShouldBeNil)
+			return buckets
+		}
+
+		Convey("should store values the way the server does", func() {
+			name := strings.Repeat("apple", 20)
+			for _, bucket := range []string{"fruits", "notes", "pii"} {
+				So(run("mkbucket", bucket), ShouldBeNil)
+				So(run("put", bucket, "item1", `{"name": "`+name+`"}`), ShouldBeNil)
+			}
+
+			So(stored("pii", "item1"), ShouldNotContainSubstring, "apple")
+			So(stored("notes", "item1"), ShouldNotContainSubstring, name)
+			So(stored("fruits", "item1"), ShouldNotEqual, `{"name":"`+name+`"}`)
+
+			buckets := exported()
+			So(len(buckets), ShouldEqual, 3)
+			for _, bucket := range []string{"fruits", "notes", "pii"} {
+				So(len(buckets[bucket]), ShouldEqual, 1)
+				So(buckets[bucket][0].Value, ShouldResemble, map[string]interface{}{"name": name})
+			}
+
+			values, err := loadValues()
+			So(err, ShouldBeNil)
+			s, err := openLocalStore(path, true, values)
+			So(err, ShouldBeNil)
+			defer s.Close()
+			value, err := s.Get("pii", "item1")
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, map[string]interface{}{"name": name})
+		})
+
+		Convey("should import what it exported", func() {
+			So(run("mkbucket", "pii"), ShouldBeNil)
+			So(run("put", "pii", "item1", `"secret"`), ShouldBeNil)
+			So(run("put", "pii", "item2", `plain string`), ShouldBeNil)
+			buckets := exported()
+
+			So(run("rm", "pii", "item1"), ShouldBeNil)
+			So(run("rm", "pii"), ShouldBeNil)
+			So(run("get", "pii", "item1"), ShouldEqual, boltapi.ErrBucketMissing)
+
+			So(run("import", filepath.Join(dir, "export.json")), ShouldBeNil)
+			So(stored("pii", "item1"), ShouldNotContainSubstring, "secret")
+			So(exported(), ShouldResemble, buckets)
+			So(buckets["pii"][1].Value, ShouldEqual, "plain string")
+		})
+
+		Convey("should write tables and json", func() {
+			values, err := loadValues()
+			So(err, ShouldBeNil)
+			s, err := openLocalStore(path, false, values)
+			So(err, ShouldBeNil)
+			defer s.Close()
+			So(s.CreateBucket("bucket1"), ShouldBeNil)
+			So(s.Put("bucket1", "item1", "apple"), ShouldBeNil)
+			So(s.Put("bucket1", "item2", map[string]interface{}{"name": "banana"}), ShouldBeNil)
+
+			buf := new(bytes.Buffer)
+			out := &output{w: buf, format: formatTable}
+			So(runLs(s, out, nil), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "bucket1\n")
+
+			buf.Reset()
+			So(runLs(s, out, []string{"bucket1"}), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "KEY    VALUE\nitem1  \"apple\"\nitem2  {\"name\":\"banana\"}\n")
+
+			buf.Reset()
+			So(runGet(s, out, []string{"bucket1", "item1"}), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "apple\n")
+
+			buf.Reset()
+			So(runStats(s, out, nil), ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, "bucket1  2     1")
+
+			buf.Reset()
+			out.format = formatJson
+			So(runGet(s, out, []string{"bucket1", "item2"}), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "{\n  \"name\": \"banana\"\n}\n")
+
+			buf.Reset()
+			So(runStats(s, out, nil), ShouldBeNil)
+			stats := &boltapi.Stats{}
+			So(json.Unmarshal(buf.Bytes(), stats), ShouldBeNil)
+			So(len(stats.Buckets), ShouldEqual, 1)
+			So(stats.Buckets[0].Name, ShouldEqual, "bucket1")
+			So(stats.Buckets[0].KeyN, ShouldEqual, 2)
+		})
+
+		Convey("should keep blobs, the audit bucket and reserved keys apart", func() {
+			s, err := openLocalStore(path, false, boltapi.NewValues(boltapi.Audit(nil, "_audit")))
+			So(err, ShouldBeNil)
+			defer s.Close()
+			So(s.CreateBucket("files"), ShouldBeNil)
+			So(s.db.Update(func(tx *bolt.Tx) error {
+				_, err := tx.CreateBucket([]byte("_audit"))
+				return err
+			}), ShouldBeNil)
+
+			restapi, err := boltapi.NewRestApi(s.db)
+			So(err, ShouldBeNil)
+			handler := restapi.GetHandler()
+			upload := func(key string) {
+				request := httptest.NewRequest("PUT", "/v1/buckets/files/"+key+"/blob", strings.NewReader("content"))
+				request.Header.Set("Content-Type", "application/octet-stream")
+				response := httptest.NewRecorder()
+				handler.ServeHTTP(response, request)
+				So(response.Code, ShouldEqual, http.StatusOK)
+			}
+			chunks := func(key string) bool {
+				exists := false
+				s.db.View(func(tx *bolt.Tx) error {
+					blobs := tx.Bucket([]byte(boltapi.BlobBucket))
+					exists = blobs != nil && blobs.Bucket([]byte("files")) != nil && blobs.Bucket([]byte("files")).Bucket([]byte(key)) != nil
+					return nil
+				})
+				return exists
+			}
+			upload("doc1")
+			upload("doc2")
+			So(chunks("doc1"), ShouldBeTrue)
+
+			names, err := s.ListBuckets()
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"files"})
+
+			So(s.Put("files", "doc1", "replaced"), ShouldBeNil)
+			So(chunks("doc1"), ShouldBeFalse)
+			So(s.Delete("files", "doc2"), ShouldBeNil)
+			So(chunks("doc2"), ShouldBeFalse)
+
+			upload("doc3")
+			So(s.DeleteBucket("files"), ShouldBeNil)
+			So(chunks("doc3"), ShouldBeFalse)
+
+			So(s.CreateBucket("files"), ShouldBeNil)
+			So(s.Put("files", "sequence", "apple"), ShouldEqual, boltapi.ErrBucketKeyReserved)
+			So(s.Put("_audit", "item1", "apple"), ShouldEqual, boltapi.ErrAuditBucket)
+			So(s.DeleteBucket("_audit"), ShouldEqual, boltapi.ErrAuditBucket)
+			So(s.CreateBucket(boltapi.BlobBucket), ShouldEqual, boltapi.ErrBucketBlobName)
+		})
+
+		Convey("should run against a server", func() {
+			db, err := bolt.Open(path, 0600, nil)
+			So(err, ShouldBeNil)
+			defer db.Close()
+			restapi, err := boltapi.NewRestApi(db, boltapi.AccessLog(ioutil.Discard, boltapi.LogFormatDefault))
+			So(err, ShouldBeNil)
+			server := httptest.NewServer(restapi.ServeMux())
+			defer server.Close()
+
+			s := openRemoteStore(server.URL+"/api", "")
+			So(s.CreateBucket("bucket1"), ShouldBeNil)
+			So(s.Put("bucket1", "item1", "apple"), ShouldBeNil)
+			So(s.Import(map[string][]*boltapi.BucketItem{
+				"bucket1": {{Key: "item2", Value: "banana"}},
+				"bucket2": {{Key: "item1", Value: map[string]interface{}{"name": "cherry"}}},
+			}), ShouldBeNil)
+
+			names, err := s.ListBuckets()
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"bucket1", "bucket2"})
+			items, err := s.ListItems("bucket1")
+			So(err, ShouldBeNil)
+			So(len(items), ShouldEqual, 2)
+			So(items[1].Value, ShouldEqual, "banana")
+			value, err := s.Get("bucket2", "item1")
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, map[string]interface{}{"name": "cherry"})
+
+			stats, err := s.Stats()
+			So(err, ShouldBeNil)
+			So(len(stats.Buckets), ShouldEqual, 2)
+			So(stats.Buckets[0].KeyN, ShouldEqual, 2)
+
+			So(s.Delete("bucket1", "item1"), ShouldBeNil)
+			So(s.DeleteBucket("bucket2"), ShouldBeNil)
+			names, err = s.ListBuckets()
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"bucket1"})
+		})
+
+		Convey("should import into encrypted buckets of a server", func() {
+			values, err := loadValues()
+			So(err, ShouldBeNil)
+			local, err := openLocalStore(path, false, values)
+			So(err, ShouldBeNil)
+			So(local.CreateBucket("pii"), ShouldBeNil)
+			So(local.Close(), ShouldBeNil)
+
+			db, err := bolt.Open(path, 0600, nil)
+			So(err, ShouldBeNil)
+			keyring, err := boltapi.LoadKeyring(keyFile)
+			So(err, ShouldBeNil)
+			restapi, err := boltapi.NewRestApi(db,
+				boltapi.Encryption(keyring, "pii"),
+				boltapi.AccessLog(ioutil.Discard, boltapi.LogFormatDefault))
+			So(err, ShouldBeNil)
+			server := httptest.NewServer(restapi.ServeMux())
+
+			s := openRemoteStore(server.URL+"/api", "")
+			So(s.Import(map[string][]*boltapi.BucketItem{
+				"pii": {{Key: "item1", Value: map[string]interface{}{"name": "apple"}}},
+			}), ShouldBeNil)
+			value, err := s.Get("pii", "item1")
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, map[string]interface{}{"name": "apple"})
+			server.Close()
+			So(db.Close(), ShouldBeNil)
+
+			So(stored("pii", "item1"), ShouldNotContainSubstring, "apple")
+			local, err = openLocalStore(path, true, values)
+			So(err, ShouldBeNil)
+			defer local.Close()
+			value, err = local.Get("pii", "item1")
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, map[string]interface{}{"name": "apple"})
+		})
+
+		Reset(func() {
+			*dbpath, *configpath = "", ""
+			os.RemoveAll(dir)
+		})
+	})
+}
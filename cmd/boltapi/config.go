@@ -0,0 +1,738 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/boltdb/bolt"
+	"gopkg.in/yaml.v2"
+
+	"github.com/marconi/boltapi"
+)
+
+// envPrefix starts the environment variables overriding the config file,
+// e.g. BOLTAPI_LISTEN or BOLTAPI_BOLT_TIMEOUT.
+const envPrefix = "BOLTAPI"
+
+// config is what the server runs with, read from the -config file, then
+// overridden by environment variables and by flags given on the command
+// line.
+type config struct {
+	Listen   string            `json:"listen"`
+	DbPath   string            `json:"dbPath"`
+	ReadOnly bool              `json:"readOnly"`
+	Users    map[string]string `json:"users"`
+	Bolt     boltConfig        `json:"bolt"`
+	TLS      tlsConfig         `json:"tls"`
+
+	CompactJson   bool            `json:"compactJson"`
+	StackTrace    bool            `json:"stackTrace"`
+	MaxBodySize   int             `json:"maxBodySize"`
+	BlobChunkSize int             `json:"blobChunkSize"`
+	AccessLog     accessLogConfig `json:"accessLog"`
+	Log           logConfig       `json:"log"`
+	Tracing       tracingConfig   `json:"tracing"`
+	Codecs        codecsConfig    `json:"codecs"`
+	Cors          corsConfig      `json:"cors"`
+	RateLimit     rateLimitConfig `json:"rateLimit"`
+	// ScanConcurrency caps the requests going through whole buckets
+	ScanConcurrency int `json:"scanConcurrency"`
+
+	// Compression maps buckets to gzip, zstd or snappy
+	Compression map[string]string `json:"compression"`
+	Encryption  encryptionConfig  `json:"encryption"`
+	Audit       auditConfig       `json:"audit"`
+
+	// multi-database mode, see boltapi.MultiApi
+	Databases []*boltapi.DatabaseConfig `json:"databases"`
+	Admin     map[string]string         `json:"admin"`
+	DataDir   string                    `json:"dataDir"`
+}
+
+type boltConfig struct {
+	Timeout         duration `json:"timeout"`
+	NoGrowSync      bool     `json:"noGrowSync"`
+	InitialMmapSize int      `json:"initialMmapSize"`
+	MmapFlags       int      `json:"mmapFlags"`
+}
+
+type tlsConfig struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+}
+
+type accessLogConfig struct {
+	// Format is one of the boltapi.LogFormat* formats
+	Format string `json:"format"`
+	// File is appended to, stderr is used when it's empty and stdout when
+	// it's "stdout"
+	File string `json:"file"`
+}
+
+// logConfig is how the server logs besides the access log.
+type logConfig struct {
+	// Format is json or logfmt, the standard logger's lines are kept when
+	// it's empty
+	Format string `json:"format"`
+	// Level is debug, info, warn or error
+	Level string `json:"level"`
+	// File is appended to, stderr is used when it's empty and stdout when
+	// it's "stdout"
+	File string `json:"file"`
+	// SlowRequest logs the requests taking longer, with their
+	// transactions
+	SlowRequest duration `json:"slowRequest"`
+}
+
+// tracingConfig exports the spans of requests over OTLP, tracing is off
+// without endpoint and file.
+type tracingConfig struct {
+	// Endpoint is the host:port of an OTLP/HTTP collector
+	Endpoint string `json:"endpoint"`
+	Insecure bool   `json:"insecure"`
+	// File is appended the spans as OTLP JSON lines instead, e.g. for
+	// tests
+	File        string `json:"file"`
+	ServiceName string `json:"serviceName"`
+	// SampleRatio of the traces started here, those of clients are sampled
+	// as they decided
+	SampleRatio float64 `json:"sampleRatio"`
+}
+
+type codecsConfig struct {
+	// Default and Buckets name codecs, json, msgpack, cbor, gob or one of
+	// the Protobuf ones
+	Default  string            `json:"default"`
+	Buckets  map[string]string `json:"buckets"`
+	Protobuf []protobufConfig  `json:"protobuf"`
+}
+
+type protobufConfig struct {
+	Name          string `json:"name"`
+	DescriptorSet string `json:"descriptorSet"`
+	Message       string `json:"message"`
+}
+
+// corsConfig matches boltapi.CorsPolicy, CORS is off without origins.
+type corsConfig struct {
+	Origins        []string `json:"origins"`
+	Methods        []string `json:"methods"`
+	Headers        []string `json:"headers"`
+	ExposedHeaders []string `json:"exposedHeaders"`
+	Credentials    bool     `json:"credentials"`
+	MaxAge         duration `json:"maxAge"`
+}
+
+// rateLimitConfig matches boltapi.RateLimits, requests aren't limited
+// without rates.
+type rateLimitConfig struct {
+	Reads          rateConfig `json:"reads"`
+	Writes         rateConfig `json:"writes"`
+	IdentityHeader string     `json:"identityHeader"`
+	TrustedProxies []string   `json:"trustedProxies"`
+}
+
+type rateConfig struct {
+	PerSecond float64 `json:"perSecond"`
+	Burst     int     `json:"burst"`
+}
+
+type encryptionConfig struct {
+	// KeyFile holds the keys as read by boltapi.LoadKeyring
+	KeyFile string   `json:"keyFile"`
+	Buckets []string `json:"buckets"`
+}
+
+// auditConfig records mutations, auditing is off without file and bucket.
+type auditConfig struct {
+	// File is appended JSON lines, moved aside past MaxSize bytes keeping
+	// MaxBackups rotated files
+	File       string `json:"file"`
+	MaxSize    int    `json:"maxSize"`
+	MaxBackups int    `json:"maxBackups"`
+	// Bucket holds the entries in every database, queried with
+	// /v1/admin/audit
+	Bucket string `json:"bucket"`
+}
+
+// duration reads durations written as strings, e.g. "1s".
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func defaultConfig() *config {
+	return &config{
+		Listen:        ":8080",
+		Bolt:          boltConfig{Timeout: duration{1 * time.Second}},
+		MaxBodySize:   boltapi.DefaultMaxBodySize,
+		BlobChunkSize: boltapi.DefaultBlobChunkSize,
+		AccessLog:     accessLogConfig{Format: boltapi.LogFormatDefault},
+		Audit:         auditConfig{MaxSize: 100 << 20, MaxBackups: 10},
+		Tracing:       tracingConfig{ServiceName: "boltapi", SampleRatio: 1},
+	}
+}
+
+// options returns the boltapi options matching the config, and the log
+// files to close once the server is done.
+func (c *config) options() (opts []boltapi.Option, files []io.Closer, err error) {
+	defer func() {
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+		}
+	}()
+
+	if c.CompactJson {
+		opts = append(opts, boltapi.CompactJson())
+	}
+	if c.StackTrace {
+		opts = append(opts, boltapi.ResponseStackTrace())
+	}
+	opts = append(opts, boltapi.MaxBodySize(int64(c.MaxBodySize)), boltapi.BlobChunkSize(c.BlobChunkSize))
+
+	valueOpts, err := c.valueOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	opts = append(opts, valueOpts...)
+
+	if len(c.Cors.Origins) > 0 {
+		opts = append(opts, boltapi.Cors(boltapi.CorsPolicy{
+			Origins:        c.Cors.Origins,
+			Methods:        c.Cors.Methods,
+			Headers:        c.Cors.Headers,
+			ExposedHeaders: c.Cors.ExposedHeaders,
+			Credentials:    c.Cors.Credentials,
+			MaxAge:         c.Cors.MaxAge.Duration,
+		}))
+	}
+
+	if c.RateLimit.Reads.PerSecond > 0 || c.RateLimit.Writes.PerSecond > 0 {
+		opts = append(opts, boltapi.RateLimit(boltapi.RateLimits{
+			Reads:          boltapi.Rate(c.RateLimit.Reads),
+			Writes:         boltapi.Rate(c.RateLimit.Writes),
+			IdentityHeader: c.RateLimit.IdentityHeader,
+			TrustedProxies: c.RateLimit.TrustedProxies,
+		}))
+	}
+	if c.ScanConcurrency > 0 {
+		opts = append(opts, boltapi.ScanConcurrency(c.ScanConcurrency))
+	}
+
+	logOpts, logFile, err := c.Log.options()
+	if err != nil {
+		return nil, files, err
+	}
+	if logFile != nil {
+		files = append(files, logFile)
+	}
+	opts = append(opts, logOpts...)
+
+	tracingOpts, provider, err := c.Tracing.options()
+	if err != nil {
+		return nil, files, err
+	}
+	if provider != nil {
+		files = append(files, provider)
+	}
+	opts = append(opts, tracingOpts...)
+
+	if c.Audit.File != "" || c.Audit.Bucket != "" {
+		var w io.Writer
+		if c.Audit.File != "" {
+			f, err := boltapi.OpenRotatingFile(c.Audit.File, int64(c.Audit.MaxSize), c.Audit.MaxBackups)
+			if err != nil {
+				return nil, files, err
+			}
+			files = append(files, f)
+			w = f
+		}
+		opts = append(opts, boltapi.Audit(w, c.Audit.Bucket))
+	}
+
+	switch c.AccessLog.File {
+	case "":
+		return append(opts, boltapi.AccessLog(os.Stderr, c.AccessLog.Format)), files, nil
+	case "stdout":
+		return append(opts, boltapi.AccessLog(os.Stdout, c.AccessLog.Format)), files, nil
+	}
+
+	f, err := os.OpenFile(c.AccessLog.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, files, err
+	}
+	return append(opts, boltapi.AccessLog(f, c.AccessLog.Format)), append(files, f), nil
+}
+
+// options sets up the default logger, the one of the standard log package
+// too, and returns the file it logs to when it has to be closed.
+func (c *logConfig) options() ([]boltapi.Option, io.Closer, error) {
+	var level slog.Level
+	if c.Level != "" {
+		if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var w io.Writer
+	var closer io.Closer
+	switch c.File {
+	case "":
+		w = os.Stderr
+	case "stdout":
+		w = os.Stdout
+	default:
+		f, err := os.OpenFile(c.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, closer = f, f
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	switch c.Format {
+	case "":
+		log.SetOutput(w)
+		slog.SetLogLoggerLevel(level)
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, handlerOpts)))
+	case "logfmt":
+		slog.SetDefault(slog.New(slog.NewTextHandler(w, handlerOpts)))
+	}
+
+	opts := []boltapi.Option{}
+	if c.SlowRequest.Duration > 0 {
+		opts = append(opts, boltapi.SlowRequests(c.SlowRequest.Duration))
+	}
+	return opts, closer, nil
+}
+
+// valueOptions returns the options picking how values are stored: their
+// codecs, compression and encryption.
+func (c *config) valueOptions() ([]boltapi.Option, error) {
+	opts, err := c.Codecs.options()
+	if err != nil {
+		return nil, err
+	}
+
+	for bucket, name := range c.Compression {
+		compression, err := boltapi.LookupCompression(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q", err, name)
+		}
+		opts = append(opts, boltapi.BucketCompression(bucket, compression))
+	}
+
+	if c.Encryption.KeyFile != "" {
+		keyring, err := boltapi.LoadKeyring(c.Encryption.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, boltapi.Encryption(keyring, c.Encryption.Buckets...))
+	}
+	return opts, nil
+}
+
+// options registers the protobuf codecs and picks the codecs of buckets.
+func (c *codecsConfig) options() ([]boltapi.Option, error) {
+	for _, config := range c.Protobuf {
+		descriptorSet, err := ioutil.ReadFile(config.DescriptorSet)
+		if err != nil {
+			return nil, err
+		}
+		codec, err := boltapi.NewProtobufCodec(descriptorSet, config.Message)
+		if err != nil {
+			return nil, fmt.Errorf("protobuf codec %s: %s", config.Name, err)
+		}
+		boltapi.RegisterCodec(config.Name, codec)
+	}
+
+	opts := []boltapi.Option{}
+	if c.Default != "" {
+		codec, err := boltapi.LookupCodec(c.Default)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q", err, c.Default)
+		}
+		opts = append(opts, boltapi.DefaultCodec(codec))
+	}
+	for bucket, name := range c.Buckets {
+		codec, err := boltapi.LookupCodec(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q", err, name)
+		}
+		opts = append(opts, boltapi.BucketCodec(bucket, codec))
+	}
+	return opts, nil
+}
+
+func (c *boltConfig) options() *bolt.Options {
+	return &bolt.Options{
+		Timeout:         c.Timeout.Duration,
+		NoGrowSync:      c.NoGrowSync,
+		InitialMmapSize: c.InitialMmapSize,
+		MmapFlags:       c.MmapFlags,
+	}
+}
+
+// loadConfig builds the config from the -config file, the environment and
+// the flags set on the command line, in increasing order of precedence.
+func loadConfig() (*config, error) {
+	c := defaultConfig()
+	if *configpath != "" {
+		if err := readConfig(*configpath, c); err != nil {
+			return nil, fmt.Errorf("reading %s: %s", *configpath, err)
+		}
+	}
+	if err := applyEnv(envPrefix, reflect.ValueOf(c).Elem(), os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := applyFlags(c); err != nil {
+		return nil, err
+	}
+	return c, c.validate()
+}
+
+// loadValues reads the -config file and the environment like loadConfig,
+// for commands to store values in the database file the way the server
+// does. The rest of the config is left to the server to check.
+func loadValues() (*boltapi.Values, error) {
+	c := defaultConfig()
+	if *configpath != "" {
+		if err := readConfig(*configpath, c); err != nil {
+			return nil, fmt.Errorf("reading %s: %s", *configpath, err)
+		}
+	}
+	if err := applyEnv(envPrefix, reflect.ValueOf(c).Elem(), os.LookupEnv); err != nil {
+		return nil, err
+	}
+	opts, err := c.valueOptions()
+	if err != nil {
+		return nil, err
+	}
+	// commands don't audit their writes, but keep out of the audit bucket
+	if c.Audit.Bucket != "" {
+		opts = append(opts, boltapi.Audit(nil, c.Audit.Bucket))
+	}
+	return boltapi.NewValues(opts...), nil
+}
+
+// readConfig decodes a YAML, TOML or JSON file, picked by its extension.
+// YAML and TOML are decoded to JSON first so field names are matched the
+// same, case insensitive, way.
+func readConfig(path string, c *config) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var generic interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &generic); err != nil {
+			return err
+		}
+	case ".toml":
+		if _, err := toml.Decode(string(content), &generic); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unknown config format, expected .json, .yaml, .yml or .toml")
+	}
+
+	if generic != nil {
+		if content, err = json.Marshal(stringKeys(generic)); err != nil {
+			return err
+		}
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(content)))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(c)
+}
+
+// stringKeys converts the map[interface{}]interface{} values YAML decodes
+// objects to, which JSON can't encode.
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, value := range v {
+			m[fmt.Sprint(key)] = stringKeys(value)
+		}
+		return m
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = stringKeys(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = stringKeys(value)
+		}
+	}
+	return v
+}
+
+var textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// applyEnv overrides the fields of v with the environment variables named
+// after their path, e.g. BOLTAPI_BOLT_TIMEOUT for Bolt.Timeout. Users maps
+// are given as user:password pairs separated by commas.
+func applyEnv(prefix string, v reflect.Value, lookup func(string) (string, bool)) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		tag := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		name := prefix + "_" + strings.ToUpper(tag)
+
+		if field.Kind() == reflect.Struct && !field.Addr().Type().Implements(textUnmarshaler) {
+			if err := applyEnv(name, field, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("invalid %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Addr().Type().Implements(textUnmarshaler) {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case map[string]string:
+		users, err := parseUsers(value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(users))
+	case []string:
+		field.Set(reflect.ValueOf(strings.Split(value, ",")))
+	default:
+		return errors.New("can't be set from the environment")
+	}
+	return nil
+}
+
+// parseUsers reads user:password pairs separated by commas.
+func parseUsers(value string) (map[string]string, error) {
+	users := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("expected user:password, got %q", pair)
+		}
+		users[parts[0]] = parts[1]
+	}
+	return users, nil
+}
+
+// applyFlags overrides the config with the flags set on the command line.
+func applyFlags(c *config) error {
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dbpath":
+			c.DbPath = *dbpath
+		case "port":
+			c.Listen = fmt.Sprintf(":%d", *port)
+		case "readonly":
+			c.ReadOnly = *readonly
+		case "datadir":
+			c.DataDir = *datadir
+		case "admin":
+			if c.Admin, err = parseUsers(*admin); err != nil {
+				err = fmt.Errorf("invalid -admin: %s", err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, mount := range mounts {
+		mount.ReadOnly = c.ReadOnly
+		c.Databases = append(c.Databases, mount)
+	}
+	if *mountconfig != "" {
+		configs, err := readMountConfig(*mountconfig)
+		if err != nil {
+			return err
+		}
+		c.Databases = append(c.Databases, configs...)
+	}
+	return nil
+}
+
+// validate reports every problem of the config at once.
+func (c *config) validate() error {
+	problems := []string{}
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid listen address %q", c.Listen))
+	}
+
+	multi := len(c.Databases) > 0 || len(c.Admin) > 0
+	switch {
+	case c.DbPath == "" && !multi:
+		problems = append(problems, "either dbPath, databases or admin is required")
+	case c.DbPath != "" && multi:
+		problems = append(problems, "dbPath can't be combined with databases or admin")
+	}
+	if multi && len(c.Users) > 0 {
+		problems = append(problems, "users only apply to dbPath, set them per database instead")
+	}
+
+	names := map[string]bool{}
+	for i, database := range c.Databases {
+		switch {
+		case database.Name == "" || strings.Contains(database.Name, "/"):
+			problems = append(problems, fmt.Sprintf("database %d: invalid name %q", i, database.Name))
+		case names[database.Name]:
+			problems = append(problems, fmt.Sprintf("database %s: name used twice", database.Name))
+		}
+		names[database.Name] = true
+		if database.Path == "" {
+			problems = append(problems, fmt.Sprintf("database %d: path is required", i))
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		problems = append(problems, "tls needs both certFile and keyFile")
+	}
+	if c.Bolt.Timeout.Duration < 0 {
+		problems = append(problems, "bolt timeout can't be negative")
+	}
+	switch c.AccessLog.Format {
+	case boltapi.LogFormatDefault, boltapi.LogFormatCommon, boltapi.LogFormatCombined,
+		boltapi.LogFormatJson, boltapi.LogFormatStructured, boltapi.LogFormatNone:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown accessLog format %q", c.AccessLog.Format))
+	}
+	switch c.Log.Format {
+	case "", "json", "logfmt":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown log format %q", c.Log.Format))
+	}
+	if c.Log.Level != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+			problems = append(problems, fmt.Sprintf("unknown log level %q", c.Log.Level))
+		}
+	}
+	if c.Log.SlowRequest.Duration < 0 {
+		problems = append(problems, "log slowRequest can't be negative")
+	}
+	if c.Tracing.Endpoint != "" && c.Tracing.File != "" {
+		problems = append(problems, "tracing endpoint can't be combined with file")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		problems = append(problems, "tracing sampleRatio must be between 0 and 1")
+	}
+	codecs := map[string]bool{"": true, "json": true, "msgpack": true, "cbor": true, "gob": true}
+	for _, config := range c.Codecs.Protobuf {
+		if config.Name == "" || config.DescriptorSet == "" || config.Message == "" {
+			problems = append(problems, "protobuf codecs need a name, descriptorSet and message")
+		}
+		codecs[config.Name] = true
+	}
+	if !codecs[c.Codecs.Default] {
+		problems = append(problems, fmt.Sprintf("unknown default codec %q", c.Codecs.Default))
+	}
+	for bucket, name := range c.Codecs.Buckets {
+		if !codecs[name] {
+			problems = append(problems, fmt.Sprintf("unknown codec %q of bucket %s", name, bucket))
+		}
+	}
+	for bucket, name := range c.Compression {
+		if _, err := boltapi.LookupCompression(name); err != nil {
+			problems = append(problems, fmt.Sprintf("unknown compression %q of bucket %s", name, bucket))
+		}
+	}
+	for _, origin := range c.Cors.Origins {
+		if origin == "*" && c.Cors.Credentials {
+			problems = append(problems, "cors credentials can't be allowed to any origin")
+		}
+	}
+	if c.RateLimit.Reads.PerSecond < 0 || c.RateLimit.Reads.Burst < 0 {
+		problems = append(problems, "rateLimit reads can't be negative")
+	}
+	if c.RateLimit.Writes.PerSecond < 0 || c.RateLimit.Writes.Burst < 0 {
+		problems = append(problems, "rateLimit writes can't be negative")
+	}
+	if c.ScanConcurrency < 0 {
+		problems = append(problems, "scanConcurrency can't be negative")
+	}
+	if len(c.Encryption.Buckets) > 0 && c.Encryption.KeyFile == "" {
+		problems = append(problems, "encryption buckets need a keyFile")
+	}
+	if c.MaxBodySize < 0 {
+		problems = append(problems, "maxBodySize can't be negative")
+	}
+	if c.Audit.MaxSize < 0 || c.Audit.MaxBackups < 0 {
+		problems = append(problems, "audit maxSize and maxBackups can't be negative")
+	}
+	if c.BlobChunkSize <= 0 {
+		problems = append(problems, "blobChunkSize must be positive")
+	}
+	if c.Bolt.InitialMmapSize < 0 {
+		problems = append(problems, "bolt initialMmapSize can't be negative")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestConfig(t *testing.T) {
+	Convey("testing config files", t, func() {
+		dir, err := ioutil.TempDir("", "boltapi")
+		So(err, ShouldBeNil)
+
+		write := func(name, content string) string {
+			path := filepath.Join(dir, name)
+			So(ioutil.WriteFile(path, []byte(content), 0600), ShouldBeNil)
+			return path
+		}
+
+		files := map[string]string{
+			"config.json": `{
+				"listen": "127.0.0.1:9090",
+				"bolt": {"timeout": "5s", "noGrowSync": true, "initialMmapSize": 1024},
+				"tls": {"certFile": "cert.pem", "keyFile": "key.pem"},
+				"databases": [{"name": "db1", "path": "db1.db", "readOnly": true}]
+			}`,
+			"config.yaml": `
+listen: 127.0.0.1:9090
+bolt:
+  timeout: 5s
+  noGrowSync: true
+  initialMmapSize: 1024
+tls:
+  certFile: cert.pem
+  keyFile: key.pem
+databases:
+  - name: db1
+    path: db1.db
+    readOnly: true
+`,
+			"config.toml": `
+listen = "127.0.0.1:9090"
+
+[bolt]
+timeout = "5s"
+noGrowSync = true
+initialMmapSize = 1024
+
+[tls]
+certFile = "cert.pem"
+keyFile = "key.pem"
+
+[[databases]]
+name = "db1"
+path = "db1.db"
+readOnly = true
+`,
+		}
+
+		Convey("should read every format the same way", func() {
+			for name, content := range files {
+				c := defaultConfig()
+				So(readConfig(write(name, content), c), ShouldBeNil)
+				So(c.validate(), ShouldBeNil)
+
+				So(c.Listen, ShouldEqual, "127.0.0.1:9090")
+				So(c.Bolt.Timeout.Duration, ShouldEqual, 5*time.Second)
+				So(c.Bolt.NoGrowSync, ShouldBeTrue)
+				So(c.Bolt.InitialMmapSize, ShouldEqual, 1024)
+				So(c.TLS.KeyFile, ShouldEqual, "key.pem")
+				So(len(c.Databases), ShouldEqual, 1)
+				So(c.Databases[0].Name, ShouldEqual, "db1")
+				So(c.Databases[0].ReadOnly, ShouldBeTrue)
+			}
+		})
+
+		Convey("should reject unknown fields and formats", func() {
+			err := readConfig(write("config.json", `{"listen": ":8080", "port": 8080}`), defaultConfig())
+			So(err, ShouldNotBeNil)
+
+			err = readConfig(write("config.ini", `listen = :8080`), defaultConfig())
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should export traces to a file", func() {
+			c := defaultConfig()
+			c.Tracing.File = filepath.Join(dir, "traces.jsonl")
+			provider, err := c.Tracing.provider()
+			So(err, ShouldBeNil)
+
+			_, span := provider.Tracer("test").Start(context.Background(), "GET")
+			span.End()
+			So(providerCloser{provider}.Close(), ShouldBeNil)
+
+			content, err := ioutil.ReadFile(c.Tracing.File)
+			So(err, ShouldBeNil)
+			request := new(coltracepb.ExportTraceServiceRequest)
+			So(protojson.Unmarshal(content, request), ShouldBeNil)
+			So(len(request.ResourceSpans), ShouldEqual, 1)
+			resourceSpans := request.ResourceSpans[0]
+			So(resourceSpans.Resource.Attributes[0].Value.GetStringValue(), ShouldEqual, "boltapi")
+			So(resourceSpans.ScopeSpans[0].Spans[0].Name, ShouldEqual, "GET")
+		})
+
+		Convey("should be overridden by the environment", func() {
+			env := map[string]string{
+				"BOLTAPI_DBPATH":       "app.db",
+				"BOLTAPI_BOLT_TIMEOUT": "2s",
+				"BOLTAPI_TLS_CERTFILE": "cert.pem",
+				"BOLTAPI_USERS":        "admin:secret,reader:pass:word",
+
+				"BOLTAPI_ENCRYPTION_BUCKETS": "users,payments",
+			}
+			lookup := func(name string) (string, bool) {
+				value, ok := env[name]
+				return value, ok
+			}
+
+			c := defaultConfig()
+			So(applyEnv(envPrefix, reflect.ValueOf(c).Elem(), lookup), ShouldBeNil)
+			So(c.DbPath, ShouldEqual, "app.db")
+			So(c.Bolt.Timeout.Duration, ShouldEqual, 2*time.Second)
+			So(c.Users, ShouldResemble, map[string]string{"admin": "secret", "reader": "pass:word"})
+			So(c.Encryption.Buckets, ShouldResemble, []string{"users", "payments"})
+
+			env["BOLTAPI_READONLY"] = "maybe"
+			So(applyEnv(envPrefix, reflect.ValueOf(c).Elem(), lookup), ShouldNotBeNil)
+		})
+
+		Convey("should report every validation error", func() {
+			c := defaultConfig()
+			c.Listen = "8080"
+			c.TLS.CertFile = "cert.pem"
+			c.Bolt.InitialMmapSize = -1
+			c.Log.Format = "xml"
+			c.Log.Level = "loud"
+			c.Tracing.SampleRatio = 2
+
+			err := c.validate()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "invalid listen address")
+			So(err.Error(), ShouldContainSubstring, "either dbPath, databases or admin is required")
+			So(err.Error(), ShouldContainSubstring, "tls needs both certFile and keyFile")
+			So(err.Error(), ShouldContainSubstring, "initialMmapSize")
+			So(err.Error(), ShouldContainSubstring, `unknown log format "xml"`)
+			So(err.Error(), ShouldContainSubstring, `unknown log level "loud"`)
+			So(err.Error(), ShouldContainSubstring, "sampleRatio")
+		})
+
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+	})
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
-	"strings"
-	"time"
+	"net/http"
+	"os"
+	"sort"
 
 	"github.com/boltdb/bolt"
 
@@ -12,21 +14,112 @@ import (
 )
 
 var (
-	dbpath = flag.String("dbpath", "", "Path to bolt database")
-	port   = flag.Int("port", 8080, "Port to listen to")
+	dbpath   = flag.String("dbpath", "", "Path to bolt database")
+	port     = flag.Int("port", 8080, "Port to listen to")
+	readonly = flag.Bool("readonly", false, "Open the database read-only, rejecting writes")
+	apiurl   = flag.String("url", "", "URL of a boltapi server commands run against, e.g. http://localhost:8080/api")
+	format   = flag.String("format", formatTable, "Output format of commands, table or json")
+	database = flag.String("db", "", "Database of a multi-database server commands run against")
+
+	mounts      mountFlags
+	mountconfig = flag.String("mountconfig", "", "JSON file listing the databases to serve")
+	admin       = flag.String("admin", "", "Credentials of the admin endpoints managing databases, as user:password")
+	datadir     = flag.String("datadir", "", "Directory the paths of databases attached at runtime are relative to")
+	configpath  = flag.String("config", "", "YAML, TOML or JSON file configuring the server")
 )
 
+func init() {
+	flag.Var(&mounts, "mount", "Serve the database at path as name, given as name=path, repeatable")
+}
+
 func main() {
+	flag.Usage = usage
 	flag.Parse()
-	if strings.TrimSpace(*dbpath) == "" {
-		log.Fatal("-dbpath param is required")
+
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Arg(0), flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	db, err := bolt.Open(*dbpath, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	c, err := loadConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
+	log.Fatal(serve(c))
+}
+
+// serve runs the server described by c until it fails.
+func serve(c *config) error {
+	opts, files, err := c.options()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		defer f.Close()
+	}
+
+	var handler http.Handler
+	if c.DbPath != "" {
+		options := c.Bolt.options()
+		options.ReadOnly = c.ReadOnly
+		db, err := bolt.Open(c.DbPath, 0600, options)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		restapi, err := boltapi.NewRestApi(db, opts...)
+		if err != nil {
+			return err
+		}
+		if len(c.Users) > 0 {
+			restapi.Use(boltapi.BasicAuth("boltapi", c.Users))
+		}
+		handler = restapi.ServeMux()
+	} else {
+		multi, err := boltapi.NewMultiApi(opts...)
+		if err != nil {
+			return err
+		}
+		defer multi.Close()
+
+		multi.AdminUsers = c.Admin
+		multi.Dir = c.DataDir
+		multi.BoltOptions = c.Bolt.options()
+		for _, database := range c.Databases {
+			if err := multi.Mount(database); err != nil {
+				return fmt.Errorf("mounting %s: %s", database.Name, err)
+			}
+		}
+		handler = multi.ServeMux()
+	}
+
+	server := &http.Server{Addr: c.Listen, Handler: handler}
+	if c.TLS.CertFile != "" {
+		return server.ListenAndServeTLS(c.TLS.CertFile, c.TLS.KeyFile)
+	}
+	return server.ListenAndServe()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  boltapi -config=<file>\n")
+	fmt.Fprintf(os.Stderr, "  boltapi -dbpath=<path> [-port=8080] [-readonly]\n")
+	fmt.Fprintf(os.Stderr, "  boltapi (-mount=<name>=<path> ... | -mountconfig=<file> | -admin=<user>:<password>) [-datadir=<dir>] [-port=8080] [-readonly]\n")
+	fmt.Fprintf(os.Stderr, "  boltapi (-dbpath=<path> | -url=<url> [-db=<name>]) [-format=table|json] <command> [args]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
 
-	defer db.Close()
-	log.Fatal(boltapi.Serve(db, *port))
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/marconi/boltapi"
+)
+
+// mountFlags collects the repeatable -mount name=path flag.
+type mountFlags []*boltapi.DatabaseConfig
+
+func (mounts *mountFlags) String() string {
+	pairs := []string{}
+	for _, config := range *mounts {
+		pairs = append(pairs, config.Name+"="+config.Path)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (mounts *mountFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("expected name=path, got %q", value)
+	}
+	*mounts = append(*mounts, &boltapi.DatabaseConfig{Name: parts[0], Path: parts[1]})
+	return nil
+}
+
+// readMountConfig reads a JSON list of databases to mount, e.g.
+//
+//	[{"Name": "users", "Path": "users.db", "ReadOnly": true,
+//	  "Users": {"admin": "secret"}}]
+func readMountConfig(path string) ([]*boltapi.DatabaseConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	configs := []*boltapi.DatabaseConfig{}
+	if err := json.NewDecoder(f).Decode(&configs); err != nil {
+		return nil, fmt.Errorf("reading %s: %s", path, err)
+	}
+	return configs, nil
+}
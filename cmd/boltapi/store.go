@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/marconi/boltapi"
+	"github.com/marconi/boltapi/client"
+)
+
+// store is what commands run against, either a local bolt file or a remote
+// boltapi server.
+type store interface {
+	ListBuckets() ([]string, error)
+	ListItems(bucket string) ([]*boltapi.BucketItem, error)
+	Get(bucket, key string) (interface{}, error)
+	Put(bucket, key string, value interface{}) error
+	Delete(bucket, key string) error
+	CreateBucket(name string) error
+	DeleteBucket(name string) error
+	Import(buckets map[string][]*boltapi.BucketItem) error
+	Stats() (*boltapi.Stats, error)
+	Recompress(bucket string, compression boltapi.Compression) (*boltapi.RecompressResult, error)
+	Close() error
+}
+
+// localStore encodes values with the codecs, compression and encryption
+// the server is configured with, so both read and write the same file.
+type localStore struct {
+	db     *bolt.DB
+	values *boltapi.Values
+}
+
+func openLocalStore(path string, readOnly bool, values *boltapi.Values) (*localStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: readOnly})
+	if err != nil {
+		return nil, err
+	}
+	return &localStore{db: db, values: values}, nil
+}
+
+func (s *localStore) ListBuckets() ([]string, error) {
+	names := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if !s.values.Hidden(string(name)) {
+				names = append(names, string(name))
+			}
+			return nil
+		})
+	})
+	return names, err
+}
+
+func (s *localStore) ListItems(bucketName string) ([]*boltapi.BucketItem, error) {
+	items := []*boltapi.BucketItem{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil {
+			return boltapi.ErrBucketMissing
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil // nested bucket
+			}
+			item := &boltapi.BucketItem{Key: string(k)}
+			if err := s.values.Decode(bucketName, item, v); err != nil {
+				return err
+			}
+			items = append(items, item)
+			return nil
+		})
+	})
+	return items, err
+}
+
+func (s *localStore) Get(bucketName, key string) (interface{}, error) {
+	item := &boltapi.BucketItem{Key: key}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil {
+			return boltapi.ErrBucketMissing
+		}
+		value := bucket.Get(item.EncodeKey())
+		if value == nil {
+			return boltapi.ErrBucketItemMissing
+		}
+		return s.values.Decode(bucketName, item, value)
+	})
+	return item.Value, err
+}
+
+func (s *localStore) Put(bucketName, key string, value interface{}) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.values.Put(tx, bucketName, &boltapi.BucketItem{Key: key, Value: value})
+	})
+}
+
+func (s *localStore) Delete(bucketName, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.values.Delete(tx, bucketName, key)
+	})
+}
+
+func (s *localStore) CreateBucket(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.values.CreateBucket(tx, name)
+	})
+}
+
+func (s *localStore) DeleteBucket(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.values.DeleteBucket(tx, name)
+	})
+}
+
+func (s *localStore) Import(buckets map[string][]*boltapi.BucketItem) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for name, items := range buckets {
+			if tx.Bucket([]byte(name)) == nil {
+				if err := s.values.CreateBucket(tx, name); err != nil {
+					return err
+				}
+			}
+			for _, item := range items {
+				if err := s.values.Put(tx, name, item); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *localStore) Stats() (*boltapi.Stats, error) {
+	return boltapi.GetStats(s.db)
+}
+
+func (s *localStore) Recompress(bucket string, compression boltapi.Compression) (*boltapi.RecompressResult, error) {
+	return boltapi.Recompress(s.db, bucket, compression, boltapi.DefaultTruncateBatchSize)
+}
+
+func (s *localStore) Close() error {
+	return s.db.Close()
+}
+
+type remoteStore struct {
+	client *client.Client
+	ctx    context.Context
+}
+
+func openRemoteStore(url, database string) *remoteStore {
+	c := client.New(url)
+	c.Database = database
+	return &remoteStore{client: c, ctx: context.Background()}
+}
+
+func (s *remoteStore) ListBuckets() ([]string, error) {
+	return s.client.ListBuckets(s.ctx)
+}
+
+func (s *remoteStore) ListItems(bucket string) ([]*boltapi.BucketItem, error) {
+	items := []*boltapi.BucketItem{}
+	scanner := s.client.Scan(s.ctx, bucket, client.ScanOptions{})
+	for scanner.Next() {
+		items = append(items, scanner.Item())
+	}
+	return items, scanner.Err()
+}
+
+func (s *remoteStore) Get(bucket, key string) (interface{}, error) {
+	var value interface{}
+	err := s.client.Get(s.ctx, bucket, key, &value)
+	return value, err
+}
+
+func (s *remoteStore) Put(bucket, key string, value interface{}) error {
+	return s.client.Put(s.ctx, bucket, key, value)
+}
+
+func (s *remoteStore) Delete(bucket, key string) error {
+	return s.client.Delete(s.ctx, bucket, key)
+}
+
+func (s *remoteStore) CreateBucket(name string) error {
+	return s.client.CreateBucket(s.ctx, name)
+}
+
+func (s *remoteStore) DeleteBucket(name string) error {
+	return s.client.DeleteBucket(s.ctx, name)
+}
+
+// Import sends everything as a single batch so a failed import leaves the
+// server untouched.
+func (s *remoteStore) Import(buckets map[string][]*boltapi.BucketItem) error {
+	existing, err := s.client.ListBuckets(s.ctx)
+	if err != nil {
+		return err
+	}
+	exists := map[string]bool{}
+	for _, name := range existing {
+		exists[name] = true
+	}
+
+	ops := []*boltapi.BatchOp{}
+	for name, items := range buckets {
+		if !exists[name] {
+			ops = append(ops, &boltapi.BatchOp{Op: boltapi.BatchCreateBucket, Bucket: name})
+		}
+		// sent as JSON for the server to store them the way the bucket does
+		for _, item := range items {
+			encodedValue, err := item.EncodeValue()
+			if err != nil {
+				return err
+			}
+			ops = append(ops, &boltapi.BatchOp{Op: boltapi.BatchPut, Bucket: name, Key: item.Key, Json: encodedValue})
+		}
+	}
+	return s.client.Batch(s.ctx, ops)
+}
+
+func (s *remoteStore) Stats() (*boltapi.Stats, error) {
+	return s.client.Stats(s.ctx)
+}
+
+func (s *remoteStore) Recompress(bucket string, compression boltapi.Compression) (*boltapi.RecompressResult, error) {
+	return nil, errors.New("recompress only runs against a local -dbpath")
+}
+
+func (s *remoteStore) Close() error {
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/marconi/boltapi"
+)
+
+// options returns the tracing option, and the tracer provider to shut
+// down once the server is done, flushing the spans left.
+func (c *tracingConfig) options() ([]boltapi.Option, io.Closer, error) {
+	if c.Endpoint == "" && c.File == "" {
+		return nil, nil, nil
+	}
+	provider, err := c.provider()
+	if err != nil {
+		return nil, nil, err
+	}
+	return []boltapi.Option{boltapi.Tracing(provider)}, providerCloser{provider}, nil
+}
+
+// provider exports the spans to the OTLP/HTTP endpoint, or to the file.
+func (c *tracingConfig) provider() (*sdktrace.TracerProvider, error) {
+	var client otlptrace.Client
+	if c.File != "" {
+		f, err := os.OpenFile(c.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		client = &fileClient{w: f}
+	} else {
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(c.Endpoint)}
+		if c.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		client = otlptracehttp.NewClient(opts...)
+	}
+
+	exporter, err := otlptrace.New(context.Background(), client)
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", c.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	), nil
+}
+
+type providerCloser struct {
+	provider *sdktrace.TracerProvider
+}
+
+func (c providerCloser) Close() error {
+	return c.provider.Shutdown(context.Background())
+}
+
+// fileClient writes the spans as the JSON lines of the OTLP file exporter,
+// an ExportTraceServiceRequest per line, which collectors read back with
+// their otlpjsonfile receiver.
+type fileClient struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+func (c *fileClient) Start(ctx context.Context) error {
+	return nil
+}
+
+func (c *fileClient) Stop(ctx context.Context) error {
+	return c.w.Close()
+}
+
+func (c *fileClient) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	line, err := protojson.Marshal(&coltracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.w.Write(append(line, '\n'))
+	return err
+}
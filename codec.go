@@ -0,0 +1,418 @@
+package boltapi
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	ErrCodecMissing         = errors.New("unknown codec")
+	ErrUnsupportedMediaType = errors.New("unsupported content type")
+	ErrNotAcceptable        = errors.New("none of the accepted content types can be produced")
+)
+
+// Codec encodes the values stored in buckets, and the values exchanged
+// with clients asking for its content type.
+type Codec interface {
+	// ContentType is the media type of encoded values, e.g. application/cbor
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes data into the value v points to. Values decoded
+	// into an interface{} must be encodable as JSON.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JsonCodec    Codec = jsonCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+	CborCodec    Codec = cborCodec{}
+	GobCodec     Codec = gobCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		"json":    JsonCodec,
+		"msgpack": MsgpackCodec,
+		"cbor":    CborCodec,
+		"gob":     GobCodec,
+	}
+
+	// builtinCodecs are tried in order when looking up a codec by content
+	// type, before the registered ones.
+	builtinCodecs = []Codec{JsonCodec, MsgpackCodec, CborCodec, GobCodec}
+)
+
+// RegisterCodec makes codec available by name, e.g. to the config file.
+func RegisterCodec(name string, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[name] = codec
+}
+
+// LookupCodec returns the codec registered under name.
+func LookupCodec(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, ErrCodecMissing
+	}
+	return codec, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                        { return "application/json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string                        { return "application/msgpack" }
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+// cborDecMode decodes maps with string keys so they can be written as JSON.
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+}.DecMode()
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string                        { return "application/cbor" }
+func (cborCodec) Marshal(v interface{}) ([]byte, error)      { return cbor.Marshal(v) }
+func (cborCodec) Unmarshal(data []byte, v interface{}) error { return cborDecMode.Unmarshal(data, v) }
+
+func init() {
+	// the types JSON values are decoded to, which gob needs to know about
+	// to encode them behind an interface{}
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string { return "application/x-gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type protobufCodec struct {
+	message protoreflect.MessageDescriptor
+}
+
+// NewProtobufCodec stores values as the message with the given full name,
+// looked up in a serialized FileDescriptorSet as written by protoc's
+// --descriptor_set_out with --include_imports. Values are converted from
+// and to their JSON mapping.
+func NewProtobufCodec(descriptorSet []byte, message string) (Codec, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(descriptorSet, set); err != nil {
+		return nil, err
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	descriptor, err := files.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, err
+	}
+	messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, errors.New(message + " isn't a message")
+	}
+	return &protobufCodec{message: messageDescriptor}, nil
+}
+
+// ContentType names the message, telling the codecs of different messages
+// apart.
+func (codec *protobufCodec) ContentType() string {
+	return mime.FormatMediaType("application/x-protobuf", map[string]string{"messagetype": string(codec.message.FullName())})
+}
+
+func (codec *protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	message := dynamicpb.NewMessage(codec.message)
+	if err := protojson.Unmarshal(content, message); err != nil {
+		return nil, err
+	}
+	// dynamic messages are written in random field order otherwise
+	return proto.MarshalOptions{Deterministic: true}.Marshal(message)
+}
+
+func (codec *protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	message := dynamicpb.NewMessage(codec.message)
+	if err := proto.Unmarshal(data, message); err != nil {
+		return err
+	}
+	content, err := protojson.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+// bucketCodec is the codec values of the bucket are stored with.
+func (o *options) bucketCodec(bucket string) Codec {
+	if codec, ok := o.codecs[bucket]; ok {
+		return codec
+	}
+	return o.defaultCodec
+}
+
+// convertingCodecs lists the codecs values can be converted with: the
+// default one, the builtin ones, those of buckets sorted by bucket, and
+// the registered ones sorted by name.
+func (o *options) convertingCodecs() []Codec {
+	candidates := append([]Codec{o.defaultCodec}, builtinCodecs...)
+	buckets := []string{}
+	for bucket := range o.codecs {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	for _, bucket := range buckets {
+		candidates = append(candidates, o.codecs[bucket])
+	}
+
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	names := []string{}
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		candidates = append(candidates, codecs[name])
+	}
+	return candidates
+}
+
+// producesType tells whether codec produces the media type. The parameters
+// of its content type, like the message of protobuf codecs, have to match
+// those given.
+func producesType(codec Codec, mediatype string, params map[string]string) bool {
+	codecType, codecParams, err := mime.ParseMediaType(codec.ContentType())
+	if err != nil || codecType != mediatype {
+		return false
+	}
+	for name, value := range codecParams {
+		if given, ok := params[name]; ok && given != value {
+			return false
+		}
+	}
+	return true
+}
+
+// codecForType finds a codec producing the media type, preferring the one
+// of the bucket when given. Otherwise codecs sharing the media type but
+// not their content type, like protobuf ones of different messages, are
+// ambiguous unless the parameters tell them apart, and none is picked.
+func (o *options) codecForType(bucket, mediatype string, params map[string]string) Codec {
+	if bucket != "" && producesType(o.bucketCodec(bucket), mediatype, params) {
+		return o.bucketCodec(bucket)
+	}
+	var found Codec
+	for _, codec := range o.convertingCodecs() {
+		if !producesType(codec, mediatype, params) {
+			continue
+		}
+		if found != nil && found.ContentType() != codec.ContentType() {
+			return nil
+		}
+		if found == nil {
+			found = codec
+		}
+	}
+	return found
+}
+
+// knownType tells whether a codec produces the media type.
+func (o *options) knownType(mediatype string, params map[string]string) bool {
+	for _, codec := range o.convertingCodecs() {
+		if producesType(codec, mediatype, params) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestCodec picks the codec decoding the request body from its
+// Content-Type, JSON when there's none.
+func (o *options) requestCodec(r *rest.Request, bucket string) (Codec, error) {
+	mediatype, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediatype == "" || mediatype == JsonCodec.ContentType() {
+		return JsonCodec, nil
+	}
+	if codec := o.codecForType(bucket, mediatype, params); codec != nil {
+		return codec, nil
+	}
+	return nil, ErrUnsupportedMediaType
+}
+
+// responseCodec picks the codec of the response from the Accept header,
+// JSON when there's none or when anything goes.
+func (o *options) responseCodec(r *rest.Request, bucket string) (Codec, error) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return JsonCodec, nil
+	}
+
+	type accepted struct {
+		mediatype string
+		params    map[string]string
+		q         float64
+	}
+	ranges := []accepted{}
+	for _, part := range strings.Split(accept, ",") {
+		mediatype, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, accepted{mediatype, params, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	for _, accepted := range ranges {
+		switch accepted.mediatype {
+		case "*/*", "application/*", JsonCodec.ContentType():
+			return JsonCodec, nil
+		}
+		if codec := o.codecForType(bucket, accepted.mediatype, accepted.params); codec != nil {
+			return codec, nil
+		}
+	}
+	return nil, ErrNotAcceptable
+}
+
+// decodeValue reads the request body into v with the codec of its
+// Content-Type.
+func decodeValue(r *rest.Request, codec Codec, v interface{}) error {
+	if codec == JsonCodec {
+		return r.DecodeJsonPayload(v)
+	}
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(content) == 0 {
+		return rest.ErrJsonPayloadEmpty
+	}
+	return codec.Unmarshal(content, v)
+}
+
+// negotiate picks the codecs of the request body and of the response,
+// failing the request when there's none.
+func (restapi *RestApi) negotiate(w rest.ResponseWriter, r *rest.Request, bucket string) (Codec, Codec, bool) {
+	requestCodec, err := restapi.options.requestCodec(r, bucket)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return nil, nil, false
+	}
+	responseCodec, err := restapi.options.responseCodec(r, bucket)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusNotAcceptable)
+		return nil, nil, false
+	}
+	return requestCodec, responseCodec, true
+}
+
+// writeValue writes v with codec, with the given status code unless it's
+// zero.
+func writeValue(w rest.ResponseWriter, codec Codec, status int, v interface{}) {
+	if codec == JsonCodec {
+		if status != 0 {
+			w.WriteHeader(status)
+		}
+		w.WriteJson(v)
+		return
+	}
+
+	content, err := codec.Marshal(v)
+	if err != nil {
+		slog.Error(ErrBucketItemEncode.Error(), "error", err.Error())
+		rest.Error(w, ErrBucketItemEncode.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", codec.ContentType())
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+	w.(http.ResponseWriter).Write(content)
+}
+
+// contentTypeChecker replaces go-json-rest's ContentTypeCheckerMiddleware,
+// also letting through bodies in the content type of a known codec, and
+// blobs of any content type.
+type contentTypeChecker struct {
+	options *options
+}
+
+func (mw *contentTypeChecker) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		mediatype, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		charset, ok := params["charset"]
+		if !ok {
+			charset = "UTF-8"
+		}
+
+		// per net/http doc, means that the length is known and non-null
+		if r.ContentLength > 0 && !isBlobUpload(r) {
+			switch {
+			case mediatype == JsonCodec.ContentType() && strings.ToUpper(charset) == "UTF-8":
+			case mediatype != JsonCodec.ContentType() && mw.options.knownType(mediatype, params):
+			default:
+				rest.Error(w,
+					"Bad Content-Type or charset, expected 'application/json'",
+					http.StatusUnsupportedMediaType,
+				)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
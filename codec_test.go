@@ -0,0 +1,191 @@
+package boltapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// eventDescriptorSet describes a test.Event message with a name and a count.
+func eventDescriptorSet() []byte {
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     kind.Enum(),
+		}
+	}
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("event.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Event"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+			},
+		}, {
+			Name: proto.String("Tag"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("label", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			},
+		}},
+	}}}
+	content, err := proto.Marshal(set)
+	if err != nil {
+		panic(err)
+	}
+	return content
+}
+
+func TestCodecs(t *testing.T) {
+	Convey("testing value codecs", t, func() {
+		_, db := prepDB(t)
+
+		events, err := boltapi.NewProtobufCodec(eventDescriptorSet(), "test.Event")
+		So(err, ShouldBeNil)
+
+		tags, err := boltapi.NewProtobufCodec(eventDescriptorSet(), "test.Tag")
+		So(err, ShouldBeNil)
+
+		restapi, err := boltapi.NewRestApi(db,
+			boltapi.BucketCodec("compact", boltapi.MsgpackCodec),
+			boltapi.BucketCodec("events", events),
+			boltapi.BucketCodec("tags", tags))
+		So(err, ShouldBeNil)
+		handler := restapi.GetHandler()
+
+		So(db.Update(func(tx *bolt.Tx) error {
+			for _, name := range []string{"compact", "events", "plain", "tags"} {
+				if _, err := tx.CreateBucket([]byte(name)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}), ShouldBeNil)
+
+		stored := func(bucket, key string) []byte {
+			var value []byte
+			db.View(func(tx *bolt.Tx) error {
+				value = append(value, tx.Bucket([]byte(bucket)).Get([]byte(key))...)
+				return nil
+			})
+			return value
+		}
+
+		Convey("should store values with the bucket's codec", func() {
+			response := serve(handler, "PUT", "/v1/buckets/compact/item1", strings.NewReader(`{"name": "apple"}`), nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			value := map[string]interface{}{}
+			So(msgpack.Unmarshal(stored("compact", "item1"), &value), ShouldBeNil)
+			So(value, ShouldResemble, map[string]interface{}{"name": "apple"})
+
+			response = serve(handler, "GET", "/v1/buckets/compact/item1", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldContainSubstring, `"name": "apple"`)
+
+			response = serve(handler, "GET", "/v1/buckets/compact", nil, nil)
+			So(response.Body.String(), ShouldContainSubstring, `"name": "apple"`)
+		})
+
+		Convey("should negotiate content types", func() {
+			body, err := cbor.Marshal(map[string]interface{}{"name": "pear"})
+			So(err, ShouldBeNil)
+			response := serve(handler, "PUT", "/v1/buckets/plain/item1", bytes.NewReader(body), map[string]string{"Content-Type": "application/cbor", "Accept": "application/msgpack"})
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Header().Get("Content-Type"), ShouldEqual, "application/msgpack")
+			So(string(stored("plain", "item1")), ShouldEqual, `{"name":"pear"}`)
+
+			response = serve(handler, "GET", "/v1/buckets/plain/item1", nil, map[string]string{"Accept": "text/html;q=0.9, application/cbor"})
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Header().Get("Content-Type"), ShouldEqual, "application/cbor")
+			So(response.Body.Bytes(), ShouldResemble, body)
+
+			response = serve(handler, "GET", "/v1/buckets/plain/item1", nil, map[string]string{"Accept": "text/html"})
+			So(response.Code, ShouldEqual, http.StatusNotAcceptable)
+
+			response = serve(handler, "PUT", "/v1/buckets/plain/item1", strings.NewReader("pear"), map[string]string{"Content-Type": "text/plain"})
+			So(response.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+		})
+
+		Convey("should take the key of non-JSON posts from the query string", func() {
+			body, err := msgpack.Marshal("banana")
+			So(err, ShouldBeNil)
+			response := serve(handler, "POST", "/v1/buckets/plain?key=item2", bytes.NewReader(body), map[string]string{"Content-Type": "application/msgpack"})
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(string(stored("plain", "item2")), ShouldEqual, `"banana"`)
+		})
+
+		Convey("should convert protobuf messages", func() {
+			response := serve(handler, "PUT", "/v1/buckets/events/event1", strings.NewReader(`{"name": "click", "count": 3}`), nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			body := stored("events", "event1")
+			response = serve(handler, "GET", "/v1/buckets/events/event1", nil, map[string]string{"Accept": "application/x-protobuf"})
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.Bytes(), ShouldResemble, body)
+
+			response = serve(handler, "PUT", "/v1/buckets/events/event2", bytes.NewReader(body), map[string]string{"Content-Type": "application/x-protobuf"})
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			response = serve(handler, "GET", "/v1/buckets/events/event2", nil, nil)
+			value := map[string]interface{}{}
+			So(json.Unmarshal(response.Body.Bytes(), &value), ShouldBeNil)
+			So(value, ShouldResemble, map[string]interface{}{"name": "click", "count": 3.0})
+
+			response = serve(handler, "PUT", "/v1/buckets/events/event3", strings.NewReader(`{"color": "red"}`), nil)
+			So(response.Code, ShouldEqual, http.StatusInternalServerError)
+		})
+
+		Convey("should tell protobuf messages apart by their content type", func() {
+			So(serve(handler, "PUT", "/v1/buckets/plain/event1", strings.NewReader(`{"name": "click"}`), nil).Code, ShouldEqual, http.StatusOK)
+
+			// two messages could be meant
+			response := serve(handler, "GET", "/v1/buckets/plain/event1", nil, map[string]string{"Accept": "application/x-protobuf"})
+			So(response.Code, ShouldEqual, http.StatusNotAcceptable)
+
+			for i := 0; i < 10; i++ {
+				response = serve(handler, "GET", "/v1/buckets/plain/event1", nil, map[string]string{"Accept": "application/x-protobuf; messagetype=test.Event"})
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(response.Header().Get("Content-Type"), ShouldEqual, "application/x-protobuf; messagetype=test.Event")
+			}
+
+			// the bucket's own message is meant
+			So(serve(handler, "PUT", "/v1/buckets/tags/tag1", strings.NewReader(`{"label": "red"}`), nil).Code, ShouldEqual, http.StatusOK)
+			response = serve(handler, "GET", "/v1/buckets/tags/tag1", nil, map[string]string{"Accept": "application/x-protobuf"})
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Header().Get("Content-Type"), ShouldEqual, "application/x-protobuf; messagetype=test.Tag")
+		})
+
+		Convey("should round-trip values with every codec", func() {
+			value := map[string]interface{}{"name": "apple", "tags": []interface{}{"red", "sweet"}, "ok": true}
+			for _, codec := range []boltapi.Codec{boltapi.JsonCodec, boltapi.MsgpackCodec, boltapi.CborCodec, boltapi.GobCodec} {
+				item := &boltapi.BucketItem{Key: "item1", Value: value}
+				encoded, err := item.EncodeValueWith(codec)
+				So(err, ShouldBeNil)
+
+				decoded := &boltapi.BucketItem{Key: "item1"}
+				So(decoded.DecodeValueWith(codec, encoded), ShouldBeNil)
+				So(decoded.Value, ShouldResemble, value)
+			}
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
@@ -0,0 +1,407 @@
+package boltapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+
+	"github.com/boltdb/bolt"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// valueMarker starts compressed and encrypted values, followed by the id of
+// the compression or encryptedId. No value encoded by the built-in codecs
+// starts with it and carries more bytes, so they can be told apart from
+// values stored as encoded. Those of other codecs that do are escaped, see
+// escapedId.
+const valueMarker = 0x00
+
+// escapedId follows the marker of values stored as encoded that start with
+// the marker themselves, which could be mistaken for compressed or
+// encrypted ones otherwise.
+const escapedId = 0x00
+
+var (
+	ErrCompressionMissing = errors.New("unknown compression")
+	ErrDecompress         = errors.New("error decompressing value")
+)
+
+// Compression compresses encoded values before storing them.
+type Compression interface {
+	// ID is stored along with compressed values to find the compression
+	// reading them back.
+	ID() byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	GzipCompression   Compression = gzipCompression{}
+	ZstdCompression   Compression = zstdCompression{}
+	SnappyCompression Compression = snappyCompression{}
+
+	compressions = map[string]Compression{
+		"gzip":   GzipCompression,
+		"zstd":   ZstdCompression,
+		"snappy": SnappyCompression,
+	}
+)
+
+// LookupCompression returns the compression called name, which is nil for
+// "none".
+func LookupCompression(name string) (Compression, error) {
+	if name == "none" {
+		return nil, nil
+	}
+	compression, ok := compressions[name]
+	if !ok {
+		return nil, ErrCompressionMissing
+	}
+	return compression, nil
+}
+
+type gzipCompression struct{}
+
+func (gzipCompression) ID() byte { return 1 }
+
+func (gzipCompression) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompression) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// zstd encoders and decoders are safe for concurrent use of EncodeAll and
+// DecodeAll, and costly to create.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+type zstdCompression struct{}
+
+func (zstdCompression) ID() byte { return 2 }
+
+func (zstdCompression) Compress(data []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (zstdCompression) Decompress(data []byte) ([]byte, error) {
+	return zstdDecoder.DecodeAll(data, nil)
+}
+
+type snappyCompression struct{}
+
+func (snappyCompression) ID() byte { return 3 }
+
+func (snappyCompression) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (snappyCompression) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
+
+// compress prefixes compressed data with the marker and the compression's
+// id. Data that doesn't shrink, or isn't compressed, is kept as is unless
+// it starts with the marker, in which case it's escaped.
+func compress(compression Compression, data []byte) ([]byte, error) {
+	if compression == nil {
+		return escape(data), nil
+	}
+	compressed, err := compression.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed)+2 >= len(data) {
+		return escape(data), nil
+	}
+	return append([]byte{valueMarker, compression.ID()}, compressed...), nil
+}
+
+// escape prefixes data starting with the marker with the marker and
+// escapedId, so it isn't read back as compressed or encrypted.
+func escape(data []byte) []byte {
+	if len(data) < 2 || data[0] != valueMarker {
+		return data
+	}
+	return append([]byte{valueMarker, escapedId}, data...)
+}
+
+// decompress undoes compress, whichever compression was used. Data without
+// the marker is returned as is.
+func decompress(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != valueMarker {
+		return data, nil
+	}
+	if data[1] == escapedId {
+		return data[2:], nil
+	}
+	for _, compression := range compressions {
+		if compression.ID() == data[1] {
+			return compression.Decompress(data[2:])
+		}
+	}
+	return nil, ErrCompressionMissing
+}
+
+// encodeStored encodes the value of item the way bucket stores it:
+// encoded with its codec, then compressed and encrypted if it's set to.
+func (o *options) encodeStored(bucket string, item *BucketItem) ([]byte, error) {
+	encoded, err := item.EncodeValueWith(o.bucketCodec(bucket))
+	if err != nil {
+		return nil, err
+	}
+	if encoded, err = compress(o.compressions[bucket], encoded); err != nil {
+		o.log().Error(ErrBucketItemEncode.Error(), "error", err.Error())
+		return nil, ErrBucketItemEncode
+	}
+	if o.encrypted[bucket] {
+		if encoded, err = o.keyring.encrypt(encoded); err != nil {
+			o.log().Error(ErrBucketItemEncode.Error(), "error", err.Error())
+			return nil, ErrBucketItemEncode
+		}
+	}
+	return encoded, nil
+}
+
+// plainStorage reports whether bucket stores values as plain JSON, which
+// raw values written as given don't break.
+func (o *options) plainStorage(bucket string) bool {
+	return o.bucketCodec(bucket) == JsonCodec && o.compressions[bucket] == nil && !o.encrypted[bucket]
+}
+
+// decodeStored decodes a value stored in bucket into item.
+func (o *options) decodeStored(bucket string, item *BucketItem, value []byte) error {
+	value, err := decrypt(o.keyring, value)
+	if err != nil {
+		o.log().Error(ErrDecrypt.Error(), "error", err.Error())
+		return ErrBucketItemDecode
+	}
+	return item.DecodeValueWith(o.bucketCodec(bucket), value)
+}
+
+// Values encodes and decodes values the way a RestApi built with the same
+// options stores them, for tools working on the database file directly.
+// Its writes keep the blobs, the audit bucket and the keys of bucket
+// operations the way the RestApi does.
+type Values struct {
+	options  *options
+	reserved map[string]bool
+}
+
+func NewValues(opts ...Option) *Values {
+	o := newOptions(opts)
+	return &Values{options: o, reserved: reservedKeys((&RestApi{options: o}).endpoints())}
+}
+
+// Hidden tells whether the bucket is kept out of listings, like the chunk
+// bucket of blobs and the audit bucket.
+func (values *Values) Hidden(bucket string) bool {
+	return values.options.hiddenBucket(bucket)
+}
+
+// writable fails for the buckets clients can't write to.
+func (values *Values) writable(bucket string) error {
+	switch {
+	case isBlobBucket(bucket):
+		return ErrBucketBlobName
+	case values.options.hiddenBucket(bucket):
+		return ErrAuditBucket
+	}
+	return nil
+}
+
+// CreateBucket creates a top-level bucket in tx.
+func (values *Values) CreateBucket(tx *bolt.Tx, name string) error {
+	if err := values.writable(name); err != nil {
+		return err
+	}
+	_, err := tx.CreateBucket([]byte(name))
+	return err
+}
+
+// DeleteBucket deletes a top-level bucket in tx, along with the chunks of
+// its blobs.
+func (values *Values) DeleteBucket(tx *bolt.Tx, name string) error {
+	if err := values.writable(name); err != nil {
+		return err
+	}
+	if err := tx.DeleteBucket([]byte(name)); err != nil {
+		return err
+	}
+	return deleteBlobBuckets(tx, name)
+}
+
+// Put encodes and stores item in tx, replacing the blob stored under its
+// key, if any. It fails with ErrBucketKeyReserved on the keys of bucket
+// operations.
+func (values *Values) Put(tx *bolt.Tx, bucketName string, item *BucketItem) error {
+	if err := values.writable(bucketName); err != nil {
+		return err
+	}
+	if values.reserved[item.Key] {
+		return ErrBucketKeyReserved
+	}
+	bucket := tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return ErrBucketMissing
+	}
+	encoded, err := values.Encode(bucketName, item)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put(item.EncodeKey(), encoded); err != nil {
+		return err
+	}
+	return deleteBlobChunks(tx, bucketName, item.Key)
+}
+
+// Delete deletes the item stored under key in tx, with its blob if it's
+// one.
+func (values *Values) Delete(tx *bolt.Tx, bucketName, key string) error {
+	if err := values.writable(bucketName); err != nil {
+		return err
+	}
+	bucket := tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return ErrBucketMissing
+	}
+	if err := bucket.Delete([]byte(key)); err != nil {
+		return err
+	}
+	return deleteBlobChunks(tx, bucketName, key)
+}
+
+// Encode encodes the value of item the way bucket stores it.
+func (values *Values) Encode(bucket string, item *BucketItem) ([]byte, error) {
+	return values.options.encodeStored(bucket, item)
+}
+
+// Decode decodes a value stored in bucket into item.
+func (values *Values) Decode(bucket string, item *BucketItem, value []byte) error {
+	return values.options.decodeStored(bucket, item, value)
+}
+
+// RecompressResult counts the values a recompression rewrote.
+type RecompressResult struct {
+	Keys    int
+	Batches int
+}
+
+// Recompress rewrites every value of the bucket with the given compression,
+// or uncompressed when it's nil, batchSize values per transaction. It works
+// below the codecs, on values as stored, and fails on encrypted ones. The
+// rewrites are recorded in the audit log when opts set one.
+func Recompress(db *bolt.DB, bucketName string, compression Compression, batchSize int, opts ...Option) (*RecompressResult, error) {
+	o := newOptions(opts)
+	trail := o.auditTrail(slog.Default(), "", "")
+	result := new(RecompressResult)
+	err := rewriteValues(db, bucketName, batchSize, AuditRecompress, trail, func(value []byte) ([]byte, error) {
+		value, err := decompress(value)
+		if err != nil {
+			return nil, err
+		}
+		return compress(compression, value)
+	}, func(keys, _ int) {
+		result.Keys += keys
+		result.Batches++
+		slog.Info("recompressing bucket", "bucket", bucketName, "keys", result.Keys, "batches", result.Batches)
+	})
+	return result, err
+}
+
+// rewriteValues passes every value of the bucket through rewrite, batchSize
+// keys per transaction, and stores what it returns unless it's nil. The
+// values rewritten are recorded as op on a fork of trail. batch is called
+// after every transaction with the number of keys it went through and
+// rewrote.
+func rewriteValues(db *bolt.DB, bucketName string, batchSize int, op string, trail *auditTrail, rewrite func(value []byte) ([]byte, error), batch func(keys, rewritten int)) error {
+	if batchSize <= 0 {
+		batchSize = DefaultTruncateBatchSize
+	}
+
+	var next []byte
+	for {
+		count := 0
+		var keys, values [][]byte
+		batchTrail := trail.fork()
+		if err := db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte(bucketName))
+			if bucket == nil {
+				return ErrBucketMissing
+			}
+
+			count = 0
+			keys, values = nil, nil
+			batchTrail = trail.fork()
+			c := bucket.Cursor()
+			k, v := c.First()
+			if next != nil {
+				k, v = c.Seek(next)
+			}
+			for ; k != nil; k, v = c.Next() {
+				if count == batchSize {
+					next = append([]byte(nil), k...)
+					break
+				}
+				count++
+				// nested buckets have nil values
+				if v == nil {
+					continue
+				}
+
+				value, err := rewrite(v)
+				if err != nil {
+					return err
+				}
+				if value == nil {
+					continue
+				}
+				batchTrail.item(op, bucketName, k, v, value)
+				keys = append(keys, append([]byte(nil), k...))
+				values = append(values, append([]byte(nil), value...))
+			}
+			if k == nil {
+				next = nil
+			}
+
+			for i, k := range keys {
+				if err := bucket.Put(k, values[i]); err != nil {
+					return err
+				}
+			}
+			return batchTrail.store(tx)
+		}); err != nil {
+			return err
+		}
+		batchTrail.write()
+
+		if count == 0 {
+			break
+		}
+		batch(count, len(keys))
+
+		if next == nil {
+			break
+		}
+	}
+	return nil
+}
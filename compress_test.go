@@ -0,0 +1,145 @@
+package boltapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// markedCodec writes JSON behind bytes looking like the start of a gzip
+// compressed value.
+type markedCodec struct{}
+
+func (markedCodec) ContentType() string { return "application/x-marked" }
+
+func (markedCodec) Marshal(v interface{}) ([]byte, error) {
+	content, err := json.Marshal(v)
+	return append([]byte{0x00, boltapi.GzipCompression.ID()}, content...), err
+}
+
+func (markedCodec) Unmarshal(data []byte, v interface{}) error {
+	if len(data) < 2 || data[0] != 0x00 {
+		return errors.New("unmarked value")
+	}
+	return json.Unmarshal(data[2:], v)
+}
+
+func TestCompression(t *testing.T) {
+	Convey("testing value compression", t, func() {
+		_, db := prepDB(t)
+
+		restapi, err := boltapi.NewRestApi(db, boltapi.BucketCompression("docs", boltapi.GzipCompression))
+		So(err, ShouldBeNil)
+		handler := restapi.GetHandler()
+
+		So(db.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucket([]byte("docs"))
+			if err != nil {
+				return err
+			}
+			// written before compression was turned on
+			return bucket.Put([]byte("old"), []byte(`{"text": "uncompressed"}`))
+		}), ShouldBeNil)
+
+		document := `{"text": "` + strings.Repeat("all work and no play ", 50) + `"}`
+		stored := func(key string) []byte {
+			var value []byte
+			db.View(func(tx *bolt.Tx) error {
+				value = append(value, tx.Bucket([]byte("docs")).Get([]byte(key))...)
+				return nil
+			})
+			return value
+		}
+
+		Convey("should compress values transparently", func() {
+			response := serve(handler, "PUT", "/v1/buckets/docs/new", strings.NewReader(document), nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(stored("new")[:2], ShouldResemble, []byte{0x00, boltapi.GzipCompression.ID()})
+			So(len(stored("new")), ShouldBeLessThan, len(document)/4)
+
+			response = serve(handler, "GET", "/v1/buckets/docs/new", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldContainSubstring, "all work and no play all work")
+
+			response = serve(handler, "GET", "/v1/buckets/docs/old", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldContainSubstring, "uncompressed")
+
+			response = serve(handler, "GET", "/v1/buckets/docs", nil, nil)
+			So(response.Body.String(), ShouldContainSubstring, "uncompressed")
+			So(response.Body.String(), ShouldContainSubstring, "all work and no play")
+		})
+
+		Convey("should escape values that start like compressed ones", func() {
+			restapi, err := boltapi.NewRestApi(db,
+				boltapi.BucketCodec("marked", markedCodec{}),
+				boltapi.BucketCodec("docs", markedCodec{}),
+				boltapi.BucketCompression("docs", boltapi.GzipCompression))
+			So(err, ShouldBeNil)
+			handler := restapi.GetHandler()
+			So(serve(handler, "POST", "/v1/buckets", strings.NewReader(`{"name": "marked"}`), nil).Code, ShouldEqual, http.StatusOK)
+
+			for _, bucket := range []string{"marked", "docs"} {
+				So(serve(handler, "PUT", "/v1/buckets/"+bucket+"/small", strings.NewReader(`"tiny"`), nil).Code, ShouldEqual, http.StatusOK)
+				response := serve(handler, "GET", "/v1/buckets/"+bucket+"/small", nil, nil)
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(response.Body.String(), ShouldEqual, `"tiny"`)
+			}
+			So(stored("small"), ShouldResemble, append([]byte{0x00, 0x00, 0x00, boltapi.GzipCompression.ID()}, `"tiny"`...))
+		})
+
+		Convey("should keep values that don't shrink uncompressed", func() {
+			response := serve(handler, "PUT", "/v1/buckets/docs/small", strings.NewReader(`"tiny"`), nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(string(stored("small")), ShouldEqual, `"tiny"`)
+		})
+
+		Convey("should recompress existing values", func() {
+			So(serve(handler, "PUT", "/v1/buckets/docs/new", strings.NewReader(document), nil).Code, ShouldEqual, http.StatusOK)
+			So(db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("docs")).Put([]byte("plain"), []byte(document))
+			}), ShouldBeNil)
+
+			result, err := boltapi.Recompress(db, "docs", boltapi.ZstdCompression, 2)
+			So(err, ShouldBeNil)
+			So(result.Keys, ShouldEqual, 3)
+			So(result.Batches, ShouldEqual, 2)
+			So(stored("new")[1], ShouldEqual, boltapi.ZstdCompression.ID())
+			So(stored("plain")[1], ShouldEqual, boltapi.ZstdCompression.ID())
+			So(string(stored("old")), ShouldEqual, `{"text": "uncompressed"}`)
+
+			_, err = boltapi.Recompress(db, "docs", nil, 0)
+			So(err, ShouldBeNil)
+			So(string(stored("new")), ShouldStartWith, `{"text":"all work and no play`)
+
+			_, err = boltapi.Recompress(db, "missing", nil, 0)
+			So(err, ShouldEqual, boltapi.ErrBucketMissing)
+		})
+
+		Convey("should round-trip every compression", func() {
+			for _, name := range []string{"gzip", "zstd", "snappy"} {
+				compression, err := boltapi.LookupCompression(name)
+				So(err, ShouldBeNil)
+				compressed, err := compression.Compress([]byte(document))
+				So(err, ShouldBeNil)
+				decompressed, err := compression.Decompress(compressed)
+				So(err, ShouldBeNil)
+				So(bytes.Equal(decompressed, []byte(document)), ShouldBeTrue)
+			}
+
+			_, err := boltapi.LookupCompression("lzma")
+			So(err, ShouldEqual, boltapi.ErrCompressionMissing)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
@@ -0,0 +1,204 @@
+package boltapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+var ErrCorsForbidden = errors.New("cross-origin request not allowed")
+
+// corsHeaders are the request headers allowed by default, the ones the
+// endpoints read.
+var corsHeaders = []string{
+	"Accept", "Authorization", "Content-Type", "Content-Range", "Range",
+	BlobHashHeader,
+}
+
+// corsExposedHeaders are the response headers exposed by default, the ones
+// the endpoints set.
+var corsExposedHeaders = []string{
+	NextKeyHeader, "Location", "Content-Range", "ETag", BlobHashHeader,
+	RequestIdHeader,
+}
+
+// CorsPolicy lets browsers call the api from other origins.
+type CorsPolicy struct {
+	// Origins allowed, like https://dashboard.example.com, where * matches
+	// anything, e.g. https://*.example.com, or any origin alone
+	Origins []string
+	// Methods allowed, restricting those of the routes when not empty
+	Methods []string
+	// Headers allowed in requests, the ones read by the endpoints when
+	// empty, or any with *
+	Headers []string
+	// ExposedHeaders of responses, the ones set by the endpoints when empty
+	ExposedHeaders []string
+	// Credentials lets requests carry cookies and basic auth
+	Credentials bool
+	// MaxAge is how long browsers may cache preflight responses
+	MaxAge time.Duration
+}
+
+// corsChecker answers preflight requests with the methods the route
+// matching the path handles, and adds CORS headers to the responses of
+// requests from allowed origins.
+type corsChecker struct {
+	policy CorsPolicy
+	routes []*rest.Route
+}
+
+func (mw *corsChecker) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			handler(w, r)
+			return
+		}
+
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		methods := mw.methods(r)
+		if preflight && len(methods) == 0 {
+			// unknown routes are left to the router
+			handler(w, r)
+			return
+		}
+		if !mw.allowOrigin(origin) {
+			if preflight {
+				rest.Error(w, ErrCorsForbidden.Error(), http.StatusForbidden)
+				return
+			}
+			handler(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		if mw.policy.Credentials || !contains(mw.policy.Origins, "*") {
+			header.Set("Access-Control-Allow-Origin", origin)
+		} else {
+			header.Set("Access-Control-Allow-Origin", "*")
+		}
+		if mw.policy.Credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			exposed := mw.policy.ExposedHeaders
+			if len(exposed) == 0 {
+				exposed = corsExposedHeaders
+			}
+			header.Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+			handler(w, r)
+			return
+		}
+
+		requested := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+		requestedHeaders := []string{}
+		for _, name := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				requestedHeaders = append(requestedHeaders, http.CanonicalHeaderKey(name))
+			}
+		}
+		if !contains(methods, requested) || !mw.allowHeaders(requestedHeaders) {
+			rest.Error(w, ErrCorsForbidden.Error(), http.StatusForbidden)
+			return
+		}
+
+		header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(requestedHeaders) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+		}
+		if mw.policy.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(mw.policy.MaxAge/time.Second)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// methods lists the methods of the routes matching the request path, as
+// allowed by the policy.
+func (mw *corsChecker) methods(r *rest.Request) []string {
+	segments := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	methods := []string{}
+	for _, route := range mw.routes {
+		if !matchPathExp(route.PathExp, segments) || contains(methods, route.HttpMethod) {
+			continue
+		}
+		if len(mw.policy.Methods) == 0 || contains(mw.policy.Methods, route.HttpMethod) {
+			methods = append(methods, route.HttpMethod)
+		}
+	}
+	return methods
+}
+
+// matchPathExp tells whether the segments of a path match those of a
+// route, where params match any segment.
+func matchPathExp(pathExp string, segments []string) bool {
+	expSegments := strings.Split(strings.Trim(pathExp, "/"), "/")
+	if len(expSegments) != len(segments) {
+		return false
+	}
+	for i, segment := range expSegments {
+		switch {
+		case strings.HasPrefix(segment, "#"), strings.HasPrefix(segment, ":"):
+			if segments[i] == "" {
+				return false
+			}
+		case segment != segments[i]:
+			return false
+		}
+	}
+	return true
+}
+
+func (mw *corsChecker) allowOrigin(origin string) bool {
+	for _, pattern := range mw.policy.Origins {
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		if star := strings.Index(pattern, "*"); star >= 0 {
+			prefix, suffix := pattern[:star], pattern[star+1:]
+			if len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (mw *corsChecker) allowHeaders(requested []string) bool {
+	allowed := mw.policy.Headers
+	if len(allowed) == 0 {
+		allowed = corsHeaders
+	}
+	if contains(allowed, "*") {
+		return true
+	}
+	for _, name := range requested {
+		found := false
+		for _, header := range allowed {
+			if strings.EqualFold(header, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package boltapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCors(t *testing.T) {
+	Convey("testing CORS", t, func() {
+		_, db := prepDB(t)
+		So(db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucket([]byte("bucket1"))
+			return err
+		}), ShouldBeNil)
+
+		restapi, err := boltapi.NewRestApi(db, boltapi.Cors(boltapi.CorsPolicy{
+			Origins:     []string{"https://dashboard.example.com", "https://*.internal.example.com"},
+			Credentials: true,
+			MaxAge:      10 * time.Minute,
+		}))
+		So(err, ShouldBeNil)
+		restapi.Use(boltapi.BasicAuth("boltapi", map[string]string{"admin": "secret"}))
+		handler := restapi.GetHandler()
+
+		preflight := func(url, origin, method, headers string) *httptest.ResponseRecorder {
+			return serve(handler, "OPTIONS", url, nil, map[string]string{
+				"Origin":                         origin,
+				"Access-Control-Request-Method":  method,
+				"Access-Control-Request-Headers": headers,
+			})
+		}
+
+		Convey("should answer preflight requests with the methods of the route", func() {
+			response := preflight("/v1/buckets/bucket1/item1", "https://dashboard.example.com", "PUT", "content-type, authorization")
+			So(response.Code, ShouldEqual, http.StatusNoContent)
+			So(response.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://dashboard.example.com")
+			So(response.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "GET, HEAD, PUT, DELETE")
+			So(response.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "Content-Type, Authorization")
+			So(response.Header().Get("Access-Control-Allow-Credentials"), ShouldEqual, "true")
+			So(response.Header().Get("Access-Control-Max-Age"), ShouldEqual, "600")
+
+			response = preflight("/v1/buckets/bucket1/mget", "https://app.internal.example.com", "POST", "")
+			So(response.Code, ShouldEqual, http.StatusNoContent)
+			So(response.Header().Get("Access-Control-Allow-Methods"), ShouldContainSubstring, "POST")
+
+			response = preflight("/v1/buckets", "https://dashboard.example.com", "PUT", "")
+			So(response.Code, ShouldEqual, http.StatusForbidden)
+
+			response = preflight("/v1/buckets/bucket1/item1", "https://dashboard.example.com", "PUT", "X-Custom")
+			So(response.Code, ShouldEqual, http.StatusForbidden)
+
+			response = preflight("/v1/buckets/bucket1/item1", "https://evil.example.com", "PUT", "")
+			So(response.Code, ShouldEqual, http.StatusForbidden)
+			So(response.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+		})
+
+		Convey("should add headers to requests from allowed origins", func() {
+			response := serve(handler, "GET", "/v1/buckets", nil, map[string]string{
+				"Origin":        "https://dashboard.example.com",
+				"Authorization": "Basic YWRtaW46c2VjcmV0",
+			})
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://dashboard.example.com")
+			So(response.Header().Get("Access-Control-Expose-Headers"), ShouldContainSubstring, boltapi.NextKeyHeader)
+			So(response.Header().Get("Vary"), ShouldEqual, "Origin")
+
+			response = serve(handler, "GET", "/v1/buckets", nil, map[string]string{
+				"Origin":        "https://evil.example.com",
+				"Authorization": "Basic YWRtaW46c2VjcmV0",
+			})
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+
+			// still behind auth
+			response = serve(handler, "GET", "/v1/buckets", nil, map[string]string{"Origin": "https://dashboard.example.com"})
+			So(response.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("should allow any origin without credentials", func() {
+			restapi, err := boltapi.NewRestApi(db, boltapi.Cors(boltapi.CorsPolicy{
+				Origins: []string{"*"},
+				Methods: []string{"get"},
+			}))
+			So(err, ShouldBeNil)
+			handler = restapi.GetHandler()
+
+			response := preflight("/v1/buckets/bucket1/item1", "https://anywhere.example.com", "GET", "")
+			So(response.Code, ShouldEqual, http.StatusNoContent)
+			So(response.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "*")
+			So(response.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "GET")
+
+			response = preflight("/v1/buckets/bucket1/item1", "https://anywhere.example.com", "DELETE", "")
+			So(response.Code, ShouldEqual, http.StatusForbidden)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
@@ -0,0 +1,346 @@
+package boltapi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/boltdb/bolt"
+)
+
+// encryptedId follows the marker of encrypted values, out of the range of
+// compression ids.
+const encryptedId = 0x80
+
+// dataKeySize is the size of the AES-256 keys generated for every value,
+// sealedKeySize the size of one once sealed with GCM.
+const (
+	dataKeySize   = 32
+	sealedKeySize = 12 + dataKeySize + 16
+)
+
+var (
+	ErrKeyringDecode      = errors.New("error decoding keyring")
+	ErrKeyringPrimary     = errors.New("primary key isn't in the keyring")
+	ErrKeyId              = errors.New("key ids must be 1 to 255 bytes long")
+	ErrKeySize            = errors.New("keys must be 16, 24 or 32 bytes long")
+	ErrKeyMissing         = errors.New("unknown encryption key")
+	ErrDecrypt            = errors.New("error decrypting value")
+	ErrBucketNotEncrypted = errors.New("bucket isn't encrypted")
+	ErrReencryptRunning   = errors.New("bucket is already being re-encrypted")
+	ErrReencryptMissing   = errors.New("bucket hasn't been re-encrypted")
+)
+
+// Keyring holds the keys encrypting values. Values are encrypted with the
+// primary one, the others decrypt values stored before it was rotated.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring makes a keyring out of AES keys by id.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	keyring := &Keyring{primary: primary, keys: map[string]cipher.AEAD{}}
+	for id, key := range keys {
+		if len(id) == 0 || len(id) > 255 {
+			return nil, ErrKeyId
+		}
+		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			return nil, ErrKeySize
+		}
+		aead, err := newAead(key)
+		if err != nil {
+			return nil, err
+		}
+		keyring.keys[id] = aead
+	}
+	if _, ok := keyring.keys[primary]; !ok {
+		return nil, ErrKeyringPrimary
+	}
+	return keyring, nil
+}
+
+// LoadKeyring reads a keyring from a JSON file holding base64 keys by id:
+//
+//	{"primary": "2", "keys": {"1": "<base64>", "2": "<base64>"}}
+func LoadKeyring(path string) (*Keyring, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := struct {
+		Primary string
+		Keys    map[string][]byte
+	}{}
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, ApiError{ErrKeyringDecode, err}
+	}
+	return NewKeyring(file.Primary, file.Keys)
+}
+
+// Primary is the id of the key new values are encrypted with.
+func (keyring *Keyring) Primary() string {
+	return keyring.primary
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+}
+
+// encrypt seals data with a key generated for it, which is sealed in turn
+// with the primary key. The value starts with the marker, the encrypted id
+// and the id of the primary key prefixed with its length, followed by the
+// sealed key and data.
+func (keyring *Keyring) encrypt(data []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	sealedKey, err := seal(keyring.keys[keyring.primary], dataKey, []byte(keyring.primary))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	sealedData, err := seal(aead, data, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := []byte{valueMarker, encryptedId, byte(len(keyring.primary))}
+	encrypted = append(encrypted, keyring.primary...)
+	encrypted = append(encrypted, sealedKey...)
+	return append(encrypted, sealedData...), nil
+}
+
+// encryptionKeyId returns the id of the key data was encrypted with, and
+// whether it's encrypted at all.
+func encryptionKeyId(data []byte) (string, bool) {
+	if len(data) < 3 || data[0] != valueMarker || data[1] != encryptedId {
+		return "", false
+	}
+	end := 3 + int(data[2])
+	if len(data) < end {
+		return "", true
+	}
+	return string(data[3:end]), true
+}
+
+// decrypt undoes encrypt with any key of the keyring, which may be nil.
+// Data that isn't encrypted is returned as is.
+func decrypt(keyring *Keyring, data []byte) ([]byte, error) {
+	id, ok := encryptionKeyId(data)
+	if !ok {
+		return data, nil
+	}
+	if keyring == nil || keyring.keys[id] == nil {
+		return nil, ErrKeyMissing
+	}
+
+	sealed := data[3+len(id):]
+	if len(sealed) < sealedKeySize {
+		return nil, ErrDecrypt
+	}
+	dataKey, err := open(keyring.keys[id], sealed[:sealedKeySize], []byte(id))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, sealed[sealedKeySize:], nil)
+}
+
+// ReencryptResult counts the values a re-encryption went through, and the
+// blob chunks it re-encrypted.
+type ReencryptResult struct {
+	Keys        int
+	Reencrypted int
+	Chunks      int
+	Batches     int
+}
+
+// Reencrypt encrypts every value of the bucket with the primary key of the
+// keyring, batchSize values per transaction, and then the chunks of its
+// blobs. Values already encrypted with it are left alone, other values are
+// encrypted whether they were with an older key or not at all. progress,
+// when not nil, is called after every batch. opts give the codec and
+// compression of the bucket, which blob manifests are read with, and the
+// audit log the rewrites are recorded in.
+func Reencrypt(db *bolt.DB, bucketName string, keyring *Keyring, batchSize int, progress func(*ReencryptResult), opts ...Option) (*ReencryptResult, error) {
+	o := newOptions(append(opts, Encryption(keyring, bucketName)))
+	return o.reencrypt(db, bucketName, batchSize, o.auditTrail(slog.Default(), "", ""), progress)
+}
+
+// reencrypt records the rewrites on forks of trail, one per transaction.
+// Values are audited one by one, chunks with an entry for their blob per
+// batch.
+func (o *options) reencrypt(db *bolt.DB, bucketName string, batchSize int, trail *auditTrail, progress func(*ReencryptResult)) (*ReencryptResult, error) {
+	keyring := o.keyring
+	result := new(ReencryptResult)
+	err := rewriteValues(db, bucketName, batchSize, AuditReencrypt, trail, func(value []byte) ([]byte, error) {
+		if id, _ := encryptionKeyId(value); id == keyring.primary {
+			return nil, nil
+		}
+		value, err := decrypt(keyring, value)
+		if err != nil {
+			return nil, err
+		}
+		return keyring.encrypt(value)
+	}, func(keys, rewritten int) {
+		result.Keys += keys
+		result.Reencrypted += rewritten
+		result.Batches++
+		slog.Info("re-encrypting bucket", "bucket", bucketName, "reencrypted", result.Reencrypted, "keys", result.Keys, "batches", result.Batches)
+		if progress != nil {
+			progress(result)
+		}
+	})
+	if err != nil {
+		return result, err
+	}
+
+	err = o.reencryptBlobs(db, bucketName, batchSize, trail, func(chunks int) {
+		result.Chunks += chunks
+		result.Batches++
+		if progress != nil {
+			progress(result)
+		}
+	})
+	return result, err
+}
+
+// Reencryption reports on the background re-encryption of a bucket.
+type Reencryption struct {
+	ReencryptResult
+	Running  bool
+	Error    string `json:",omitempty"`
+	Started  time.Time
+	Finished *time.Time `json:",omitempty"`
+}
+
+// reencryptions tracks the last re-encryption of every bucket.
+type reencryptions struct {
+	mu      sync.Mutex
+	buckets map[string]*Reencryption
+}
+
+// get returns a copy of the last re-encryption of the bucket.
+func (jobs *reencryptions) get(bucket string) (Reencryption, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	job, ok := jobs.buckets[bucket]
+	if !ok {
+		return Reencryption{}, false
+	}
+	return *job, true
+}
+
+// start runs Reencrypt in the background unless it's already running for
+// the bucket, auditing it on behalf of the request of trail.
+func (jobs *reencryptions) start(db *bolt.DB, bucket string, o *options, trail *auditTrail) (Reencryption, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	if job, ok := jobs.buckets[bucket]; ok && job.Running {
+		return *job, false
+	}
+	if jobs.buckets == nil {
+		jobs.buckets = map[string]*Reencryption{}
+	}
+	job := &Reencryption{Running: true, Started: time.Now()}
+	jobs.buckets[bucket] = job
+
+	go func() {
+		_, err := o.reencrypt(db, bucket, DefaultTruncateBatchSize, trail, func(result *ReencryptResult) {
+			jobs.mu.Lock()
+			job.ReencryptResult = *result
+			jobs.mu.Unlock()
+		})
+		if err != nil {
+			slog.Error("error re-encrypting bucket", "bucket", bucket, "error", err.Error())
+		}
+
+		jobs.mu.Lock()
+		defer jobs.mu.Unlock()
+		finished := time.Now()
+		job.Running = false
+		job.Finished = &finished
+		if err != nil {
+			job.Error = err.Error()
+		}
+	}()
+	return *job, true
+}
+
+// ReencryptBucket starts encrypting the values of the bucket with the
+// primary key in the background, answering 202 Accepted right away.
+func (restapi *RestApi) ReencryptBucket(w rest.ResponseWriter, r *rest.Request) {
+	bucketName := r.PathParam("name")
+	keyring := restapi.options.keyring
+	if keyring == nil || !restapi.options.encrypted[bucketName] {
+		logError(r, ErrBucketNotEncrypted, nil)
+		rest.Error(w, ErrBucketNotEncrypted.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := restapi.view(r, func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(bucketName)) == nil {
+			return ErrBucketMissing
+		}
+		return nil
+	}); err != nil {
+		logError(r, err, nil)
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	job, ok := restapi.reencryptions.start(restapi.db, bucketName, restapi.options, restapi.auditTrail(r))
+	if !ok {
+		logError(r, ErrReencryptRunning, nil)
+		rest.Error(w, ErrReencryptRunning.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.WriteJson(job)
+}
+
+// GetReencryption reports on the last re-encryption of the bucket.
+func (restapi *RestApi) GetReencryption(w rest.ResponseWriter, r *rest.Request) {
+	job, ok := restapi.reencryptions.get(r.PathParam("name"))
+	if !ok {
+		logError(r, ErrReencryptMissing, nil)
+		rest.Error(w, ErrReencryptMissing.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteJson(job)
+}
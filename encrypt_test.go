@@ -0,0 +1,162 @@
+package boltapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncryption(t *testing.T) {
+	Convey("testing value encryption", t, func() {
+		_, db := prepDB(t)
+
+		oldKey := bytes.Repeat([]byte{1}, 32)
+		newKey := bytes.Repeat([]byte{2}, 32)
+		oldKeyring, err := boltapi.NewKeyring("old", map[string][]byte{"old": oldKey})
+		So(err, ShouldBeNil)
+		keyring, err := boltapi.NewKeyring("new", map[string][]byte{"old": oldKey, "new": newKey})
+		So(err, ShouldBeNil)
+
+		So(db.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucket([]byte("pii"))
+			if err != nil {
+				return err
+			}
+			// written before encryption was turned on
+			return bucket.Put([]byte("plain"), []byte(`{"name": "plain"}`))
+		}), ShouldBeNil)
+
+		newHandler := func(keyring *boltapi.Keyring) http.Handler {
+			restapi, err := boltapi.NewRestApi(db,
+				boltapi.Encryption(keyring, "pii"),
+				boltapi.BucketCompression("pii", boltapi.GzipCompression))
+			So(err, ShouldBeNil)
+			return restapi.GetHandler()
+		}
+		handler := newHandler(keyring)
+		stored := func(key string) []byte {
+			var value []byte
+			db.View(func(tx *bolt.Tx) error {
+				value = append(value, tx.Bucket([]byte("pii")).Get([]byte(key))...)
+				return nil
+			})
+			return value
+		}
+		keyId := func(key string) string {
+			value := stored(key)
+			return string(value[3 : 3+int(value[2])])
+		}
+
+		Convey("should encrypt values transparently", func() {
+			document := `{"name": "` + strings.Repeat("john doe ", 20) + `"}`
+			response := serve(handler, "PUT", "/v1/buckets/pii/john", strings.NewReader(document), nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(stored("john")[:2], ShouldResemble, []byte{0x00, 0x80})
+			So(keyId("john"), ShouldEqual, "new")
+			So(string(stored("john")), ShouldNotContainSubstring, "john doe")
+
+			response = serve(handler, "GET", "/v1/buckets/pii/john", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldContainSubstring, "john doe john doe")
+
+			response = serve(handler, "GET", "/v1/buckets/pii", nil, nil)
+			So(response.Body.String(), ShouldContainSubstring, "john doe")
+			So(response.Body.String(), ShouldContainSubstring, `"plain"`)
+
+			response = serve(handler, "POST", "/v1/buckets/pii/mget", strings.NewReader(`{"keys": ["john"]}`), nil)
+			So(response.Body.String(), ShouldContainSubstring, "john doe")
+		})
+
+		Convey("should fail reading values without their key", func() {
+			So(serve(handler, "PUT", "/v1/buckets/pii/john", strings.NewReader(`{"name": "john"}`), nil).Code, ShouldEqual, http.StatusOK)
+
+			response := serve(newHandler(oldKeyring), "GET", "/v1/buckets/pii/john", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusInternalServerError)
+
+			other, err := boltapi.NewKeyring("new", map[string][]byte{"new": oldKey})
+			So(err, ShouldBeNil)
+			response = serve(newHandler(other), "GET", "/v1/buckets/pii/john", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusInternalServerError)
+		})
+
+		Convey("should re-encrypt values with the primary key", func() {
+			So(serve(newHandler(oldKeyring), "PUT", "/v1/buckets/pii/john", strings.NewReader(`{"name": "john"}`), nil).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "PUT", "/v1/buckets/pii/jane", strings.NewReader(`{"name": "jane"}`), nil).Code, ShouldEqual, http.StatusOK)
+			So(keyId("john"), ShouldEqual, "old")
+
+			result, err := boltapi.Reencrypt(db, "pii", keyring, 2, nil)
+			So(err, ShouldBeNil)
+			So(*result, ShouldResemble, boltapi.ReencryptResult{Keys: 3, Reencrypted: 2, Batches: 2})
+			So(keyId("john"), ShouldEqual, "new")
+			So(keyId("plain"), ShouldEqual, "new")
+
+			response := serve(handler, "GET", "/v1/buckets/pii/plain", nil, nil)
+			So(response.Body.String(), ShouldContainSubstring, `"plain"`)
+		})
+
+		Convey("should re-encrypt buckets in the background", func() {
+			So(serve(newHandler(oldKeyring), "PUT", "/v1/buckets/pii/john", strings.NewReader(`{"name": "john"}`), nil).Code, ShouldEqual, http.StatusOK)
+
+			response := serve(handler, "GET", "/v1/buckets/pii/reencrypt", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+
+			response = serve(handler, "POST", "/v1/buckets/pii/reencrypt", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusAccepted)
+
+			job := boltapi.Reencryption{Running: true}
+			for i := 0; i < 100 && job.Running; i++ {
+				time.Sleep(10 * time.Millisecond)
+				response = serve(handler, "GET", "/v1/buckets/pii/reencrypt", nil, nil)
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(json.Unmarshal(response.Body.Bytes(), &job), ShouldBeNil)
+			}
+			So(job.Running, ShouldBeFalse)
+			So(job.Error, ShouldBeEmpty)
+			So(job.Reencrypted, ShouldEqual, 2)
+			So(keyId("john"), ShouldEqual, "new")
+
+			response = serve(handler, "POST", "/v1/buckets/missing/reencrypt", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusBadRequest)
+
+			restapi, err := boltapi.NewRestApi(db, boltapi.Encryption(keyring, "missing"))
+			So(err, ShouldBeNil)
+			response = serve(restapi.GetHandler(), "POST", "/v1/buckets/missing/reencrypt", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("should load keyrings from files", func() {
+			dir, err := ioutil.TempDir("", "boltapi")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "keys.json")
+			content, _ := json.Marshal(map[string]interface{}{
+				"primary": "new",
+				"keys":    map[string][]byte{"old": oldKey, "new": newKey},
+			})
+			So(ioutil.WriteFile(path, content, 0600), ShouldBeNil)
+			loaded, err := boltapi.LoadKeyring(path)
+			So(err, ShouldBeNil)
+			So(loaded.Primary(), ShouldEqual, "new")
+
+			_, err = boltapi.NewKeyring("other", map[string][]byte{"new": newKey})
+			So(err, ShouldEqual, boltapi.ErrKeyringPrimary)
+			_, err = boltapi.NewKeyring("new", map[string][]byte{"new": newKey[:10]})
+			So(err, ShouldEqual, boltapi.ErrKeySize)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
@@ -0,0 +1,250 @@
+package boltapi
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/boltdb/bolt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxRequestIdSize bounds the request ids propagated from clients, longer
+// ones or ones with other than printable ASCII are replaced.
+const maxRequestIdSize = 128
+
+// requestLogEnv is the Env key of the requestLog of a request.
+const requestLogEnv = "REQUEST_LOG"
+
+// requestLog follows a request for the logs, every line logged through
+// it carries the request id.
+type requestLog struct {
+	id     string
+	logger *slog.Logger
+
+	mu  sync.Mutex
+	txs []txTiming
+}
+
+// txTiming is how long a transaction ran, along with the wait for bolt's
+// write lock for updates.
+type txTiming struct {
+	writable bool
+	duration time.Duration
+}
+
+func (o *options) log() *slog.Logger {
+	if o.logger != nil {
+		return o.logger
+	}
+	return slog.Default()
+}
+
+// requestLogOf returns the log of a request, or one logging to the default
+// logger for handlers called outside of the api.
+func requestLogOf(r *rest.Request) *requestLog {
+	if rl, ok := r.Env[requestLogEnv].(*requestLog); ok {
+		return rl
+	}
+	return &requestLog{logger: slog.Default()}
+}
+
+// logError logs the error a request failed with.
+func logError(r *rest.Request, customErr, origErr error) {
+	logger := requestLogOf(r).logger
+	if origErr != nil {
+		logger.Error(customErr.Error(), "error", origErr.Error())
+		return
+	}
+	logger.Error(customErr.Error())
+}
+
+func (rl *requestLog) time(writable bool, start time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.txs = append(rl.txs, txTiming{writable, time.Since(start)})
+}
+
+// transactions describes the transactions of the request in the order
+// they ended, e.g. "view 1.2ms".
+func (rl *requestLog) transactions() []string {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	txs := []string{}
+	for _, tx := range rl.txs {
+		kind := "view"
+		if tx.writable {
+			kind = "update"
+		}
+		txs = append(txs, kind+" "+tx.duration.String())
+	}
+	return txs
+}
+
+// view runs fn in a read transaction, timed for the slow request log and
+// traced.
+func (restapi *RestApi) view(r *rest.Request, fn func(tx *bolt.Tx) error) error {
+	defer requestLogOf(r).time(false, time.Now())
+	return traced(r, "bolt.View", func() error {
+		return restapi.db.View(fn)
+	})
+}
+
+// requestIdentifier gives every request an id, the one of the
+// RequestIdHeader sent by the client or a new one, set on the request and
+// the response headers, in error bodies and in the logs. It logs requests
+// slower than slow, when it's set, with their transactions.
+type requestIdentifier struct {
+	logger func() *slog.Logger
+	slow   time.Duration
+}
+
+func (mw *requestIdentifier) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		start := time.Now()
+		id := ensureRequestId(w.Header(), r.Request)
+		rl := &requestLog{id: id, logger: mw.logger().With("request_id", id)}
+		r.Env[requestLogEnv] = rl
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("boltapi.request_id", id))
+
+		handler(&requestIdWriter{w, id}, r)
+
+		if elapsed := time.Since(start); mw.slow > 0 && elapsed >= mw.slow {
+			rl.logger.Warn("slow request",
+				"method", r.Method,
+				"path", r.URL.RequestURI(),
+				"duration", elapsed,
+				"transactions", rl.transactions())
+		}
+	}
+}
+
+// panicRecoverer answers requests whose handler panicked with a 500, as
+// rest.RecoverMiddleware does, logging the panic and its stack with the
+// request id.
+type panicRecoverer struct {
+	stackTrace bool
+}
+
+func (mw *panicRecoverer) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		defer func() {
+			if reason := recover(); reason != nil {
+				stack := debug.Stack()
+				requestLogOf(r).logger.Error("panic serving request",
+					"panic", fmt.Sprint(reason),
+					"stack", string(stack))
+
+				message := http.StatusText(http.StatusInternalServerError)
+				if mw.stackTrace {
+					message = fmt.Sprintf("%s\n%s", reason, stack)
+				}
+				rest.Error(w, message, http.StatusInternalServerError)
+			}
+		}()
+		handler(w, r)
+	}
+}
+
+// ensureRequestId returns the id of the request, generating one when the
+// client didn't send a valid one, and sets it on both headers.
+func ensureRequestId(header http.Header, r *http.Request) string {
+	id := r.Header.Get(RequestIdHeader)
+	if !validRequestId(id) {
+		id = newRequestId()
+		r.Header.Set(RequestIdHeader, id)
+	}
+	header.Set(RequestIdHeader, id)
+	return id
+}
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdSize {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// requestIdWriter adds the request id to the bodies written by rest.Error.
+type requestIdWriter struct {
+	rest.ResponseWriter
+	id string
+}
+
+func (w *requestIdWriter) WriteJson(v interface{}) error {
+	if body, ok := v.(map[string]string); ok && len(body) == 1 && body[rest.ErrorFieldName] != "" {
+		v = map[string]string{rest.ErrorFieldName: body[rest.ErrorFieldName], "RequestId": w.id}
+	}
+	return w.ResponseWriter.WriteJson(v)
+}
+
+func (w *requestIdWriter) Write(b []byte) (int, error) {
+	return w.ResponseWriter.(http.ResponseWriter).Write(b)
+}
+
+func (w *requestIdWriter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *requestIdWriter) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (w *requestIdWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// structuredAccessLog logs a line per request through the logger, once the
+// timer and recorder middlewares after it are done.
+type structuredAccessLog struct {
+	logger func() *slog.Logger
+}
+
+func (mw *structuredAccessLog) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		handler(w, r)
+
+		logger := mw.logger()
+		if rl, ok := r.Env[requestLogEnv].(*requestLog); ok {
+			logger = rl.logger
+		}
+		attrs := []interface{}{
+			"method", r.Method,
+			"path", r.URL.RequestURI(),
+			"remote_addr", r.RemoteAddr,
+		}
+		if status, ok := r.Env["STATUS_CODE"].(int); ok {
+			attrs = append(attrs, "status", status)
+		}
+		if elapsed, ok := r.Env["ELAPSED_TIME"].(*time.Duration); ok {
+			attrs = append(attrs, "duration", *elapsed)
+		}
+		if written, ok := r.Env["BYTES_WRITTEN"].(int64); ok {
+			attrs = append(attrs, "bytes", written)
+		}
+		if user, ok := r.Env["REMOTE_USER"].(string); ok {
+			attrs = append(attrs, "user", user)
+		}
+		logger.Info("request", attrs...)
+	}
+}
@@ -0,0 +1,104 @@
+package boltapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogging(t *testing.T) {
+	Convey("testing structured logging", t, func() {
+		_, db := prepDB(t)
+		So(db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucket([]byte("bucket1"))
+			return err
+		}), ShouldBeNil)
+
+		logged := new(bytes.Buffer)
+		logger := slog.New(slog.NewJSONHandler(logged, nil))
+		lines := func() []map[string]interface{} {
+			entries := []map[string]interface{}{}
+			for _, line := range strings.Split(strings.TrimSpace(logged.String()), "\n") {
+				entry := map[string]interface{}{}
+				So(json.Unmarshal([]byte(line), &entry), ShouldBeNil)
+				entries = append(entries, entry)
+			}
+			return entries
+		}
+
+		Convey("should give every request an id", func() {
+			restapi, err := boltapi.NewRestApi(db, boltapi.Logger(logger))
+			So(err, ShouldBeNil)
+			handler := restapi.GetHandler()
+
+			response := serve(handler, "GET", "/v1/buckets", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(len(response.Header().Get(boltapi.RequestIdHeader)), ShouldEqual, 32)
+
+			response = serve(handler, "GET", "/v1/buckets", nil, map[string]string{boltapi.RequestIdHeader: "client-id-1"})
+			So(response.Header().Get(boltapi.RequestIdHeader), ShouldEqual, "client-id-1")
+
+			response = serve(handler, "GET", "/v1/buckets", nil, map[string]string{boltapi.RequestIdHeader: "bad id"})
+			So(response.Header().Get(boltapi.RequestIdHeader), ShouldNotEqual, "bad id")
+			So(len(response.Header().Get(boltapi.RequestIdHeader)), ShouldEqual, 32)
+		})
+
+		Convey("should log errors with the request id", func() {
+			restapi, err := boltapi.NewRestApi(db, boltapi.Logger(logger))
+			So(err, ShouldBeNil)
+
+			response := serve(restapi.GetHandler(), "GET", "/v1/buckets/bucket1/missing", nil, map[string]string{boltapi.RequestIdHeader: "client-id-2"})
+			So(response.Code, ShouldEqual, http.StatusInternalServerError)
+			body := map[string]string{}
+			So(json.Unmarshal(response.Body.Bytes(), &body), ShouldBeNil)
+			So(body["RequestId"], ShouldEqual, "client-id-2")
+			So(body["Error"], ShouldNotBeEmpty)
+
+			entries := lines()
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0]["level"], ShouldEqual, "ERROR")
+			So(entries[0]["request_id"], ShouldEqual, "client-id-2")
+		})
+
+		Convey("should write the access log through the logger", func() {
+			restapi, err := boltapi.NewRestApi(db, boltapi.Logger(logger), boltapi.AccessLog(nil, boltapi.LogFormatStructured))
+			So(err, ShouldBeNil)
+
+			So(serve(restapi.GetHandler(), "GET", "/v1/buckets", nil, map[string]string{boltapi.RequestIdHeader: "client-id-3"}).Code, ShouldEqual, http.StatusOK)
+			entries := lines()
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0]["msg"], ShouldEqual, "request")
+			So(entries[0]["request_id"], ShouldEqual, "client-id-3")
+			So(entries[0]["method"], ShouldEqual, "GET")
+			So(entries[0]["path"], ShouldEqual, "/v1/buckets")
+			So(entries[0]["status"], ShouldEqual, http.StatusOK)
+		})
+
+		Convey("should log slow requests with their transactions", func() {
+			restapi, err := boltapi.NewRestApi(db, boltapi.Logger(logger), boltapi.SlowRequests(time.Nanosecond))
+			So(err, ShouldBeNil)
+
+			So(serve(restapi.GetHandler(), "GET", "/v1/buckets/bucket1", nil, map[string]string{boltapi.RequestIdHeader: "client-id-4"}).Code, ShouldEqual, http.StatusOK)
+			entries := lines()
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0]["level"], ShouldEqual, "WARN")
+			So(entries[0]["msg"], ShouldEqual, "slow request")
+			So(entries[0]["request_id"], ShouldEqual, "client-id-4")
+			transactions := entries[0]["transactions"].([]interface{})
+			So(len(transactions), ShouldEqual, 1)
+			So(transactions[0], ShouldStartWith, "view ")
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
@@ -0,0 +1,100 @@
+package boltapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/boltdb/bolt"
+)
+
+// ItemRef points to a single item, possibly in another bucket.
+type ItemRef struct {
+	Bucket string
+	Key    string
+}
+
+// MultiGetItem is a single entry in a multi-get response. Keys that aren't
+// present are returned with Missing set instead of failing the request.
+type MultiGetItem struct {
+	Bucket  string `json:",omitempty"`
+	Key     string
+	Value   interface{}
+	Missing bool
+}
+
+func (restapi *RestApi) MultiGetBucketItems(w rest.ResponseWriter, r *rest.Request) {
+	fail := func(cusromErr, origErr error) {
+		logError(r, cusromErr, origErr)
+		rest.Error(w, cusromErr.Error(), http.StatusInternalServerError)
+	}
+
+	bucketName := r.PathParam("name")
+	payload := struct{ Keys []string }{}
+	if err := r.DecodeJsonPayload(&payload); err != nil {
+		fail(ErrBucketKeysDecode, err)
+		return
+	}
+
+	items := make([]*MultiGetItem, 0, len(payload.Keys))
+	if err := restapi.view(r, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(strings.TrimSpace(bucketName)))
+		if bucket == nil {
+			return ErrBucketMissing
+		}
+
+		for _, key := range payload.Keys {
+			item := restapi.options.getMultiItem(bucket, bucketName, key)
+			item.Bucket = ""
+			items = append(items, item)
+		}
+		return nil
+	}); err != nil {
+		fail(err, nil)
+		return
+	}
+	w.WriteJson(items)
+}
+
+func (restapi *RestApi) MultiGetItems(w rest.ResponseWriter, r *rest.Request) {
+	fail := func(cusromErr, origErr error) {
+		logError(r, cusromErr, origErr)
+		rest.Error(w, cusromErr.Error(), http.StatusInternalServerError)
+	}
+
+	payload := struct{ Items []ItemRef }{}
+	if err := r.DecodeJsonPayload(&payload); err != nil {
+		fail(ErrBucketKeysDecode, err)
+		return
+	}
+
+	items := make([]*MultiGetItem, 0, len(payload.Items))
+	if err := restapi.view(r, func(tx *bolt.Tx) error {
+		for _, ref := range payload.Items {
+			bucket := tx.Bucket([]byte(strings.TrimSpace(ref.Bucket)))
+			if bucket == nil || restapi.options.hiddenBucket(strings.TrimSpace(ref.Bucket)) {
+				items = append(items, &MultiGetItem{Bucket: ref.Bucket, Key: ref.Key, Missing: true})
+				continue
+			}
+			items = append(items, restapi.options.getMultiItem(bucket, ref.Bucket, ref.Key))
+		}
+		return nil
+	}); err != nil {
+		fail(ErrBucketGet, err)
+		return
+	}
+	w.WriteJson(items)
+}
+
+func (o *options) getMultiItem(bucket *bolt.Bucket, bucketName, key string) *MultiGetItem {
+	item := &MultiGetItem{Bucket: bucketName, Key: key}
+	bucketItem := &BucketItem{Key: key}
+	value := bucket.Get(bucketItem.EncodeKey())
+	if value == nil {
+		item.Missing = true
+		return item
+	}
+	o.decodeStored(bucketName, bucketItem, value)
+	item.Value = bucketItem.Value
+	return item
+}
@@ -0,0 +1,68 @@
+package boltapi_test
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMultiGetEndpoint(t *testing.T) {
+	Convey("testing multi-get endpoint", t, func() {
+		restapi, db := prepDB(t)
+
+		for _, name := range []string{"bucket1", "bucket2"} {
+			request := createRequest("POST", "/api/v1/buckets", map[string]string{"name": name}, nil)
+			response := NewRecorder()
+			restapi.AddBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+		}
+
+		payload := map[string]interface{}{"key": "item1", "value": "apple"}
+		request := createRequest("POST", "/api/v1/buckets/bucket1", payload, map[string]string{"name": "bucket1"})
+		response := NewRecorder()
+		restapi.AddBucketItem(response, request)
+		So(response.Code, ShouldEqual, http.StatusOK)
+
+		payload = map[string]interface{}{"key": "item2", "value": "orange"}
+		request = createRequest("POST", "/api/v1/buckets/bucket2", payload, map[string]string{"name": "bucket2"})
+		response = NewRecorder()
+		restapi.AddBucketItem(response, request)
+		So(response.Code, ShouldEqual, http.StatusOK)
+
+		Convey("should be able to retrieve several bucket items", func() {
+			payload := map[string]interface{}{"keys": []string{"item1", "item3"}}
+			request := createRequest("POST", "/api/v1/buckets/bucket1/mget", payload, map[string]string{"name": "bucket1"})
+			response := NewRecorder()
+			restapi.MultiGetBucketItems(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `[{"Key":"item1","Value":"apple","Missing":false},{"Key":"item3","Value":null,"Missing":true}]`)
+
+			// non-existing bucket returns error
+			request = createRequest("POST", "/api/v1/buckets/bucket3/mget", payload, map[string]string{"name": "bucket3"})
+			response = NewRecorder()
+			restapi.MultiGetBucketItems(response, request)
+			So(response.Code, ShouldEqual, http.StatusInternalServerError)
+			So(response.Body.String(), ShouldEqual, `{"Error":"bucket doesn't exist"}`)
+		})
+
+		Convey("should be able to retrieve items across buckets", func() {
+			payload := map[string]interface{}{
+				"items": []map[string]string{
+					{"bucket": "bucket1", "key": "item1"},
+					{"bucket": "bucket2", "key": "item2"},
+					{"bucket": "bucket3", "key": "item1"},
+				},
+			}
+			request := createRequest("POST", "/api/v1/mget", payload, nil)
+			response := NewRecorder()
+			restapi.MultiGetItems(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `[{"Bucket":"bucket1","Key":"item1","Value":"apple","Missing":false},{"Bucket":"bucket2","Key":"item2","Value":"orange","Missing":false},{"Bucket":"bucket3","Key":"item1","Value":null,"Missing":true}]`)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
@@ -0,0 +1,354 @@
+package boltapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/boltdb/bolt"
+)
+
+var (
+	ErrDatabaseMissing     = errors.New("database isn't mounted")
+	ErrDatabaseMounted     = errors.New("database is already mounted")
+	ErrDatabaseInvalidName = errors.New("invalid database name")
+)
+
+// DatabaseConfig describes a database served by a MultiApi.
+type DatabaseConfig struct {
+	Name     string
+	Path     string
+	ReadOnly bool `json:",omitempty"`
+
+	// Users maps the names of users allowed to access the database with
+	// basic auth to their password. Databases without users are open to
+	// anyone.
+	Users map[string]string `json:",omitempty"`
+}
+
+// Database is what the database listing reports about a mounted database.
+type Database struct {
+	Name string
+	// Path is only listed to admins
+	Path     string `json:",omitempty"`
+	ReadOnly bool
+	Auth     bool
+}
+
+func (config *DatabaseConfig) database() *Database {
+	return &Database{
+		Name:     config.Name,
+		Path:     config.Path,
+		ReadOnly: config.ReadOnly,
+		Auth:     len(config.Users) > 0,
+	}
+}
+
+type mount struct {
+	config   *DatabaseConfig
+	db       *bolt.DB
+	restapi  *RestApi
+	handler  http.Handler
+	inflight sync.WaitGroup
+}
+
+// MultiApi serves several bolt databases, each mounted under
+// /v1/dbs/<name>/ with the same routes RestApi serves under /v1/.
+type MultiApi struct {
+	// AdminUsers maps the names of users allowed to use the admin
+	// endpoints to their password. The admin endpoints reject every
+	// request while it's empty.
+	AdminUsers map[string]string
+
+	// Dir is the directory paths given to the admin endpoints are relative
+	// to, the working directory when empty. They can't point outside of it.
+	Dir string
+
+	// BoltOptions are the options databases are opened with, besides
+	// ReadOnly which is set per database.
+	BoltOptions *bolt.Options
+
+	mu     sync.RWMutex
+	mounts map[string]*mount
+	admin  http.Handler
+	opts   []Option
+}
+
+// NewMultiApi serves databases and the admin endpoints with the options
+// NewRestApi takes.
+func NewMultiApi(opts ...Option) (*MultiApi, error) {
+	multi := &MultiApi{mounts: map[string]*mount{}, opts: opts}
+	admin, err := multi.adminHandler()
+	if err != nil {
+		return nil, err
+	}
+	multi.admin = admin
+	return multi, nil
+}
+
+// Mount opens the database described by config and starts serving it.
+func (multi *MultiApi) Mount(config *DatabaseConfig) error {
+	if config.Name == "" || strings.Contains(config.Name, "/") {
+		return ErrDatabaseInvalidName
+	}
+	if multi.mounted(config.Name) {
+		return ErrDatabaseMounted
+	}
+
+	options := &bolt.Options{Timeout: 1 * time.Second}
+	if multi.BoltOptions != nil {
+		copied := *multi.BoltOptions
+		options = &copied
+	}
+	options.ReadOnly = config.ReadOnly
+
+	db, err := bolt.Open(config.Path, 0600, options)
+	if err != nil {
+		return err
+	}
+	opts := append(append([]Option{}, multi.opts...), databaseName(config.Name))
+	restapi, err := NewRestApi(db, opts...)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	if len(config.Users) > 0 {
+		restapi.Use(BasicAuth(config.Name, config.Users))
+	}
+
+	multi.mu.Lock()
+	defer multi.mu.Unlock()
+	if _, ok := multi.mounts[config.Name]; ok {
+		db.Close()
+		return ErrDatabaseMounted
+	}
+	multi.mounts[config.Name] = &mount{config: config, db: db, restapi: restapi, handler: restapi.GetHandler()}
+	return nil
+}
+
+// Unmount stops serving the named database and closes it once the requests
+// it's serving are done.
+func (multi *MultiApi) Unmount(name string) error {
+	multi.mu.Lock()
+	m, ok := multi.mounts[name]
+	delete(multi.mounts, name)
+	multi.mu.Unlock()
+
+	if !ok {
+		return ErrDatabaseMissing
+	}
+	m.inflight.Wait()
+	return m.db.Close()
+}
+
+// Close unmounts every database, returning the first error met.
+func (multi *MultiApi) Close() error {
+	multi.mu.Lock()
+	mounts := multi.mounts
+	multi.mounts = map[string]*mount{}
+	multi.mu.Unlock()
+
+	var first error
+	for _, m := range mounts {
+		m.inflight.Wait()
+		if err := m.db.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Databases lists the mounted databases sorted by name.
+func (multi *MultiApi) Databases() []*Database {
+	return multi.databases(func(config *DatabaseConfig) bool {
+		return true
+	})
+}
+
+// visibleDatabases lists on /v1/dbs the databases open to anyone, and the
+// protected ones r carries the credentials of, without their paths. Admins
+// get them all from /v1/admin/dbs.
+func (multi *MultiApi) visibleDatabases(r *http.Request) []*Database {
+	user, password, _ := r.BasicAuth()
+	databases := multi.databases(func(config *DatabaseConfig) bool {
+		return len(config.Users) == 0 || checkPassword(config.Users, user, password)
+	})
+	for _, database := range databases {
+		database.Path = ""
+	}
+	return databases
+}
+
+func (multi *MultiApi) databases(visible func(config *DatabaseConfig) bool) []*Database {
+	multi.mu.RLock()
+	defer multi.mu.RUnlock()
+
+	databases := []*Database{}
+	for _, m := range multi.mounts {
+		if visible(m.config) {
+			databases = append(databases, m.config.database())
+		}
+	}
+	sort.Slice(databases, func(i, j int) bool {
+		return databases[i].Name < databases[j].Name
+	})
+	return databases
+}
+
+func (multi *MultiApi) mounted(name string) bool {
+	multi.mu.RLock()
+	defer multi.mu.RUnlock()
+	_, ok := multi.mounts[name]
+	return ok
+}
+
+// endpoints lists every route multi serves, those of the databases
+// prefixed with /v1/dbs/#db, for the OpenAPI document.
+func (multi *MultiApi) endpoints() []*endpoint {
+	endpoints := []*endpoint{
+		{
+			Method:      "GET",
+			PathExp:     "/v1/openapi.json",
+			OperationId: "GetMultiOpenApi",
+			Summary:     "OpenAPI description of the API",
+			Response:    anyValue,
+		},
+		{
+			Method:      "GET",
+			PathExp:     "/v1/dbs",
+			OperationId: "ListVisibleDatabases",
+			Summary:     "List the databases open to anyone and those the credentials give access to",
+			Response:    []Database{},
+		},
+	}
+
+	opts := append(append([]Option{}, multi.opts...), databaseName("db"))
+	restapi := &RestApi{options: newOptions(opts)}
+	for _, e := range restapi.endpoints() {
+		mounted := *e
+		mounted.PathExp = "/v1/dbs/#db" + strings.TrimPrefix(e.PathExp, "/v1")
+		endpoints = append(endpoints, &mounted)
+	}
+	return append(endpoints, multi.adminEndpoints()...)
+}
+
+// ServeHTTP lists the mounted databases on /v1/dbs and hands requests to
+// /v1/dbs/<name>/<path> to the database's api as /v1/<path>. Requests to
+// /v1/admin/ go to the admin endpoints, /v1/openapi.json describes them
+// all.
+func (multi *MultiApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the ids are kept by the mounted apis and the admin one
+	ensureRequestId(w.Header(), r)
+
+	if r.URL.EscapedPath() == "/v1/openapi.json" {
+		if r.Method != "GET" {
+			writeError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		writeJson(w, multi.OpenApi(openApiServer(r.RequestURI)), http.StatusOK)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.EscapedPath(), "/v1/admin/") {
+		multi.admin.ServeHTTP(w, r)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/v1/dbs")
+	if path == r.URL.EscapedPath() || (path != "" && path[0] != '/') {
+		writeError(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if path == "" || path == "/" {
+		if r.Method != "GET" {
+			writeError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		writeJson(w, multi.visibleDatabases(r), http.StatusOK)
+		return
+	}
+
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	name, err := url.PathUnescape(segments[0])
+	if err != nil {
+		writeError(w, ErrDatabaseInvalidName.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, ok := multi.acquire(name)
+	if !ok {
+		writeError(w, ErrDatabaseMissing.Error(), http.StatusNotFound)
+		return
+	}
+	defer m.inflight.Done()
+
+	rawPath := "/v1/"
+	if len(segments) == 2 {
+		rawPath += segments[1]
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.RawPath = rawPath
+	if r2.URL.Path, err = url.PathUnescape(rawPath); err != nil {
+		writeError(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	m.handler.ServeHTTP(w, r2)
+}
+
+// acquire returns the named mount, counting a request it serves until
+// inflight.Done is called. Requests are counted while holding the lock so
+// Unmount can't miss one it has to wait for.
+func (multi *MultiApi) acquire(name string) (*mount, bool) {
+	multi.mu.RLock()
+	defer multi.mu.RUnlock()
+	m, ok := multi.mounts[name]
+	if ok {
+		m.inflight.Add(1)
+	}
+	return m, ok
+}
+
+// ServeMulti serves every database mounted on multi under /api/v1/dbs/.
+func ServeMulti(multi *MultiApi, port int) error {
+	return http.ListenAndServe(fmt.Sprintf(":%d", port), multi.ServeMux())
+}
+
+// ServeMux routes /api/ to multi and /ui/ to the admin UI, as ServeMulti
+// does.
+func (multi *MultiApi) ServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/api/", http.StripPrefix("/api", multi))
+	mux.Handle("/ui/", http.StripPrefix("/ui", UIHandler()))
+	return mux
+}
+
+// writeJson and writeError respond the way go-json-rest's indenting
+// middleware does, for handlers living outside of a rest.Api.
+func writeJson(w http.ResponseWriter, v interface{}, code int) {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(content)
+}
+
+func writeError(w http.ResponseWriter, error string, code int) {
+	writeJson(w, map[string]string{
+		rest.ErrorFieldName: error,
+		"RequestId":         w.Header().Get(RequestIdHeader),
+	}, code)
+}
@@ -0,0 +1,128 @@
+package boltapi_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMultiApi(t *testing.T) {
+	Convey("testing multiple databases", t, func() {
+		dir, err := ioutil.TempDir("", "boltapi")
+		So(err, ShouldBeNil)
+
+		multi, err := boltapi.NewMultiApi()
+		So(err, ShouldBeNil)
+		So(multi.Mount(&boltapi.DatabaseConfig{Name: "db1", Path: filepath.Join(dir, "db1.db")}), ShouldBeNil)
+		So(multi.Mount(&boltapi.DatabaseConfig{
+			Name:  "db2",
+			Path:  filepath.Join(dir, "db2.db"),
+			Users: map[string]string{"admin": "secret"},
+		}), ShouldBeNil)
+
+		admin := map[string]string{"Authorization": "Basic YWRtaW46c2VjcmV0"}
+
+		Convey("should list mounted databases", func() {
+			response := serve(multi, "GET", "/v1/dbs", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			databases := []*boltapi.Database{}
+			So(json.Unmarshal(response.Body.Bytes(), &databases), ShouldBeNil)
+			So(len(databases), ShouldEqual, 1)
+			So(databases[0].Name, ShouldEqual, "db1")
+			So(databases[0].Auth, ShouldBeFalse)
+			So(databases[0].Path, ShouldBeEmpty)
+
+			response = serve(multi, "GET", "/v1/dbs", nil, admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldNotContainSubstring, dir)
+			databases = []*boltapi.Database{}
+			So(json.Unmarshal(response.Body.Bytes(), &databases), ShouldBeNil)
+			So(len(databases), ShouldEqual, 2)
+			So(databases[1].Name, ShouldEqual, "db2")
+			So(databases[1].Auth, ShouldBeTrue)
+		})
+
+		Convey("should keep databases apart", func() {
+			response := serve(multi, "POST", "/v1/dbs/db1/buckets", strings.NewReader(`{"name": "bucket1"}`), nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			response = serve(multi, "PUT", "/v1/dbs/db1/buckets/bucket1/a%2Fb", strings.NewReader(`"value"`), nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			response = serve(multi, "GET", "/v1/dbs/db1/buckets/bucket1/a%2Fb", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldContainSubstring, "value")
+
+			response = serve(multi, "GET", "/v1/dbs/db2/buckets", nil, admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldNotContainSubstring, "bucket1")
+		})
+
+		Convey("should require credentials of protected databases", func() {
+			response := serve(multi, "GET", "/v1/dbs/db2/buckets", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusUnauthorized)
+
+			response = serve(multi, "GET", "/v1/dbs/db2/buckets", nil, admin)
+			So(response.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("should 404 on databases that aren't mounted", func() {
+			response := serve(multi, "GET", "/v1/dbs/db3/buckets", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+			So(response.Body.String(), ShouldContainSubstring, boltapi.ErrDatabaseMissing.Error())
+			id := response.Header().Get(boltapi.RequestIdHeader)
+			So(id, ShouldNotBeEmpty)
+			So(response.Body.String(), ShouldContainSubstring, id)
+
+			response = serve(multi, "GET", "/v1/dbsdb1/buckets", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("should refuse mounting a name twice", func() {
+			err := multi.Mount(&boltapi.DatabaseConfig{Name: "db1", Path: filepath.Join(dir, "other.db")})
+			So(err, ShouldEqual, boltapi.ErrDatabaseMounted)
+
+			err = multi.Mount(&boltapi.DatabaseConfig{Name: "a/b", Path: filepath.Join(dir, "other.db")})
+			So(err, ShouldEqual, boltapi.ErrDatabaseInvalidName)
+		})
+
+		Convey("should serve read-only databases", func() {
+			So(multi.Unmount("db1"), ShouldBeNil)
+			So(multi.Mount(&boltapi.DatabaseConfig{Name: "db1", Path: filepath.Join(dir, "db1.db"), ReadOnly: true}), ShouldBeNil)
+
+			response := serve(multi, "GET", "/v1/dbs/db1/buckets", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			response = serve(multi, "POST", "/v1/dbs/db1/buckets", strings.NewReader(`{"name": "bucket2"}`), nil)
+			So(response.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+
+		Convey("should serve the api and the ui", func() {
+			mux := multi.ServeMux()
+			for path, code := range map[string]int{
+				"/api/v1/dbs":                 http.StatusOK,
+				"/api/v1/dbs/db1/buckets":     http.StatusOK,
+				"/ui/":                        http.StatusOK,
+				"/ui/app.js":                  http.StatusOK,
+				"/api/v1/dbs/missing/buckets": http.StatusNotFound,
+			} {
+				response := httptest.NewRecorder()
+				mux.ServeHTTP(response, httptest.NewRequest("GET", path, nil))
+				So(response.Code, ShouldEqual, code)
+			}
+		})
+
+		Reset(func() {
+			multi.Close()
+			os.RemoveAll(dir)
+		})
+	})
+}
@@ -0,0 +1,249 @@
+package boltapi
+
+import (
+	_ "embed"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+//go:embed explorer.html
+var explorerPage []byte
+
+// OpenApiVersion is the version of the API reported in the OpenAPI document.
+const OpenApiVersion = "1.0.0"
+
+func (restapi *RestApi) GetOpenApi(w rest.ResponseWriter, r *rest.Request) {
+	w.WriteJson(restapi.OpenApi(openApiServer(r.RequestURI)))
+}
+
+// openApiServer reports where the API is mounted, e.g. /api when started
+// through Serve, from the URI its document was requested at.
+func openApiServer(requestURI string) string {
+	server := strings.SplitN(requestURI, "?", 2)[0]
+	server = strings.TrimSuffix(server, "/v1/openapi.json")
+	if server == "" {
+		server = "/"
+	}
+	return server
+}
+
+func (restapi *RestApi) GetExplorer(w rest.ResponseWriter, r *rest.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.(http.ResponseWriter).Write(explorerPage)
+}
+
+// OpenApi builds the OpenAPI 3 document describing every route of the API,
+// with server as the URL the API is mounted on.
+func (restapi *RestApi) OpenApi(server string) map[string]interface{} {
+	return openApi(server, restapi.endpoints())
+}
+
+// OpenApi builds the OpenAPI 3 document describing the database listing,
+// the routes of every database under /v1/dbs/{db} and the admin endpoints.
+func (multi *MultiApi) OpenApi(server string) map[string]interface{} {
+	return openApi(server, multi.endpoints())
+}
+
+func openApi(server string, endpoints []*endpoint) map[string]interface{} {
+	schemas := schemaSet{
+		"Error": object{
+			"type": "object",
+			"properties": object{
+				rest.ErrorFieldName: object{"type": "string"},
+				"RequestId":         object{"type": "string"},
+			},
+		},
+	}
+
+	paths := object{}
+	for _, e := range endpoints {
+		path, params := openApiPath(e.PathExp)
+		for _, q := range e.Query {
+			params = append(params, object{
+				"name":        q.Name,
+				"in":          "query",
+				"description": q.Description,
+				"schema":      object{"type": q.Type},
+			})
+		}
+
+		responses := object{
+			"default": object{
+				"description": "Error",
+				"content": object{
+					"application/json": object{"schema": object{"$ref": "#/components/schemas/Error"}},
+				},
+			},
+		}
+		status := e.Status
+		if len(status) == 0 {
+			status = []int{http.StatusOK}
+		}
+		for _, code := range status {
+			response := object{"description": http.StatusText(code)}
+			if e.Response != nil {
+				response["content"] = schemas.content(e.ResponseTypes, e.Response)
+			}
+			responses[strconv.Itoa(code)] = response
+		}
+
+		operationId := e.OperationId
+		if operationId == "" {
+			operationId = handlerName(e.Func)
+		}
+		operation := object{
+			"operationId": operationId,
+			"summary":     e.Summary,
+			"parameters":  params,
+			"responses":   responses,
+		}
+		if e.Body != nil {
+			operation["requestBody"] = object{
+				"required": true,
+				"content":  schemas.content(e.BodyTypes, e.Body),
+			}
+		}
+
+		if _, ok := paths[path]; !ok {
+			paths[path] = object{}
+		}
+		paths[path].(object)[strings.ToLower(e.Method)] = operation
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   "BoltDB REST API",
+			"version": OpenApiVersion,
+		},
+		"servers":    []object{{"url": server}},
+		"paths":      paths,
+		"components": object{"schemas": schemas},
+	}
+}
+
+type object map[string]interface{}
+
+// schemaSet collects the named types referenced by the document.
+type schemaSet map[string]interface{}
+
+// content describes a payload sent as any of types, JSON when empty.
+// Only JSON payloads are described by the schema of sample, the others
+// are binary.
+func (schemas schemaSet) content(types []string, sample interface{}) object {
+	if len(types) == 0 {
+		types = []string{JsonCodec.ContentType()}
+	}
+	content := object{}
+	for _, contentType := range types {
+		schema := object{"type": "string", "format": "binary"}
+		if contentType == JsonCodec.ContentType() {
+			schema = schemas.of(reflect.TypeOf(sample))
+		}
+		content[contentType] = object{"schema": schema}
+	}
+	return content
+}
+
+// of returns the JSON schema of t, following the same rules encoding/json
+// uses. Named structs are added as components and referenced.
+func (schemas schemaSet) of(t reflect.Type) object {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return object{}
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return object{"type": "string", "format": "byte"}
+		}
+		return object{"type": "array", "items": schemas.of(t.Elem())}
+	case reflect.Map:
+		return object{"type": "object", "additionalProperties": schemas.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return schemas.object(t)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			// reserve the name first in case the type refers to itself
+			schemas[t.Name()] = object{}
+			schemas[t.Name()] = schemas.object(t)
+		}
+		return object{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return object{}
+}
+
+func (schemas schemaSet) object(t reflect.Type) object {
+	properties := object{}
+	schemas.fields(t, properties)
+	return object{"type": "object", "properties": properties}
+}
+
+func (schemas schemaSet) fields(t reflect.Type, properties object) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		if field.Anonymous && tag[0] == "" && field.Type.Kind() == reflect.Struct {
+			schemas.fields(field.Type, properties)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Name
+		if tag[0] != "" {
+			name = tag[0]
+		}
+		properties[name] = schemas.of(field.Type)
+	}
+}
+
+// openApiPath converts a router path expression into an OpenAPI path
+// template along with its path parameters.
+func openApiPath(pathExp string) (string, []object) {
+	params := []object{}
+	segments := strings.Split(pathExp, "/")
+	for i, segment := range segments {
+		if segment == "" || !strings.ContainsAny(segment[:1], ":#*") {
+			continue
+		}
+		name := segment[1:]
+		segments[i] = "{" + name + "}"
+		params = append(params, object{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   object{"type": "string"},
+		})
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// handlerName returns the name of the RestApi method behind a handler.
+func handlerName(f rest.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	return name[strings.LastIndex(name, ".")+1:]
+}
@@ -0,0 +1,118 @@
+package boltapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOpenApiEndpoint(t *testing.T) {
+	Convey("testing openapi endpoint", t, func() {
+		restapi, db := prepDB(t)
+
+		Convey("should describe every route", func() {
+			request := createRequest("GET", "/api/v1/openapi.json", nil, nil)
+			response := NewRecorder()
+			restapi.GetOpenApi(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			doc := struct {
+				OpenApi    string
+				Paths      map[string]map[string]map[string]interface{}
+				Components struct {
+					Schemas map[string]interface{}
+				}
+			}{}
+			So(json.Unmarshal(response.Body.Bytes(), &doc), ShouldBeNil)
+			So(doc.OpenApi, ShouldEqual, "3.0.3")
+			So(doc.Paths, ShouldContainKey, "/v1/buckets/{name}/{key}")
+
+			item := doc.Paths["/v1/buckets/{name}/{key}"]
+			So(item, ShouldContainKey, "get")
+			So(item, ShouldContainKey, "head")
+			So(item, ShouldContainKey, "put")
+			So(item, ShouldContainKey, "delete")
+			So(item["get"]["operationId"], ShouldEqual, "GetBucketItem")
+			So(doc.Components.Schemas, ShouldContainKey, "BucketItem")
+			So(doc.Components.Schemas, ShouldContainKey, "Error")
+		})
+
+		Convey("should describe statuses and content types", func() {
+			encoded, err := json.Marshal(restapi.OpenApi("/"))
+			So(err, ShouldBeNil)
+
+			type content map[string]interface{}
+			type operation struct {
+				Responses   map[string]struct{ Content content }
+				RequestBody struct{ Content content }
+			}
+			doc := struct {
+				Paths      map[string]map[string]operation
+				Components struct {
+					Schemas map[string]struct {
+						Properties map[string]interface{}
+					}
+				}
+			}{}
+			So(json.Unmarshal(encoded, &doc), ShouldBeNil)
+
+			So(doc.Paths["/v1/buckets/{name}"]["post"].Responses, ShouldContainKey, "201")
+			So(doc.Paths["/v1/buckets/{name}/reencrypt"]["post"].Responses, ShouldContainKey, "202")
+			So(doc.Paths["/v1/buckets/{name}/reencrypt"]["post"].Responses, ShouldNotContainKey, "200")
+			So(doc.Paths["/v1/buckets/{name}/{key}/blob"]["get"].Responses, ShouldContainKey, "206")
+
+			upload := doc.Paths["/v1/buckets/{name}/{key}/blob"]["put"].RequestBody.Content
+			So(upload, ShouldContainKey, "application/octet-stream")
+			So(upload, ShouldNotContainKey, "application/json")
+
+			item := doc.Paths["/v1/buckets/{name}/{key}"]["get"].Responses["200"].Content
+			So(item, ShouldContainKey, "application/json")
+			So(item, ShouldContainKey, "application/msgpack")
+			So(item, ShouldContainKey, "application/octet-stream")
+
+			So(doc.Components.Schemas["Error"].Properties, ShouldContainKey, "RequestId")
+		})
+
+		Convey("should serve the explorer page", func() {
+			request := createRequest("GET", "/api/v1/explorer", nil, nil)
+			response := NewRecorder()
+			restapi.GetExplorer(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Header().Get("Content-Type"), ShouldEqual, "text/html; charset=utf-8")
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
+
+func TestMultiOpenApiEndpoint(t *testing.T) {
+	Convey("testing the openapi endpoint of a MultiApi", t, func() {
+		multi, err := boltapi.NewMultiApi()
+		So(err, ShouldBeNil)
+
+		response := serve(multi, "GET", "/v1/openapi.json", nil, nil)
+		So(response.Code, ShouldEqual, http.StatusOK)
+
+		doc := struct {
+			Paths map[string]map[string]map[string]interface{}
+		}{}
+		So(json.Unmarshal(response.Body.Bytes(), &doc), ShouldBeNil)
+		So(doc.Paths, ShouldContainKey, "/v1/dbs")
+		So(doc.Paths, ShouldContainKey, "/v1/dbs/{db}/buckets/{name}/{key}")
+		So(doc.Paths, ShouldContainKey, "/v1/admin/dbs")
+		So(doc.Paths, ShouldContainKey, "/v1/admin/dbs/{name}")
+		So(doc.Paths, ShouldContainKey, "/v1/admin/audit")
+		So(doc.Paths, ShouldNotContainKey, "/v1/dbs/{db}/admin/audit")
+		So(doc.Paths["/v1/dbs"]["get"]["operationId"], ShouldEqual, "ListVisibleDatabases")
+		So(doc.Paths["/v1/admin/dbs"]["post"]["operationId"], ShouldEqual, "AttachDatabase")
+
+		Reset(func() {
+			multi.Close()
+		})
+	})
+}
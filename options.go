@@ -0,0 +1,281 @@
+package boltapi
+
+import (
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Access log formats, the first three are go-json-rest's Apache-like ones.
+// LogFormatStructured writes to the Logger instead, with the request ids.
+const (
+	LogFormatDefault    = "default"
+	LogFormatCommon     = "common"
+	LogFormatCombined   = "combined"
+	LogFormatJson       = "json"
+	LogFormatStructured = "structured"
+	LogFormatNone       = "none"
+)
+
+var ErrLogFormat = errors.New("unknown access log format")
+
+// Option changes how NewRestApi sets up the api.
+type Option func(*options)
+
+type options struct {
+	indent      bool
+	logWriter   io.Writer
+	logFormat   string
+	logger      *slog.Logger
+	slow        time.Duration
+	tracer      trace.Tracer
+	stackTrace  bool
+	maxBodySize int64
+	middlewares []rest.Middleware
+	cors        *CorsPolicy
+	rateLimits  *RateLimits
+	maxScans    int
+	audit       *auditLog
+	database    string
+
+	blobChunkSize int
+
+	defaultCodec Codec
+	codecs       map[string]Codec
+	compressions map[string]Compression
+	keyring      *Keyring
+	encrypted    map[string]bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		indent:        true,
+		logWriter:     os.Stderr,
+		logFormat:     LogFormatDefault,
+		maxBodySize:   DefaultMaxBodySize,
+		blobChunkSize: DefaultBlobChunkSize,
+		defaultCodec:  JsonCodec,
+		codecs:        map[string]Codec{},
+		compressions:  map[string]Compression{},
+		encrypted:     map[string]bool{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// CompactJson writes responses without indenting them.
+func CompactJson() Option {
+	return func(o *options) {
+		o.indent = false
+	}
+}
+
+// AccessLog writes a line per request to w in one of the LogFormat*
+// formats. It defaults to the colored LogFormatDefault on stderr.
+func AccessLog(w io.Writer, format string) Option {
+	return func(o *options) {
+		o.logWriter = w
+		o.logFormat = format
+	}
+}
+
+// Logger logs errors and events to logger instead of slog.Default(), every
+// line of a request carrying its request_id.
+func Logger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// SlowRequests logs a warning for requests taking threshold or longer,
+// with the duration of the transactions they ran.
+func SlowRequests(threshold time.Duration) Option {
+	return func(o *options) {
+		o.slow = threshold
+	}
+}
+
+// Tracing traces requests with the tracers of provider, along with the
+// transactions they run. Requests continue the traces of their W3C
+// traceparent header.
+func Tracing(provider trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracer = provider.Tracer(tracerName)
+	}
+}
+
+// ResponseStackTrace includes the stack trace in responses to requests
+// whose handler panicked. It's meant for development, as it leaks details
+// of the server to clients.
+func ResponseStackTrace() Option {
+	return func(o *options) {
+		o.stackTrace = true
+	}
+}
+
+// MaxBodySize answers 413 Request Entity Too Large to requests whose body
+// is larger than size bytes instead of DefaultMaxBodySize, or lets any size
+// through when it's not positive.
+func MaxBodySize(size int64) Option {
+	return func(o *options) {
+		o.maxBodySize = size
+	}
+}
+
+// BlobChunkSize splits blobs uploaded from then on into chunks of size
+// bytes instead of DefaultBlobChunkSize.
+func BlobChunkSize(size int) Option {
+	return func(o *options) {
+		if size > 0 {
+			o.blobChunkSize = size
+		}
+	}
+}
+
+// Cors lets browsers call the api from the origins of policy.
+func Cors(policy CorsPolicy) Option {
+	return func(o *options) {
+		methods := []string{}
+		for _, method := range policy.Methods {
+			methods = append(methods, strings.ToUpper(method))
+		}
+		policy.Methods = methods
+		o.cors = &policy
+	}
+}
+
+// RateLimit limits the reads and writes of every client, answering 429 Too
+// Many Requests past them.
+func RateLimit(limits RateLimits) Option {
+	return func(o *options) {
+		o.rateLimits = &limits
+	}
+}
+
+// ScanConcurrency lets at most n requests go through whole buckets at once,
+// like listings, answering 503 Service Unavailable to the others. It's
+// applied per database.
+func ScanConcurrency(n int) Option {
+	return func(o *options) {
+		o.maxScans = n
+	}
+}
+
+// Audit records every mutation as a line of JSON written to w, and in the
+// bucket named bucket of the database, in the same transaction, when they
+// aren't empty. Clients can't modify the audit bucket.
+func Audit(w io.Writer, bucket string) Option {
+	audit := &auditLog{writer: w, bucket: bucket}
+	return func(o *options) {
+		o.audit = audit
+	}
+}
+
+// databaseName names the database in the audit log of a MultiApi.
+func databaseName(name string) Option {
+	return func(o *options) {
+		o.database = name
+	}
+}
+
+// Middlewares appends middlewares to the default ones, they run after them
+// and before the handlers.
+func Middlewares(middlewares ...rest.Middleware) Option {
+	return func(o *options) {
+		o.middlewares = append(o.middlewares, middlewares...)
+	}
+}
+
+// DefaultCodec stores values with codec in buckets without a codec of their
+// own, instead of JSON.
+func DefaultCodec(codec Codec) Option {
+	return func(o *options) {
+		o.defaultCodec = codec
+	}
+}
+
+// BucketCodec stores the values of bucket with codec. Values are converted
+// to the content type clients ask for, so they can still be read as JSON.
+func BucketCodec(bucket string, codec Codec) Option {
+	return func(o *options) {
+		o.codecs[bucket] = codec
+	}
+}
+
+// BucketCompression compresses the values stored in bucket. Values stored
+// before, or not shrinking, are left uncompressed, see Recompress to
+// rewrite existing ones.
+func BucketCompression(bucket string, compression Compression) Option {
+	return func(o *options) {
+		o.compressions[bucket] = compression
+	}
+}
+
+// Encryption encrypts the values stored in buckets with the primary key of
+// keyring, and decrypts values of any bucket encrypted with one of its keys.
+// Values stored before are left as is, see Reencrypt.
+func Encryption(keyring *Keyring, buckets ...string) Option {
+	return func(o *options) {
+		o.keyring = keyring
+		for _, bucket := range buckets {
+			o.encrypted[bucket] = true
+		}
+	}
+}
+
+// stack builds the middlewares the options ask for, in front of routes.
+func (o *options) stack(routes []*rest.Route) ([]rest.Middleware, error) {
+	logger := log.New(o.logWriter, "", 0)
+
+	stack := []rest.Middleware{}
+	if o.tracer != nil {
+		// outermost, for the spans to cover the whole request
+		stack = append(stack, &requestTracer{tracer: o.tracer})
+	}
+	switch o.logFormat {
+	case LogFormatDefault:
+		stack = append(stack, &rest.AccessLogApacheMiddleware{Logger: logger, Format: rest.DefaultLogFormat})
+	case LogFormatCommon:
+		stack = append(stack, &rest.AccessLogApacheMiddleware{Logger: logger, Format: rest.CommonLogFormat})
+	case LogFormatCombined:
+		stack = append(stack, &rest.AccessLogApacheMiddleware{Logger: logger, Format: rest.CombinedLogFormat})
+	case LogFormatJson:
+		stack = append(stack, &rest.AccessLogJsonMiddleware{Logger: logger})
+	case LogFormatStructured:
+		stack = append(stack, &structuredAccessLog{logger: o.log})
+	case LogFormatNone:
+	default:
+		return nil, ErrLogFormat
+	}
+
+	stack = append(stack,
+		&rest.TimerMiddleware{},
+		&rest.RecorderMiddleware{},
+	)
+	if o.indent {
+		stack = append(stack, &rest.JsonIndentMiddleware{})
+	}
+	// wraps the indenting writer to add ids to error bodies, those of
+	// panics included
+	stack = append(stack,
+		&requestIdentifier{logger: o.log, slow: o.slow},
+		&panicRecoverer{stackTrace: o.stackTrace},
+	)
+	if o.cors != nil {
+		stack = append(stack, &corsChecker{policy: *o.cors, routes: routes})
+	}
+	if o.maxBodySize > 0 {
+		stack = append(stack, &bodyLimiter{limit: o.maxBodySize})
+	}
+	stack = append(stack, &contentTypeChecker{options: o})
+	return append(stack, o.middlewares...), nil
+}
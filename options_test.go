@@ -0,0 +1,113 @@
+package boltapi_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOptions(t *testing.T) {
+	Convey("testing api options", t, func() {
+		_, db := prepDB(t)
+
+		get := func(restapi *boltapi.RestApi, url string) *httptest.ResponseRecorder {
+			response := httptest.NewRecorder()
+			restapi.GetHandler().ServeHTTP(response, httptest.NewRequest("GET", url, nil))
+			return response
+		}
+
+		panicking := rest.MiddlewareSimple(func(handler rest.HandlerFunc) rest.HandlerFunc {
+			return func(w rest.ResponseWriter, r *rest.Request) {
+				panic("broken handler")
+			}
+		})
+
+		Convey("should indent responses by default", func() {
+			restapi, err := boltapi.NewRestApi(db, boltapi.AccessLog(&bytes.Buffer{}, boltapi.LogFormatNone))
+			So(err, ShouldBeNil)
+			So(get(restapi, "/v1/stats").Body.String(), ShouldContainSubstring, "\n  ")
+
+			restapi, err = boltapi.NewRestApi(db, boltapi.CompactJson(), boltapi.AccessLog(&bytes.Buffer{}, boltapi.LogFormatNone))
+			So(err, ShouldBeNil)
+			So(get(restapi, "/v1/stats").Body.String(), ShouldNotContainSubstring, "\n  ")
+		})
+
+		Convey("should write the access log where asked", func() {
+			log := &bytes.Buffer{}
+			restapi, err := boltapi.NewRestApi(db, boltapi.AccessLog(log, boltapi.LogFormatJson))
+			So(err, ShouldBeNil)
+			get(restapi, "/v1/buckets")
+			So(log.String(), ShouldContainSubstring, `"RequestURI":"/v1/buckets"`)
+
+			log.Reset()
+			restapi, err = boltapi.NewRestApi(db, boltapi.AccessLog(log, boltapi.LogFormatCommon))
+			So(err, ShouldBeNil)
+			get(restapi, "/v1/buckets")
+			So(log.String(), ShouldContainSubstring, `"GET /v1/buckets HTTP/1.1" 200`)
+
+			_, err = boltapi.NewRestApi(db, boltapi.AccessLog(log, "verbose"))
+			So(err, ShouldEqual, boltapi.ErrLogFormat)
+		})
+
+		Convey("should only expose stack traces when asked", func() {
+			restapi, err := boltapi.NewRestApi(db,
+				boltapi.AccessLog(&bytes.Buffer{}, boltapi.LogFormatNone),
+				boltapi.Middlewares(panicking))
+			So(err, ShouldBeNil)
+			response := get(restapi, "/v1/buckets")
+			So(response.Code, ShouldEqual, http.StatusInternalServerError)
+			So(response.Body.String(), ShouldNotContainSubstring, "broken handler")
+
+			restapi, err = boltapi.NewRestApi(db,
+				boltapi.AccessLog(&bytes.Buffer{}, boltapi.LogFormatNone),
+				boltapi.ResponseStackTrace(),
+				boltapi.Middlewares(panicking))
+			So(err, ShouldBeNil)
+			response = get(restapi, "/v1/buckets")
+			So(response.Code, ShouldEqual, http.StatusInternalServerError)
+			So(response.Body.String(), ShouldContainSubstring, "broken handler")
+		})
+
+		Convey("should log panics with the request id", func() {
+			log := &bytes.Buffer{}
+			restapi, err := boltapi.NewRestApi(db,
+				boltapi.AccessLog(&bytes.Buffer{}, boltapi.LogFormatNone),
+				boltapi.Logger(slog.New(slog.NewTextHandler(log, nil))),
+				boltapi.Middlewares(panicking))
+			So(err, ShouldBeNil)
+
+			request := httptest.NewRequest("GET", "/v1/buckets", nil)
+			request.Header.Set(boltapi.RequestIdHeader, "request-1")
+			response := httptest.NewRecorder()
+			restapi.GetHandler().ServeHTTP(response, request)
+			So(response.Code, ShouldEqual, http.StatusInternalServerError)
+			So(response.Body.String(), ShouldContainSubstring, `"RequestId": "request-1"`)
+			So(log.String(), ShouldContainSubstring, "request_id=request-1")
+			So(log.String(), ShouldContainSubstring, "broken handler")
+		})
+
+		Convey("should run custom middlewares", func() {
+			tagging := rest.MiddlewareSimple(func(handler rest.HandlerFunc) rest.HandlerFunc {
+				return func(w rest.ResponseWriter, r *rest.Request) {
+					w.Header().Set("X-Served-By", "boltapi")
+					handler(w, r)
+				}
+			})
+			restapi, err := boltapi.NewRestApi(db,
+				boltapi.AccessLog(&bytes.Buffer{}, boltapi.LogFormatNone),
+				boltapi.Middlewares(tagging))
+			So(err, ShouldBeNil)
+			So(get(restapi, "/v1/buckets").Header().Get("X-Served-By"), ShouldEqual, "boltapi")
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
@@ -0,0 +1,214 @@
+package boltapi
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+var (
+	ErrRateLimited     = errors.New("too many requests")
+	ErrScanConcurrency = errors.New("too many scans running")
+)
+
+// rateLimiterSweep is how often clients idle long enough to be back to a
+// full burst are forgotten.
+const rateLimiterSweep = time.Minute
+
+// Rate allows PerSecond requests per second on average, in bursts of up to
+// Burst requests. A zero Rate doesn't limit anything.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// RateLimits limits the requests of every client, reads and writes apart,
+// as set by the Write flag of the endpoints. Clients are told apart by the
+// user they authenticated as, or by IP otherwise.
+type RateLimits struct {
+	Reads  Rate
+	Writes Rate
+	// IdentityHeader names a header identifying unauthenticated clients,
+	// like an API key checked by a proxy in front of the api. It isn't
+	// checked here, so it's only read from the TrustedProxies.
+	IdentityHeader string
+	// TrustedProxies lists the IPs or CIDR ranges of the proxies setting
+	// IdentityHeader.
+	TrustedProxies []string
+}
+
+// tokenBucket holds up to burst tokens, refilled at the rate, one of which
+// is taken by every request.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token, or tells how long until there's one.
+func (bucket *tokenBucket) take(rate Rate, now time.Time) (time.Duration, bool) {
+	bucket.tokens = math.Min(float64(rate.burst()), bucket.tokens+now.Sub(bucket.last).Seconds()*rate.PerSecond)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - bucket.tokens) / rate.PerSecond * float64(time.Second)), false
+}
+
+func (rate Rate) burst() int {
+	if rate.Burst < 1 {
+		return 1
+	}
+	return rate.Burst
+}
+
+// full tells how long it takes an empty bucket to refill.
+func (rate Rate) full() time.Duration {
+	return time.Duration(float64(rate.burst()) / rate.PerSecond * float64(time.Second))
+}
+
+type rateLimiter struct {
+	limits  RateLimits
+	proxies []*net.IPNet
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(limits RateLimits) (*rateLimiter, error) {
+	limiter := &rateLimiter{
+		limits:    limits,
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+	for _, proxy := range limits.TrustedProxies {
+		if ip := net.ParseIP(proxy); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			limiter.proxies = append(limiter.proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %s", proxy, err)
+		}
+		limiter.proxies = append(limiter.proxies, network)
+	}
+	return limiter, nil
+}
+
+// allow takes a token from the bucket of the client for reads or writes.
+func (limiter *rateLimiter) allow(client string, write bool, now time.Time) (time.Duration, bool) {
+	rate, kind := limiter.limits.Reads, "r:"
+	if write {
+		rate, kind = limiter.limits.Writes, "w:"
+	}
+	if rate.PerSecond <= 0 {
+		return 0, true
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if now.Sub(limiter.lastSweep) >= rateLimiterSweep {
+		limiter.sweep(now)
+	}
+	bucket, ok := limiter.buckets[kind+client]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(rate.burst()), last: now}
+		limiter.buckets[kind+client] = bucket
+	}
+	return bucket.take(rate, now)
+}
+
+// sweep forgets the clients whose buckets are full again.
+func (limiter *rateLimiter) sweep(now time.Time) {
+	limiter.lastSweep = now
+	for key, bucket := range limiter.buckets {
+		rate := limiter.limits.Reads
+		if key[0] == 'w' {
+			rate = limiter.limits.Writes
+		}
+		if now.Sub(bucket.last) >= rate.full() {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+// client identifies who sent the request.
+func (limiter *rateLimiter) client(r *rest.Request) string {
+	if user, ok := r.Env["REMOTE_USER"].(string); ok && user != "" {
+		return "user:" + user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if header := limiter.limits.IdentityHeader; header != "" && r.Header.Get(header) != "" && limiter.trusted(host) {
+		return "key:" + r.Header.Get(header)
+	}
+	return "ip:" + host
+}
+
+// trusted tells whether host is one of the trusted proxies.
+func (limiter *rateLimiter) trusted(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range limiter.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitRate answers 429 Too Many Requests once the client used up its
+// reads or writes, telling when to retry in Retry-After.
+func (limiter *rateLimiter) limitRate(write bool, handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		client := limiter.client(r)
+		if wait, ok := limiter.allow(client, write, time.Now()); !ok {
+			requestLogOf(r).logger.Warn(ErrRateLimited.Error(), "client", client)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			rest.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// capScans answers 503 Service Unavailable to requests going through whole
+// buckets while as many as the scans channel holds are already running.
+func capScans(scans chan struct{}, scan func(*rest.Request) bool, handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		if !scan(r) {
+			handler(w, r)
+			return
+		}
+		select {
+		case scans <- struct{}{}:
+			defer func() { <-scans }()
+			handler(w, r)
+		default:
+			requestLogOf(r).logger.Warn(ErrScanConcurrency.Error())
+			w.Header().Set("Retry-After", "1")
+			rest.Error(w, ErrScanConcurrency.Error(), http.StatusServiceUnavailable)
+		}
+	}
+}
+
+// scanAlways marks endpoints always going through whole buckets.
+func scanAlways(*rest.Request) bool {
+	return true
+}
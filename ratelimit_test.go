@@ -0,0 +1,146 @@
+package boltapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// blockingRecorder holds the first write until released, keeping the
+// request running.
+type blockingRecorder struct {
+	*httptest.ResponseRecorder
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingRecorder) Write(p []byte) (int, error) {
+	select {
+	case <-r.started:
+	default:
+		close(r.started)
+		<-r.release
+	}
+	return r.ResponseRecorder.Write(p)
+}
+
+func TestRateLimits(t *testing.T) {
+	Convey("testing rate limits", t, func() {
+		_, db := prepDB(t)
+		So(db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucket([]byte("bucket1"))
+			return err
+		}), ShouldBeNil)
+
+		// from sends request as coming from the client at remoteAddr
+		from := func(remoteAddr string, request *http.Request) *http.Request {
+			request.RemoteAddr = remoteAddr
+			return request
+		}
+
+		Convey("should limit the writes of every client", func() {
+			restapi, err := boltapi.NewRestApi(db, boltapi.RateLimit(boltapi.RateLimits{
+				Writes: boltapi.Rate{PerSecond: 0.5, Burst: 2},
+			}))
+			So(err, ShouldBeNil)
+			handler := restapi.GetHandler()
+
+			for i := 0; i < 2; i++ {
+				So(serveRequest(handler, from("10.0.0.1:1234", newRequest("PUT", "/v1/buckets/bucket1/item1", strings.NewReader(`{"name": "apple"}`), nil))).Code, ShouldEqual, http.StatusOK)
+			}
+			response := serveRequest(handler, from("10.0.0.1:1234", newRequest("PUT", "/v1/buckets/bucket1/item1", strings.NewReader(`{"name": "apple"}`), nil)))
+			So(response.Code, ShouldEqual, http.StatusTooManyRequests)
+			So(response.Header().Get("Retry-After"), ShouldEqual, "2")
+			So(response.Body.String(), ShouldContainSubstring, boltapi.ErrRateLimited.Error())
+
+			// reads and other clients aren't affected
+			So(serveRequest(handler, from("10.0.0.1:1234", newRequest("GET", "/v1/buckets/bucket1/item1", nil, nil))).Code, ShouldEqual, http.StatusOK)
+			So(serveRequest(handler, from("10.0.0.2:1234", newRequest("PUT", "/v1/buckets/bucket1/item1", strings.NewReader(`{"name": "apple"}`), nil))).Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("should tell clients apart by identity", func() {
+			restapi, err := boltapi.NewRestApi(db, boltapi.RateLimit(boltapi.RateLimits{
+				Reads:          boltapi.Rate{PerSecond: 0.1, Burst: 1},
+				IdentityHeader: "X-Api-Key",
+			}))
+			So(err, ShouldBeNil)
+			restapi.Use(boltapi.BasicAuth("boltapi", map[string]string{"alice": "secret", "bob": "secret"}))
+			handler := restapi.GetHandler()
+
+			alice := map[string]string{"Authorization": "Basic YWxpY2U6c2VjcmV0"}
+			bob := map[string]string{"Authorization": "Basic Ym9iOnNlY3JldA=="}
+			So(serveRequest(handler, from("10.0.0.1:1234", newRequest("GET", "/v1/buckets", nil, alice))).Code, ShouldEqual, http.StatusOK)
+			So(serveRequest(handler, from("10.0.0.1:1234", newRequest("GET", "/v1/buckets", nil, alice))).Code, ShouldEqual, http.StatusTooManyRequests)
+			So(serveRequest(handler, from("10.0.0.1:1234", newRequest("GET", "/v1/buckets", nil, bob))).Code, ShouldEqual, http.StatusOK)
+
+			// users don't get around their limit with the header
+			alice["X-Api-Key"] = "key1"
+			So(serveRequest(handler, from("10.0.0.1:1234", newRequest("GET", "/v1/buckets", nil, alice))).Code, ShouldEqual, http.StatusTooManyRequests)
+		})
+
+		Convey("should only read the identity header from trusted proxies", func() {
+			restapi, err := boltapi.NewRestApi(db, boltapi.RateLimit(boltapi.RateLimits{
+				Reads:          boltapi.Rate{PerSecond: 0.1, Burst: 1},
+				IdentityHeader: "X-Api-Key",
+				TrustedProxies: []string{"10.0.0.0/24", "::1"},
+			}))
+			So(err, ShouldBeNil)
+			handler := restapi.GetHandler()
+
+			key := func(key string) map[string]string {
+				return map[string]string{"X-Api-Key": key}
+			}
+			So(serveRequest(handler, from("10.0.0.1:1234", newRequest("GET", "/v1/buckets", nil, key("key1")))).Code, ShouldEqual, http.StatusOK)
+			So(serveRequest(handler, from("10.0.0.1:1234", newRequest("GET", "/v1/buckets", nil, key("key1")))).Code, ShouldEqual, http.StatusTooManyRequests)
+			So(serveRequest(handler, from("10.0.0.1:1234", newRequest("GET", "/v1/buckets", nil, key("key2")))).Code, ShouldEqual, http.StatusOK)
+			So(serveRequest(handler, from("[::1]:1234", newRequest("GET", "/v1/buckets", nil, key("key3")))).Code, ShouldEqual, http.StatusOK)
+
+			So(serveRequest(handler, from("192.168.0.1:1234", newRequest("GET", "/v1/buckets", nil, key("key4")))).Code, ShouldEqual, http.StatusOK)
+			So(serveRequest(handler, from("192.168.0.1:1234", newRequest("GET", "/v1/buckets", nil, key("key5")))).Code, ShouldEqual, http.StatusTooManyRequests)
+
+			_, err = boltapi.NewRestApi(db, boltapi.RateLimit(boltapi.RateLimits{TrustedProxies: []string{"proxy"}}))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should cap concurrent scans", func() {
+			restapi, err := boltapi.NewRestApi(db, boltapi.ScanConcurrency(1))
+			So(err, ShouldBeNil)
+			handler := restapi.GetHandler()
+
+			blocked := &blockingRecorder{
+				ResponseRecorder: httptest.NewRecorder(),
+				started:          make(chan struct{}),
+				release:          make(chan struct{}),
+			}
+			done := make(chan struct{})
+			go func() {
+				handler.ServeHTTP(blocked, httptest.NewRequest("GET", "/v1/buckets/bucket1", nil))
+				close(done)
+			}()
+			<-blocked.started
+
+			response := serveRequest(handler, from("10.0.0.1:1234", newRequest("GET", "/v1/buckets/bucket1", nil, nil)))
+			So(response.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(response.Header().Get("Retry-After"), ShouldEqual, "1")
+			response = serveRequest(handler, from("10.0.0.1:1234", newRequest("GET", "/v1/buckets?full=true", nil, nil)))
+			So(response.Code, ShouldEqual, http.StatusServiceUnavailable)
+
+			// requests not scanning buckets go through
+			So(serveRequest(handler, from("10.0.0.1:1234", newRequest("GET", "/v1/buckets", nil, nil))).Code, ShouldEqual, http.StatusOK)
+
+			close(blocked.release)
+			<-done
+			So(blocked.Code, ShouldEqual, http.StatusOK)
+			So(serveRequest(handler, from("10.0.0.1:1234", newRequest("GET", "/v1/buckets/bucket1", nil, nil))).Code, ShouldEqual, http.StatusOK)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
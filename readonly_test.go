@@ -0,0 +1,53 @@
+package boltapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReadOnlyMode(t *testing.T) {
+	Convey("testing read-only mode", t, func() {
+		restapi, db := prepDB(t)
+
+		request := createRequest("POST", "/api/v1/buckets", map[string]string{"name": "bucket1"}, nil)
+		response := NewRecorder()
+		restapi.AddBucket(response, request)
+		So(response.Code, ShouldEqual, http.StatusOK)
+		db.Close()
+
+		db, err := bolt.Open("./test.db", 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+		So(err, ShouldBeNil)
+		restapi, err = boltapi.NewRestApi(db)
+		So(err, ShouldBeNil)
+		handler := restapi.GetHandler()
+
+		Convey("should serve reads and reject writes", func() {
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, httptest.NewRequest("GET", "/v1/buckets", nil))
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldContainSubstring, "bucket1")
+
+			request := httptest.NewRequest("POST", "/v1/buckets", strings.NewReader(`{"name": "bucket2"}`))
+			request.Header.Set("Content-Type", "application/json")
+			response = httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+			So(response.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			So(response.Body.String(), ShouldContainSubstring, "database is in read-only mode")
+
+			response = httptest.NewRecorder()
+			handler.ServeHTTP(response, httptest.NewRequest("DELETE", "/v1/buckets/bucket1", nil))
+			So(response.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
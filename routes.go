@@ -0,0 +1,430 @@
+package boltapi
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/boltdb/bolt"
+)
+
+// anyValue documents bodies that can hold any JSON value.
+var anyValue = new(interface{})
+
+// endpoint describes a single route of the API. Both the router and the
+// OpenAPI document are built from the same list so they can't drift apart.
+type endpoint struct {
+	Method  string
+	PathExp string
+	Func    rest.HandlerFunc
+	Summary string
+	Query   []queryParam
+
+	// Write marks routes modifying the database, which are rejected when
+	// it's opened read-only.
+	Write bool
+
+	// Scan tells whether a request goes through whole buckets, those are
+	// capped by ScanConcurrency. Nil for routes that never do.
+	Scan func(r *rest.Request) bool
+
+	// Body and Response are sample values used to describe the payloads,
+	// nil when there isn't any.
+	Body     interface{}
+	Response interface{}
+
+	// BodyTypes and ResponseTypes are the content types of the payloads,
+	// JSON when empty. Payloads of other types are described as binary.
+	BodyTypes     []string
+	ResponseTypes []string
+
+	// Status lists the status codes of successful responses, 200 when
+	// empty.
+	Status []int
+
+	// OperationId names the operation in the OpenAPI document, the name of
+	// Func when empty.
+	OperationId string
+}
+
+type queryParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// valueTypes are the content types item values are exchanged in, see
+// negotiate.
+var valueTypes = []string{
+	JsonCodec.ContentType(),
+	MsgpackCodec.ContentType(),
+	CborCodec.ContentType(),
+	GobCodec.ContentType(),
+	"application/x-protobuf",
+}
+
+// auditQuery are the filters of the audit log listings.
+var auditQuery = []queryParam{
+	{"bucket", "string", "Only list mutations of this bucket"},
+	{"key", "string", "Only list mutations of this key"},
+	{"principal", "string", "Only list mutations of this user"},
+	{"op", "string", "Only list this operation"},
+	{"since", "string", "Only list mutations from this RFC 3339 time"},
+	{"until", "string", "Only list mutations before this RFC 3339 time"},
+	{"start", "integer", "Id of the first entry listed"},
+	{"limit", "integer", "Maximum number of entries, the " + NextKeyHeader + " header holds the id of the next page"},
+}
+
+// endpoints lists every route of the API. When routes overlap the router
+// picks the first one defined, so fixed paths like /mget must be listed
+// before the /#key ones. Bucket operations share their path with items, so
+// their names are reserved, see reservedKeys.
+func (restapi *RestApi) endpoints() []*endpoint {
+	endpoints := []*endpoint{
+		{
+			Method:   "GET",
+			PathExp:  "/v1/openapi.json",
+			Func:     restapi.GetOpenApi,
+			Summary:  "OpenAPI description of the API",
+			Response: anyValue,
+		},
+		{
+			Method:        "GET",
+			PathExp:       "/v1/explorer",
+			Func:          restapi.GetExplorer,
+			Summary:       "API explorer page",
+			Response:      anyValue,
+			ResponseTypes: []string{"text/html"},
+		},
+		{
+			Method:   "GET",
+			PathExp:  "/v1/stats",
+			Func:     restapi.GetStats,
+			Scan:     scanAlways,
+			Summary:  "Database and bucket stats",
+			Response: Stats{},
+		},
+		{
+			Method:   "GET",
+			PathExp:  "/v1/admin/audit",
+			Func:     restapi.GetAudit,
+			Scan:     scanAlways,
+			Summary:  "List the mutations recorded in the audit bucket",
+			Query:    auditQuery,
+			Response: []AuditEntry{},
+		},
+		{
+			Method:  "GET",
+			PathExp: "/v1/buckets",
+			Func:    restapi.ListBuckets,
+			Summary: "List buckets",
+			Scan: func(r *rest.Request) bool {
+				return queryBool(r, "full", false)
+			},
+			Query: []queryParam{
+				{"full", "boolean", "Include the items of every bucket"},
+			},
+			Response: []string{},
+		},
+		{
+			Method:   "POST",
+			PathExp:  "/v1/mget",
+			Func:     restapi.MultiGetItems,
+			Summary:  "Retrieve items across buckets",
+			Body:     struct{ Items []ItemRef }{},
+			Response: []MultiGetItem{},
+		},
+		{
+			Method:   "POST",
+			PathExp:  "/v1/batch",
+			Func:     restapi.ApplyBatch,
+			Write:    true,
+			Summary:  "Apply writes atomically in a single transaction",
+			Body:     struct{ Ops []BatchOp }{},
+			Response: BatchResult{},
+		},
+		{
+			Method:  "POST",
+			PathExp: "/v1/buckets",
+			Func:    restapi.AddBucket,
+			Write:   true,
+			Summary: "Add bucket",
+			Body:    struct{ Name string }{},
+		},
+		{
+			Method:  "GET",
+			PathExp: "/v1/buckets/#name",
+			Func:    restapi.GetBucket,
+			Summary: "List bucket items",
+			Scan:    scanAlways,
+			Query: []queryParam{
+				{"prefix", "string", "Only list keys starting with prefix"},
+				{"start", "string", "First key listed"},
+				{"end", "string", "List keys before this one"},
+				{"limit", "integer", "Maximum number of items, the " + NextKeyHeader + " header holds the key of the next page"},
+				{"raw", "boolean", "Return values as stored, base64 encoded"},
+			},
+			Response: []BucketItem{},
+		},
+		{
+			Method:  "HEAD",
+			PathExp: "/v1/buckets/#name",
+			Func:    restapi.HeadBucket,
+			Summary: "Check if bucket exists",
+		},
+		{
+			Method:  "DELETE",
+			PathExp: "/v1/buckets/#name",
+			Func:    restapi.DeleteBucket,
+			Write:   true,
+			Summary: "Delete bucket",
+		},
+		{
+			Method:  "POST",
+			PathExp: "/v1/buckets/#name",
+			Func:    restapi.AddBucketItem,
+			Write:   true,
+			Summary: "Add item on the bucket, generating its key when missing",
+			Query: []queryParam{
+				{"upsert", "boolean", "Overwrite the item if it already exists"},
+				{"keyformat", "string", "Format of generated keys, decimal or binary"},
+				{"key", "string", "Key of items sent in another format than JSON"},
+			},
+			Body:          BucketItem{},
+			BodyTypes:     valueTypes,
+			Response:      anyValue,
+			ResponseTypes: valueTypes,
+			Status:        []int{http.StatusOK, http.StatusCreated},
+		},
+		{
+			Method:   "POST",
+			PathExp:  "/v1/buckets/#name/mget",
+			Func:     restapi.MultiGetBucketItems,
+			Summary:  "Retrieve several bucket items",
+			Body:     struct{ Keys []string }{},
+			Response: []MultiGetItem{},
+		},
+		{
+			Method:  "POST",
+			PathExp: "/v1/buckets/#name/rename",
+			Func:    restapi.RenameBucket,
+			Write:   true,
+			Summary: "Rename bucket",
+			Body:    BucketDestination{},
+		},
+		{
+			Method:   "POST",
+			PathExp:  "/v1/buckets/#name/copy",
+			Func:     restapi.CopyBucket,
+			Write:    true,
+			Summary:  "Copy bucket",
+			Body:     BucketDestination{},
+			Response: TransferResult{},
+		},
+		{
+			Method:   "POST",
+			PathExp:  "/v1/buckets/#name/move",
+			Func:     restapi.MoveBucketItems,
+			Write:    true,
+			Summary:  "Move items to another bucket",
+			Body:     BucketMove{},
+			Response: TransferResult{},
+		},
+		{
+			Method:   "POST",
+			PathExp:  "/v1/buckets/#name/truncate",
+			Func:     restapi.TruncateBucket,
+			Write:    true,
+			Summary:  "Delete bucket items",
+			Body:     BucketTruncate{},
+			Response: TruncateResult{},
+		},
+		{
+			Method:   "GET",
+			PathExp:  "/v1/buckets/#name/truncate",
+			Func:     restapi.GetTruncation,
+			Summary:  "Progress of the last bucket truncation",
+			Response: Truncation{},
+		},
+		{
+			Method:   "GET",
+			PathExp:  "/v1/buckets/#name/sequence",
+			Func:     restapi.GetBucketSequence,
+			Summary:  "Retrieve bucket sequence",
+			Response: BucketSequence{},
+		},
+		{
+			Method:   "PUT",
+			PathExp:  "/v1/buckets/#name/sequence",
+			Func:     restapi.UpdateBucketSequence,
+			Write:    true,
+			Summary:  "Set bucket sequence",
+			Body:     BucketSequence{},
+			Response: BucketSequence{},
+		},
+		{
+			Method:   "POST",
+			PathExp:  "/v1/buckets/#name/reencrypt",
+			Func:     restapi.ReencryptBucket,
+			Write:    true,
+			Summary:  "Start encrypting bucket items with the primary key in the background",
+			Response: Reencryption{},
+			Status:   []int{http.StatusAccepted},
+		},
+		{
+			Method:   "GET",
+			PathExp:  "/v1/buckets/#name/reencrypt",
+			Func:     restapi.GetReencryption,
+			Summary:  "Progress of the last bucket re-encryption",
+			Response: Reencryption{},
+		},
+		{
+			Method:  "GET",
+			PathExp: "/v1/buckets/#name/#key",
+			Func:    restapi.GetBucketItem,
+			Summary: "Retrieve item",
+			Query: []queryParam{
+				{"raw", "boolean", "Return the value as stored, as application/octet-stream"},
+			},
+			Response:      anyValue,
+			ResponseTypes: append(valueTypes[:len(valueTypes):len(valueTypes)], "application/octet-stream"),
+		},
+		{
+			Method:  "HEAD",
+			PathExp: "/v1/buckets/#name/#key",
+			Func:    restapi.HeadBucketItem,
+			Summary: "Check if item exists",
+		},
+		{
+			Method:  "PUT",
+			PathExp: "/v1/buckets/#name/#key",
+			Func:    restapi.UpdateBucketItem,
+			Write:   true,
+			Summary: "Update item",
+			Query: []queryParam{
+				{"create", "boolean", "Create the item if it doesn't exist, defaults to true"},
+			},
+			Body:          anyValue,
+			BodyTypes:     valueTypes,
+			Response:      anyValue,
+			ResponseTypes: valueTypes,
+		},
+		{
+			Method:  "DELETE",
+			PathExp: "/v1/buckets/#name/#key",
+			Func:    restapi.DeleteBucketItem,
+			Write:   true,
+			Summary: "Delete item",
+		},
+		{
+			Method:    "PUT",
+			PathExp:   "/v1/buckets/#name/#key/blob",
+			Func:      restapi.PutBlob,
+			Write:     true,
+			Summary:   "Upload blob, resumed with a Content-Range starting where the stored blob ends",
+			Body:      anyValue,
+			BodyTypes: []string{"application/octet-stream"},
+			Response:  BlobManifest{},
+		},
+		{
+			Method:        "GET",
+			PathExp:       "/v1/buckets/#name/#key/blob",
+			Func:          restapi.GetBlob,
+			Summary:       "Retrieve blob, or the byte ranges of the Range header",
+			Response:      anyValue,
+			ResponseTypes: []string{"application/octet-stream"},
+			Status:        []int{http.StatusOK, http.StatusPartialContent},
+		},
+		{
+			Method:  "HEAD",
+			PathExp: "/v1/buckets/#name/#key/blob",
+			Func:    restapi.GetBlob,
+			Summary: "Check if blob exists",
+		},
+		{
+			Method:  "DELETE",
+			PathExp: "/v1/buckets/#name/#key/blob",
+			Func:    restapi.DeleteBlob,
+			Write:   true,
+			Summary: "Delete blob",
+		},
+	}
+
+	// the databases of a MultiApi have their audit log served by its admin
+	// handler, to AdminUsers rather than to their own users
+	if restapi.options.database != "" {
+		for i, e := range endpoints {
+			if e.PathExp == "/v1/admin/audit" {
+				endpoints = append(endpoints[:i], endpoints[i+1:]...)
+				break
+			}
+		}
+	}
+	return endpoints
+}
+
+// bucketOpPrefix starts the routes of bucket operations, which share their
+// path with items.
+const bucketOpPrefix = "/v1/buckets/#name/"
+
+// reservedKeys are the keys the bucket operation routes shadow, like
+// sequence, which items can't be written to.
+func reservedKeys(endpoints []*endpoint) map[string]bool {
+	reserved := map[string]bool{}
+	for _, e := range endpoints {
+		op := strings.TrimPrefix(e.PathExp, bucketOpPrefix)
+		if op != e.PathExp && !strings.ContainsAny(op, "/#:*") {
+			reserved[op] = true
+		}
+	}
+	return reserved
+}
+
+func (restapi *RestApi) routes() []*rest.Route {
+	routes := []*rest.Route{}
+	for _, e := range restapi.endpoints() {
+		handler := e.Func
+		if e.Write && restapi.db.IsReadOnly() {
+			handler = rejectWrite
+		}
+		if restapi.options.audit != nil && restapi.options.audit.bucket != "" {
+			handler = restapi.protectAudit(handler)
+		}
+		if strings.Contains(e.PathExp, "#name") {
+			handler = protectBlobs(handler)
+		}
+		if e.Scan != nil && restapi.scans != nil {
+			handler = capScans(restapi.scans, e.Scan, handler)
+		}
+		// checked after the middlewares, once users are authenticated
+		if restapi.limiter != nil {
+			handler = restapi.limiter.limitRate(e.Write, handler)
+		}
+		routes = append(routes, &rest.Route{
+			HttpMethod: e.Method,
+			PathExp:    e.PathExp,
+			Func:       unescapePathParams(handler),
+		})
+	}
+	return routes
+}
+
+// rejectWrite answers the routes modifying the database when it's opened
+// read-only.
+func rejectWrite(w rest.ResponseWriter, r *rest.Request) {
+	rest.Error(w, bolt.ErrDatabaseReadOnly.Error(), http.StatusMethodNotAllowed)
+}
+
+// unescapePathParams decodes the path params before calling the handler,
+// the router matches them while they're still url-encoded.
+func unescapePathParams(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		for name, value := range r.PathParams {
+			if unescaped, err := url.PathUnescape(value); err == nil {
+				r.PathParams[name] = unescaped
+			}
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,104 @@
+package boltapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// KeyFormatDecimal formats generated keys as zero-padded decimals,
+	// so they sort in the same order they were generated.
+	KeyFormatDecimal = "decimal"
+
+	// KeyFormatBinary formats generated keys as 8-byte big-endian integers.
+	KeyFormatBinary = "binary"
+)
+
+type BucketSequence struct {
+	Sequence uint64
+}
+
+func (restapi *RestApi) GetBucketSequence(w rest.ResponseWriter, r *rest.Request) {
+	bucketName := r.PathParam("name")
+	sequence := new(BucketSequence)
+	if err := restapi.view(r, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(strings.TrimSpace(bucketName)))
+		if bucket == nil {
+			return ErrBucketMissing
+		}
+		sequence.Sequence = bucket.Sequence()
+		return nil
+	}); err != nil {
+		logError(r, err, nil)
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteJson(sequence)
+}
+
+func (restapi *RestApi) UpdateBucketSequence(w rest.ResponseWriter, r *rest.Request) {
+	fail := func(cusromErr, origErr error) {
+		logError(r, cusromErr, origErr)
+		rest.Error(w, cusromErr.Error(), http.StatusInternalServerError)
+	}
+
+	bucketName := r.PathParam("name")
+	sequence := new(BucketSequence)
+	if err := r.DecodeJsonPayload(sequence); err != nil {
+		fail(ErrBucketSequenceDecode, err)
+		return
+	}
+
+	if err := restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
+		bucket := tx.Bucket([]byte(strings.TrimSpace(bucketName)))
+		if bucket == nil {
+			return ErrBucketMissing
+		}
+		trail.bucket(AuditSetSequence, strings.TrimSpace(bucketName), "")
+		return bucket.SetSequence(sequence.Sequence)
+	}); err != nil {
+		fail(ErrBucketSequenceUpdate, err)
+		return
+	}
+	w.WriteJson(sequence)
+}
+
+func validKeyFormat(format string) bool {
+	switch format {
+	case "", KeyFormatDecimal, KeyFormatBinary:
+		return true
+	}
+	return false
+}
+
+// nextSequenceKey increments the bucket's sequence and returns it as a key
+// in the given format. It must be called within a writable transaction.
+func nextSequenceKey(bucket *bolt.Bucket, format string) ([]byte, error) {
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+
+	if format == KeyFormatBinary {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return key, nil
+	}
+	return []byte(fmt.Sprintf("%020d", seq)), nil
+}
+
+// itemLocation returns the URL of the item with the given key, relative to
+// the bucket the request was sent to.
+func itemLocation(r *rest.Request, key []byte) string {
+	base := strings.SplitN(r.RequestURI, "?", 2)[0]
+	if base == "" {
+		base = r.URL.Path
+	}
+	return strings.TrimSuffix(base, "/") + "/" + url.PathEscape(string(key))
+}
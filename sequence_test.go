@@ -0,0 +1,87 @@
+package boltapi_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBucketSequenceEndpoint(t *testing.T) {
+	Convey("testing bucket sequence endpoint", t, func() {
+		restapi, db := prepDB(t)
+
+		request := createRequest("POST", "/api/v1/buckets", map[string]string{"name": "bucket1"}, nil)
+		response := NewRecorder()
+		restapi.AddBucket(response, request)
+		So(response.Code, ShouldEqual, http.StatusOK)
+
+		Convey("should generate keys for items without one", func() {
+			payload := map[string]interface{}{"value": "apple"}
+			request := createRequest("POST", "/api/v1/buckets/bucket1", payload, map[string]string{"name": "bucket1"})
+			response := NewRecorder()
+			restapi.AddBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusCreated)
+			So(response.Header().Get("Location"), ShouldEqual, "/api/v1/buckets/bucket1/00000000000000000001")
+			So(response.Body.String(), ShouldEqual, `"apple"`)
+
+			request = createRequest("GET", "/api/v1/buckets/bucket1/00000000000000000001", nil, map[string]string{"name": "bucket1", "key": "00000000000000000001"})
+			response = NewRecorder()
+			restapi.GetBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `"apple"`)
+
+			// invalid key format returns error
+			request = createRequest("POST", "/api/v1/buckets/bucket1?keyformat=hex", payload, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.AddBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusInternalServerError)
+			So(response.Body.String(), ShouldEqual, `{"Error":"invalid key format"}`)
+		})
+
+		Convey("should be able to retrieve and update bucket sequence", func() {
+			request := createRequest("GET", "/api/v1/buckets/bucket1/sequence", nil, map[string]string{"name": "bucket1"})
+			response := NewRecorder()
+			restapi.GetBucketSequence(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `{"Sequence":0}`)
+
+			request = createRequest("PUT", "/api/v1/buckets/bucket1/sequence", map[string]uint64{"sequence": 41}, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.UpdateBucketSequence(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `{"Sequence":41}`)
+
+			payload := map[string]interface{}{"value": "apple"}
+			request = createRequest("POST", "/api/v1/buckets/bucket1", payload, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.AddBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusCreated)
+			So(response.Header().Get("Location"), ShouldEqual, "/api/v1/buckets/bucket1/00000000000000000042")
+		})
+
+		Convey("should keep items apart from bucket operations", func() {
+			handler := restapi.GetHandler()
+
+			So(serve(handler, "PUT", "/v1/buckets/bucket1/sequence", strings.NewReader(`{"sequence": 7}`), nil).Code, ShouldEqual, http.StatusOK)
+			response := serve(handler, "GET", "/v1/buckets/bucket1/sequence", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldContainSubstring, `"Sequence": 7`)
+
+			// the names of operations can't be used as keys
+			for _, key := range []string{"sequence", "rename", "mget"} {
+				response = serve(handler, "POST", "/v1/buckets/bucket1", strings.NewReader(`{"key": "`+key+`", "value": "apple"}`), nil)
+				So(response.Code, ShouldEqual, http.StatusBadRequest)
+				So(response.Body.String(), ShouldContainSubstring, boltapi.ErrBucketKeyReserved.Error())
+			}
+			So(serve(handler, "PUT", "/v1/buckets/bucket1/copy", strings.NewReader(`"apple"`), nil).Code, ShouldEqual, http.StatusBadRequest)
+			So(serve(handler, "POST", "/v1/batch", strings.NewReader(`{"ops": [{"op": "put", "bucket": "bucket1", "key": "move", "value": "MQ=="}]}`), nil).Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
@@ -0,0 +1,63 @@
+package boltapi
+
+import (
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/boltdb/bolt"
+)
+
+type BucketStats struct {
+	Name string
+	bolt.BucketStats
+}
+
+type Stats struct {
+	Size          int64
+	FreePageN     int
+	PendingPageN  int
+	FreeAlloc     int
+	FreelistInuse int
+	TxN           int
+	OpenTxN       int
+	Buckets       []*BucketStats
+}
+
+// GetStats collects the database stats along with the stats of every
+// top-level bucket.
+func GetStats(db *bolt.DB) (*Stats, error) {
+	dbStats := db.Stats()
+	stats := &Stats{
+		FreePageN:     dbStats.FreePageN,
+		PendingPageN:  dbStats.PendingPageN,
+		FreeAlloc:     dbStats.FreeAlloc,
+		FreelistInuse: dbStats.FreelistInuse,
+		TxN:           dbStats.TxN,
+		OpenTxN:       dbStats.OpenTxN,
+		Buckets:       []*BucketStats{},
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		stats.Size = tx.Size()
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			stats.Buckets = append(stats.Buckets, &BucketStats{
+				Name:        string(name),
+				BucketStats: bucket.Stats(),
+			})
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (restapi *RestApi) GetStats(w rest.ResponseWriter, r *rest.Request) {
+	stats, err := GetStats(restapi.db)
+	if err != nil {
+		logError(r, ErrStats, err)
+		rest.Error(w, ErrStats.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteJson(stats)
+}
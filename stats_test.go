@@ -0,0 +1,52 @@
+package boltapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStatsEndpoint(t *testing.T) {
+	Convey("testing stats endpoint", t, func() {
+		restapi, db := prepDB(t)
+
+		Convey("should report the database and its buckets", func() {
+			So(db.Update(func(tx *bolt.Tx) error {
+				bucket, err := tx.CreateBucket([]byte("bucket1"))
+				if err != nil {
+					return err
+				}
+				for _, key := range []string{"item1", "item2", "item3"} {
+					if err := bucket.Put([]byte(key), []byte(`"apple"`)); err != nil {
+						return err
+					}
+				}
+				_, err = tx.CreateBucket([]byte("bucket2"))
+				return err
+			}), ShouldBeNil)
+
+			request := createRequest("GET", "/api/v1/stats", nil, nil)
+			response := NewRecorder()
+			restapi.GetStats(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			stats := &boltapi.Stats{}
+			So(json.Unmarshal(response.Body.Bytes(), stats), ShouldBeNil)
+			So(stats.Size, ShouldBeGreaterThan, 0)
+			So(len(stats.Buckets), ShouldEqual, 2)
+			So(stats.Buckets[0].Name, ShouldEqual, "bucket1")
+			So(stats.Buckets[0].KeyN, ShouldEqual, 3)
+			So(stats.Buckets[0].Depth, ShouldEqual, 1)
+			So(stats.Buckets[1].Name, ShouldEqual, "bucket2")
+			So(stats.Buckets[1].KeyN, ShouldEqual, 0)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
@@ -0,0 +1,153 @@
+package boltapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// DefaultMaxBodySize is the largest request body accepted unless
+// MaxBodySize says otherwise.
+const DefaultMaxBodySize = 32 << 20
+
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// jsonStream writes a JSON array element by element, as they're read from a
+// cursor, the same way WriteJson would write the whole array.
+type jsonStream struct {
+	w       io.Writer
+	indent  bool
+	prefix  string
+	count   int
+	written bool
+}
+
+func newJsonStream(w rest.ResponseWriter) *jsonStream {
+	// follows the indentation of the writer, set by JsonIndentMiddleware
+	probe, _ := w.EncodeJson([]int{0})
+	return &jsonStream{
+		w:      w.(http.ResponseWriter),
+		indent: bytes.Contains(probe, []byte("\n")),
+	}
+}
+
+// nested returns a stream writing an array depth levels within this one.
+func (s *jsonStream) nested(depth int) *jsonStream {
+	return &jsonStream{w: s, indent: s.indent, prefix: s.prefix + strings.Repeat("  ", depth)}
+}
+
+func (s *jsonStream) Write(p []byte) (int, error) {
+	s.written = true
+	return s.w.Write(p)
+}
+
+func (s *jsonStream) write(content string) error {
+	_, err := s.Write([]byte(content))
+	return err
+}
+
+// newline starts a line depth levels within the array when indenting.
+func (s *jsonStream) newline(depth int) string {
+	if !s.indent {
+		return ""
+	}
+	return "\n" + s.prefix + strings.Repeat("  ", depth)
+}
+
+// colon separates object keys from their values.
+func (s *jsonStream) colon() string {
+	if !s.indent {
+		return ":"
+	}
+	return ": "
+}
+
+// begin writes what comes before the next element.
+func (s *jsonStream) begin() error {
+	separator := ","
+	if s.count == 0 {
+		separator = "["
+	}
+	s.count++
+	return s.write(separator + s.newline(1))
+}
+
+// Encode writes v as the next element.
+func (s *jsonStream) Encode(v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if s.indent {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, content, s.prefix+"  ", "  "); err != nil {
+			return err
+		}
+		content = buf.Bytes()
+	}
+	if err := s.begin(); err != nil {
+		return err
+	}
+	_, err = s.Write(content)
+	return err
+}
+
+// Close ends the array.
+func (s *jsonStream) Close() error {
+	if s.count == 0 {
+		return s.write("[]")
+	}
+	return s.write(s.newline(0) + "]")
+}
+
+// bodyLimiter answers 413 Request Entity Too Large to requests whose body
+// is larger than limit, except blob uploads. Bodies of unknown length are
+// read up to the limit before going further.
+type bodyLimiter struct {
+	limit int64
+}
+
+func (mw *bodyLimiter) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		tooLarge := func() {
+			rest.Error(w, ErrBodyTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		}
+
+		switch {
+		case isBlobUpload(r):
+		case r.ContentLength > mw.limit:
+			tooLarge()
+			return
+		case r.ContentLength < 0 && r.Body != nil:
+			content, err := ioutil.ReadAll(io.LimitReader(r.Body, mw.limit+1))
+			if err != nil {
+				rest.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if int64(len(content)) > mw.limit {
+				tooLarge()
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(content))
+			r.ContentLength = int64(len(content))
+		}
+		handler(w, r)
+	}
+}
+
+// writeRawValue writes a value as stored, straight from the memory map.
+// It has to be called within the transaction the value was read in, which
+// stays open until the client received it.
+func writeRawValue(w rest.ResponseWriter, value []byte) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	w.WriteHeader(http.StatusOK)
+	w.(http.ResponseWriter).Write(value)
+}
@@ -0,0 +1,135 @@
+package boltapi_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStreaming(t *testing.T) {
+	Convey("testing streamed responses and body limits", t, func() {
+		_, db := prepDB(t)
+
+		So(db.Update(func(tx *bolt.Tx) error {
+			for _, name := range []string{"fruits", "empty"} {
+				if _, err := tx.CreateBucket([]byte(name)); err != nil {
+					return err
+				}
+			}
+			bucket := tx.Bucket([]byte("fruits"))
+			for i := 0; i < 5; i++ {
+				value := fmt.Sprintf(`{"name": "fruit%d", "tags": ["a", "b"]}`, i)
+				if err := bucket.Put([]byte(fmt.Sprintf("item%d", i)), []byte(value)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}), ShouldBeNil)
+
+		// what WriteJson writes for the same listing
+		expected := func(compact bool, v interface{}) string {
+			content, _ := json.MarshalIndent(v, "", "  ")
+			if compact {
+				content, _ = json.Marshal(v)
+			}
+			return string(content)
+		}
+		items := func(from, to int) []map[string]interface{} {
+			items := []map[string]interface{}{}
+			for i := from; i < to; i++ {
+				items = append(items, map[string]interface{}{
+					"Key":   fmt.Sprintf("item%d", i),
+					"Value": map[string]interface{}{"name": fmt.Sprintf("fruit%d", i), "tags": []string{"a", "b"}},
+				})
+			}
+			return items
+		}
+
+		Convey("should stream listings the way they were written whole", func() {
+			for _, compact := range []bool{false, true} {
+				opts := []boltapi.Option{}
+				if compact {
+					opts = append(opts, boltapi.CompactJson())
+				}
+				restapi, err := boltapi.NewRestApi(db, opts...)
+				So(err, ShouldBeNil)
+				handler := restapi.GetHandler()
+
+				response := serve(handler, "GET", "/v1/buckets", nil, nil)
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(response.Body.String(), ShouldEqual, expected(compact, []string{"empty", "fruits"}))
+
+				response = serve(handler, "GET", "/v1/buckets?full=true", nil, nil)
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(response.Body.String(), ShouldEqual, expected(compact, []map[string]interface{}{
+					{"name": "empty", "items": []interface{}{}},
+					{"name": "fruits", "items": items(0, 5)},
+				}))
+
+				response = serve(handler, "GET", "/v1/buckets/fruits?limit=2&start=item1", nil, nil)
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(response.Header().Get(boltapi.NextKeyHeader), ShouldEqual, "item3")
+				So(response.Body.String(), ShouldEqual, expected(compact, items(1, 3)))
+
+				response = serve(handler, "GET", "/v1/buckets/fruits?limit=2&start=item3", nil, nil)
+				So(response.Header().Get(boltapi.NextKeyHeader), ShouldEqual, "")
+				So(response.Body.String(), ShouldEqual, expected(compact, items(3, 5)))
+
+				response = serve(handler, "GET", "/v1/buckets/empty", nil, nil)
+				So(response.Body.String(), ShouldEqual, "[]")
+			}
+		})
+
+		Convey("should return values as stored", func() {
+			restapi, err := boltapi.NewRestApi(db)
+			So(err, ShouldBeNil)
+			handler := restapi.GetHandler()
+
+			response := serve(handler, "GET", "/v1/buckets/fruits/item2?raw=true", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Header().Get("Content-Type"), ShouldEqual, "application/octet-stream")
+			So(response.Body.String(), ShouldEqual, `{"name": "fruit2", "tags": ["a", "b"]}`)
+
+			response = serve(handler, "GET", "/v1/buckets/fruits/missing?raw=true", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+			response = serve(handler, "GET", "/v1/buckets/missing/item2?raw=true", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("should reject bodies over the limit", func() {
+			restapi, err := boltapi.NewRestApi(db, boltapi.MaxBodySize(64))
+			So(err, ShouldBeNil)
+			handler := restapi.GetHandler()
+
+			small := `{"name": "kiwi"}`
+			large := `{"name": "` + strings.Repeat("kiwi", 20) + `"}`
+			So(serve(handler, "PUT", "/v1/buckets/fruits/kiwi", strings.NewReader(small), nil).Code, ShouldEqual, http.StatusOK)
+
+			response := serve(handler, "PUT", "/v1/buckets/fruits/kiwi", strings.NewReader(large), nil)
+			So(response.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(response.Body.String(), ShouldContainSubstring, boltapi.ErrBodyTooLarge.Error())
+
+			// chunked bodies, whose length isn't known up front
+			response = serve(handler, "PUT", "/v1/buckets/fruits/kiwi", io.MultiReader(strings.NewReader(large)), nil)
+			So(response.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			response = serve(handler, "PUT", "/v1/buckets/fruits/kiwi", io.MultiReader(strings.NewReader(small)), nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			restapi, err = boltapi.NewRestApi(db, boltapi.MaxBodySize(0))
+			So(err, ShouldBeNil)
+			response = serve(restapi.GetHandler(), "PUT", "/v1/buckets/fruits/kiwi", strings.NewReader(large), nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
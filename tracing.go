@@ -0,0 +1,91 @@
+package boltapi
+
+import (
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans.
+const tracerName = "github.com/marconi/boltapi"
+
+// Attributes of the transaction spans, besides db.system.name.
+const (
+	traceBucket = attribute.Key("bolt.bucket")
+	traceKeys   = attribute.Key("bolt.keys")
+	traceBytes  = attribute.Key("bolt.bytes")
+)
+
+// requestTracer starts a span per request, child of the one of the W3C
+// traceparent header when the client sent it. The transactions of the
+// request are traced as its children.
+type requestTracer struct {
+	tracer trace.Tracer
+}
+
+func (mw *requestTracer) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	propagator := propagation.TraceContext{}
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := mw.tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", r.RemoteAddr),
+			))
+		defer span.End()
+		r.Request = r.Request.WithContext(ctx)
+
+		handler(w, r)
+
+		// set by the recorder and user middlewares, which run inside this one
+		if status, ok := r.Env["STATUS_CODE"].(int); ok {
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+		}
+		if written, ok := r.Env["BYTES_WRITTEN"].(int64); ok {
+			span.SetAttributes(attribute.Int64("http.response.body.size", written))
+		}
+		if user, ok := r.Env["REMOTE_USER"].(string); ok {
+			span.SetAttributes(attribute.String("user.name", user))
+		}
+	}
+}
+
+// traced runs fn in a span named after the transaction, child of the one
+// of the request. r carries the span until fn returns, for traceTx.
+func traced(r *rest.Request, name string, fn func() error) error {
+	parent := r.Request
+	ctx := parent.Context()
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(attribute.String("db.system.name", "boltdb")))
+	r.Request = parent.WithContext(ctx)
+	defer func() {
+		r.Request = parent
+		span.End()
+	}()
+
+	err := fn()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// traceTx annotates the span of the running transaction with the bucket
+// it went through and the number of keys and bytes it read or wrote.
+func traceTx(r *rest.Request, bucket string, keys, bytes int) {
+	attrs := []attribute.KeyValue{traceKeys.Int(keys), traceBytes.Int(bytes)}
+	if bucket != "" {
+		attrs = append(attrs, traceBucket.String(bucket))
+	}
+	trace.SpanFromContext(r.Context()).SetAttributes(attrs...)
+}
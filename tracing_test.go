@@ -0,0 +1,89 @@
+package boltapi_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	Convey("testing tracing", t, func() {
+		_, db := prepDB(t)
+		So(db.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucket([]byte("bucket1"))
+			if err != nil {
+				return err
+			}
+			bucket.Put([]byte("item1"), []byte(`"apple"`))
+			return bucket.Put([]byte("item2"), []byte(`"banana"`))
+		}), ShouldBeNil)
+
+		recorder := tracetest.NewSpanRecorder()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		restapi, err := boltapi.NewRestApi(db, boltapi.Tracing(provider))
+		So(err, ShouldBeNil)
+		handler := restapi.GetHandler()
+
+		attributes := func(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+			attrs := map[attribute.Key]attribute.Value{}
+			for _, attr := range span.Attributes() {
+				attrs[attr.Key] = attr.Value
+			}
+			return attrs
+		}
+
+		Convey("should trace requests and their transactions", func() {
+			So(serve(handler, "GET", "/v1/buckets/bucket1", nil, nil).Code, ShouldEqual, http.StatusOK)
+
+			spans := recorder.Ended()
+			So(len(spans), ShouldEqual, 2)
+			tx, request := spans[0], spans[1]
+			So(request.Name(), ShouldEqual, "GET")
+			So(request.SpanKind(), ShouldEqual, trace.SpanKindServer)
+			So(attributes(request)["http.response.status_code"].AsInt64(), ShouldEqual, http.StatusOK)
+			So(attributes(request)["url.path"].AsString(), ShouldEqual, "/v1/buckets/bucket1")
+
+			So(tx.Name(), ShouldEqual, "bolt.View")
+			So(tx.Parent().SpanID(), ShouldEqual, request.SpanContext().SpanID())
+			So(attributes(tx)["bolt.bucket"].AsString(), ShouldEqual, "bucket1")
+			So(attributes(tx)["bolt.keys"].AsInt64(), ShouldEqual, 2)
+			So(attributes(tx)["bolt.bytes"].AsInt64(), ShouldEqual, len(`"apple"`)+len(`"banana"`))
+		})
+
+		Convey("should trace updates and their errors", func() {
+			So(serve(handler, "PUT", "/v1/buckets/bucket1/item3", strings.NewReader(`"cherry"`), nil).Code, ShouldEqual, http.StatusOK)
+			So(serve(handler, "PUT", "/v1/buckets/missing/item1", strings.NewReader(`"cherry"`), nil).Code, ShouldEqual, http.StatusInternalServerError)
+
+			spans := recorder.Ended()
+			So(len(spans), ShouldEqual, 4)
+			So(spans[0].Name(), ShouldEqual, "bolt.Update")
+			So(attributes(spans[0])["bolt.keys"].AsInt64(), ShouldEqual, 1)
+			So(spans[2].Status().Description, ShouldEqual, boltapi.ErrBucketMissing.Error())
+			So(spans[3].Status().Description, ShouldEqual, http.StatusText(http.StatusInternalServerError))
+		})
+
+		Convey("should continue the traces of clients", func() {
+			traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+			So(serve(handler, "GET", "/v1/buckets", nil, map[string]string{"traceparent": traceparent}).Code, ShouldEqual, http.StatusOK)
+
+			spans := recorder.Ended()
+			request := spans[len(spans)-1]
+			So(request.SpanContext().TraceID().String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+			So(request.Parent().SpanID().String(), ShouldEqual, "00f067aa0ba902b7")
+			So(request.Parent().IsRemote(), ShouldBeTrue)
+			So(attributes(request)["boltapi.request_id"].AsString(), ShouldNotBeEmpty)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
@@ -0,0 +1,367 @@
+package boltapi
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/boltdb/bolt"
+)
+
+// BucketDestination is the target of a bucket rename, copy or move. Path
+// points to a nested bucket and takes precedence over Name.
+type BucketDestination struct {
+	Name string
+	Path []string
+}
+
+func (dest *BucketDestination) names() [][]byte {
+	path := dest.Path
+	if len(path) == 0 {
+		path = []string{dest.Name}
+	}
+
+	names := make([][]byte, 0, len(path))
+	for _, name := range path {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil
+		}
+		names = append(names, []byte(name))
+	}
+	if isBlobBucket(string(names[0])) {
+		return nil
+	}
+	return names
+}
+
+// KeyRange restricts an operation to part of a bucket. Start is inclusive,
+// End is exclusive and Prefix further limits it to keys starting with it.
+// Empty fields leave the range open.
+type KeyRange struct {
+	Prefix string
+	Start  string
+	End    string
+}
+
+// seek positions the cursor on the first key of the range.
+func (kr *KeyRange) seek(c *bolt.Cursor) ([]byte, []byte) {
+	start := kr.Start
+	if kr.Prefix > start {
+		start = kr.Prefix
+	}
+	if start == "" {
+		return c.First()
+	}
+	return c.Seek([]byte(start))
+}
+
+// done reports whether the cursor went past the end of the range.
+func (kr *KeyRange) done(k []byte) bool {
+	if k == nil {
+		return true
+	}
+	if kr.End != "" && bytes.Compare(k, []byte(kr.End)) >= 0 {
+		return true
+	}
+	return kr.Prefix != "" && !bytes.HasPrefix(k, []byte(kr.Prefix))
+}
+
+// BucketMove selects the items moved to another bucket.
+type BucketMove struct {
+	BucketDestination
+	KeyRange
+}
+
+type TransferResult struct {
+	Keys int
+}
+
+func (restapi *RestApi) RenameBucket(w rest.ResponseWriter, r *rest.Request) {
+	bucketName := r.PathParam("name")
+	dest := new(BucketDestination)
+	if err := r.DecodeJsonPayload(dest); err != nil {
+		transferFail(w, r, ErrBucketDecodeName, err)
+		return
+	}
+
+	names := dest.names()
+	if len(names) != 1 || string(names[0]) == bucketName {
+		transferFail(w, r, ErrBucketDestination, nil)
+		return
+	}
+
+	if err := restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil {
+			return ErrBucketMissing
+		}
+		if !restapi.options.sameStorage(bucketName, string(names[0])) {
+			return ErrBucketStorage
+		}
+		trail.bucket(AuditRenameBucket, bucketName, string(names[0]))
+		newBucket, err := tx.CreateBucket(names[0])
+		if err != nil {
+			return err
+		}
+		if _, err := copyBucket(bucket, newBucket); err != nil {
+			return err
+		}
+		if err := transferBlobs(tx, bucketName, names, nil); err != nil {
+			return err
+		}
+		if err := deleteBlobBuckets(tx, bucketName); err != nil {
+			return err
+		}
+		return tx.DeleteBucket([]byte(bucketName))
+	}); err != nil {
+		transferFail(w, r, ErrBucketRename, err)
+		return
+	}
+}
+
+func (restapi *RestApi) CopyBucket(w rest.ResponseWriter, r *rest.Request) {
+	bucketName := r.PathParam("name")
+	dest := new(BucketDestination)
+	if err := r.DecodeJsonPayload(dest); err != nil {
+		transferFail(w, r, ErrBucketDecodeName, err)
+		return
+	}
+
+	// copying a bucket into itself would never end
+	names := dest.names()
+	if len(names) == 0 || string(names[0]) == bucketName {
+		transferFail(w, r, ErrBucketDestination, nil)
+		return
+	}
+
+	result := new(TransferResult)
+	if err := restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil {
+			return ErrBucketMissing
+		}
+		if !restapi.options.sameStorage(bucketName, joinBucketPath(names)) {
+			return ErrBucketStorage
+		}
+		trail.bucket(AuditCopyBucket, bucketName, joinBucketPath(names))
+		newBucket, err := createBucketPath(tx, names, false)
+		if err != nil {
+			return err
+		}
+		if result.Keys, err = copyBucket(bucket, newBucket); err != nil {
+			return err
+		}
+		return transferBlobs(tx, bucketName, names, nil)
+	}); err != nil {
+		transferFail(w, r, ErrBucketCopy, err)
+		return
+	}
+	w.WriteJson(result)
+}
+
+func (restapi *RestApi) MoveBucketItems(w rest.ResponseWriter, r *rest.Request) {
+	bucketName := r.PathParam("name")
+	move := new(BucketMove)
+	if err := r.DecodeJsonPayload(move); err != nil {
+		transferFail(w, r, ErrBucketDecodeName, err)
+		return
+	}
+
+	names := move.names()
+	if len(names) == 0 || (len(names) == 1 && string(names[0]) == bucketName) {
+		transferFail(w, r, ErrBucketDestination, nil)
+		return
+	}
+
+	result := new(TransferResult)
+	if err := restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
+		bucket := tx.Bucket([]byte(bucketName))
+		if bucket == nil {
+			return ErrBucketMissing
+		}
+		if !restapi.options.sameStorage(bucketName, joinBucketPath(names)) {
+			return ErrBucketStorage
+		}
+		destBucket, err := createBucketPath(tx, names, true)
+		if err != nil {
+			return err
+		}
+
+		// collect the range first, bolt cursors don't survive deletes well
+		var keys [][]byte
+		c := bucket.Cursor()
+		for k, v := move.seek(c); !move.done(k); k, v = c.Next() {
+			// nested buckets stay where they are
+			if v == nil {
+				continue
+			}
+			keys = append(keys, append([]byte(nil), k...))
+		}
+
+		destName := joinBucketPath(names)
+		for _, k := range keys {
+			value := bucket.Get(k)
+			trail.item(AuditDelete, bucketName, k, value, nil)
+			trail.item(AuditPut, destName, k, destBucket.Get(k), value)
+			if err := destBucket.Put(k, value); err != nil {
+				return err
+			}
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+			// the blob overwritten, if any, goes away like the item
+			if len(names) == 1 {
+				if err := deleteBlobChunks(tx, destName, string(k)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := transferBlobs(tx, bucketName, names, keys); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := deleteBlobChunks(tx, bucketName, string(k)); err != nil {
+				return err
+			}
+		}
+		result.Keys = len(keys)
+		return nil
+	}); err != nil {
+		transferFail(w, r, ErrBucketMove, err)
+		return
+	}
+	w.WriteJson(result)
+}
+
+func transferFail(w rest.ResponseWriter, r *rest.Request, customErr, origErr error) {
+	logError(r, customErr, origErr)
+	switch origErr {
+	case ErrBucketMissing:
+		rest.Error(w, origErr.Error(), http.StatusNotFound)
+	case bolt.ErrBucketExists, ErrBucketStorage, ErrBucketBlobs:
+		rest.Error(w, origErr.Error(), http.StatusConflict)
+	case ErrAuditBucket:
+		rest.Error(w, origErr.Error(), http.StatusForbidden)
+	default:
+		rest.Error(w, customErr.Error(), http.StatusInternalServerError)
+	}
+}
+
+// sameStorage reports whether the values stored in bucket src can be copied
+// as is to bucket dst, both having the same codec and encryption. Values
+// carry the compression they were written with, which can differ.
+func (o *options) sameStorage(src, dst string) bool {
+	return o.bucketCodec(src) == o.bucketCodec(dst) && o.encrypted[src] == o.encrypted[dst]
+}
+
+// transferBlobs copies the chunks of the blobs of bucket src to the chunk
+// bucket of dst, only those of keys unless keys is nil. Blobs are only
+// served from top-level buckets, nested destinations are refused when there
+// are chunks to copy.
+func transferBlobs(tx *bolt.Tx, src string, dst [][]byte, keys [][]byte) error {
+	srcBlobs := blobBuckets(tx, src)
+	if srcBlobs == nil {
+		return nil
+	}
+	if keys == nil {
+		if k, _ := srcBlobs.Cursor().First(); k == nil {
+			return nil
+		}
+		if len(dst) > 1 {
+			return ErrBucketBlobs
+		}
+		// the destination is new, chunks left under its name are stale
+		if err := deleteBlobBuckets(tx, string(dst[0])); err != nil {
+			return err
+		}
+		dstBlobs, err := createBlobBuckets(tx, string(dst[0]))
+		if err != nil {
+			return err
+		}
+		_, err = copyBucket(srcBlobs, dstBlobs)
+		return err
+	}
+
+	var dstBlobs *bolt.Bucket
+	for _, k := range keys {
+		chunks := srcBlobs.Bucket(k)
+		if chunks == nil {
+			continue
+		}
+		if len(dst) > 1 {
+			return ErrBucketBlobs
+		}
+		var err error
+		if dstBlobs == nil {
+			if dstBlobs, err = createBlobBuckets(tx, string(dst[0])); err != nil {
+				return err
+			}
+		}
+		child, err := dstBlobs.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		if _, err := copyBucket(chunks, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinBucketPath names nested buckets in the audit log, separated by
+// slashes.
+func joinBucketPath(names [][]byte) string {
+	path := make([]string, len(names))
+	for i, name := range names {
+		path[i] = string(name)
+	}
+	return strings.Join(path, "/")
+}
+
+// createBucketPath walks the given path from the root, creating any missing
+// bucket along the way. The last bucket must not exist unless existOk is set.
+func createBucketPath(tx *bolt.Tx, names [][]byte, existOk bool) (*bolt.Bucket, error) {
+	last := len(names) - 1
+	var bucket *bolt.Bucket
+	for i, name := range names {
+		var err error
+		switch {
+		case i == last && !existOk && bucket == nil:
+			bucket, err = tx.CreateBucket(name)
+		case i == last && !existOk:
+			bucket, err = bucket.CreateBucket(name)
+		case bucket == nil:
+			bucket, err = tx.CreateBucketIfNotExists(name)
+		default:
+			bucket, err = bucket.CreateBucketIfNotExists(name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bucket, nil
+}
+
+// copyBucket copies every item and nested bucket of src into dst, returning
+// the number of items copied.
+func copyBucket(src, dst *bolt.Bucket) (int, error) {
+	count := 0
+	if err := src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			child, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			n, err := copyBucket(src.Bucket(k), child)
+			count += n
+			return err
+		}
+		count++
+		return dst.Put(k, v)
+	}); err != nil {
+		return count, err
+	}
+	return count, dst.SetSequence(src.Sequence())
+}
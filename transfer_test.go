@@ -0,0 +1,127 @@
+package boltapi_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBucketTransferEndpoint(t *testing.T) {
+	Convey("testing bucket transfer endpoint", t, func() {
+		restapi, db := prepDB(t)
+
+		request := createRequest("POST", "/api/v1/buckets", map[string]string{"name": "bucket1"}, nil)
+		response := NewRecorder()
+		restapi.AddBucket(response, request)
+		So(response.Code, ShouldEqual, http.StatusOK)
+
+		for _, key := range []string{"item1", "item2", "item3"} {
+			payload := map[string]interface{}{"key": key, "value": key}
+			request = createRequest("POST", "/api/v1/buckets/bucket1", payload, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.AddBucketItem(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+		}
+
+		Convey("should be able to rename bucket", func() {
+			request := createRequest("POST", "/api/v1/buckets/bucket1/rename", map[string]string{"name": "bucket2"}, map[string]string{"name": "bucket1"})
+			response := NewRecorder()
+			restapi.RenameBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+
+			request = createRequest("GET", "/api/v1/buckets", nil, nil)
+			response = NewRecorder()
+			restapi.ListBuckets(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `["bucket2"]`)
+
+			// non-existing bucket returns error
+			request = createRequest("POST", "/api/v1/buckets/bucket1/rename", map[string]string{"name": "bucket3"}, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.RenameBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusNotFound)
+			So(response.Body.String(), ShouldEqual, `{"Error":"bucket doesn't exist"}`)
+		})
+
+		Convey("should be able to copy bucket", func() {
+			payload := map[string]interface{}{"path": []string{"parent", "bucket2"}}
+			request := createRequest("POST", "/api/v1/buckets/bucket1/copy", payload, map[string]string{"name": "bucket1"})
+			response := NewRecorder()
+			restapi.CopyBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `{"Keys":3}`)
+
+			// existing destination returns error
+			request = createRequest("POST", "/api/v1/buckets/bucket1/copy", payload, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.CopyBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusConflict)
+			So(response.Body.String(), ShouldEqual, `{"Error":"bucket already exists"}`)
+
+			request = createRequest("GET", "/api/v1/buckets", nil, nil)
+			response = NewRecorder()
+			restapi.ListBuckets(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `["bucket1","parent"]`)
+		})
+
+		Convey("should be able to move bucket items", func() {
+			payload := map[string]interface{}{"name": "bucket2", "start": "item2"}
+			request := createRequest("POST", "/api/v1/buckets/bucket1/move", payload, map[string]string{"name": "bucket1"})
+			response := NewRecorder()
+			restapi.MoveBucketItems(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `{"Keys":2}`)
+
+			request = createRequest("GET", "/api/v1/buckets/bucket1", nil, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.GetBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `[{"Key":"item1","Value":"item1"}]`)
+
+			request = createRequest("GET", "/api/v1/buckets/bucket2", nil, map[string]string{"name": "bucket2"})
+			response = NewRecorder()
+			restapi.GetBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `[{"Key":"item2","Value":"item2"},{"Key":"item3","Value":"item3"}]`)
+		})
+
+		Convey("should refuse transfers between buckets storing values differently", func() {
+			restapi, err := boltapi.NewRestApi(db, boltapi.BucketCodec("packed", boltapi.MsgpackCodec))
+			So(err, ShouldBeNil)
+
+			request := createRequest("POST", "/api/v1/buckets/bucket1/rename", map[string]string{"name": "packed"}, map[string]string{"name": "bucket1"})
+			response := NewRecorder()
+			restapi.RenameBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusConflict)
+			So(response.Body.String(), ShouldContainSubstring, boltapi.ErrBucketStorage.Error())
+
+			request = createRequest("POST", "/api/v1/buckets/bucket1/copy", map[string]string{"name": "packed"}, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.CopyBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusConflict)
+
+			request = createRequest("POST", "/api/v1/buckets/bucket1/move", map[string]string{"name": "packed"}, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.MoveBucketItems(response, request)
+			So(response.Code, ShouldEqual, http.StatusConflict)
+
+			request = createRequest("GET", "/api/v1/buckets", nil, nil)
+			response = NewRecorder()
+			restapi.ListBuckets(response, request)
+			So(response.Body.String(), ShouldEqual, `["bucket1"]`)
+
+			// buckets with the same codec are fine
+			request = createRequest("POST", "/api/v1/buckets/bucket1/copy", map[string]string{"name": "bucket2"}, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.CopyBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
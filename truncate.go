@@ -0,0 +1,237 @@
+package boltapi
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/boltdb/bolt"
+)
+
+// DefaultTruncateBatchSize is the number of keys deleted per transaction
+// when truncating a bucket. Keeping transactions small bounds the number of
+// dirty pages bolt has to hold before committing.
+const DefaultTruncateBatchSize = 1000
+
+// MaxTruncateBatchSize bounds the batch size clients can ask for, larger
+// ones are lowered to it.
+const MaxTruncateBatchSize = 10000
+
+// BucketTruncate selects the items removed from a bucket. Nested buckets
+// within the range are left alone unless Buckets is set, in which case
+// they're deleted along with their contents.
+type BucketTruncate struct {
+	KeyRange
+	BatchSize int
+	Buckets   bool
+}
+
+type TruncateResult struct {
+	Keys    int
+	Batches int
+}
+
+// TruncateError is the body of failed truncations. The counts cover the
+// batches committed before the failure, which aren't rolled back.
+type TruncateError struct {
+	Error     string
+	RequestId string `json:",omitempty"`
+	TruncateResult
+}
+
+// Truncation reports on the last truncation of a bucket.
+type Truncation struct {
+	TruncateResult
+	Running  bool
+	Error    string `json:",omitempty"`
+	Started  time.Time
+	Finished *time.Time `json:",omitempty"`
+}
+
+// truncations tracks the last truncation of every bucket.
+type truncations struct {
+	mu      sync.Mutex
+	buckets map[string]*Truncation
+}
+
+// get returns a copy of the last truncation of the bucket.
+func (jobs *truncations) get(bucket string) (Truncation, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	job, ok := jobs.buckets[bucket]
+	if !ok {
+		return Truncation{}, false
+	}
+	return *job, true
+}
+
+// start records a new truncation of the bucket unless one is already
+// running.
+func (jobs *truncations) start(bucket string) (*Truncation, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	if job, ok := jobs.buckets[bucket]; ok && job.Running {
+		return nil, false
+	}
+	if jobs.buckets == nil {
+		jobs.buckets = map[string]*Truncation{}
+	}
+	job := &Truncation{Running: true, Started: time.Now()}
+	jobs.buckets[bucket] = job
+	return job, true
+}
+
+func (jobs *truncations) progress(job *Truncation, result *TruncateResult) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	job.TruncateResult = *result
+}
+
+func (jobs *truncations) finish(job *Truncation, err error) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	finished := time.Now()
+	job.Running = false
+	job.Finished = &finished
+	if err != nil {
+		job.Error = err.Error()
+	}
+}
+
+// TruncateBucket deletes the items of the bucket in batches. Its progress
+// can be followed with GetTruncation while it runs.
+func (restapi *RestApi) TruncateBucket(w rest.ResponseWriter, r *rest.Request) {
+	bucketName := r.PathParam("name")
+	truncate := new(BucketTruncate)
+	if err := r.DecodeJsonPayload(truncate); err != nil && err != rest.ErrJsonPayloadEmpty {
+		logError(r, ErrBucketTruncateDecode, err)
+		rest.Error(w, ErrBucketTruncateDecode.Error(), http.StatusInternalServerError)
+		return
+	}
+	if truncate.BatchSize <= 0 {
+		truncate.BatchSize = DefaultTruncateBatchSize
+	}
+	if truncate.BatchSize > MaxTruncateBatchSize {
+		truncate.BatchSize = MaxTruncateBatchSize
+	}
+
+	if err := restapi.view(r, func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(bucketName)) == nil {
+			return ErrBucketMissing
+		}
+		return nil
+	}); err != nil {
+		logError(r, err, nil)
+		rest.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	job, ok := restapi.truncations.start(bucketName)
+	if !ok {
+		logError(r, ErrTruncateRunning, nil)
+		rest.Error(w, ErrTruncateRunning.Error(), http.StatusConflict)
+		return
+	}
+	// the truncation is recorded as failed unless it gets to the end, even
+	// when it panics
+	failure := ErrBucketTruncate
+	defer func() {
+		restapi.truncations.finish(job, failure)
+	}()
+
+	result := new(TruncateResult)
+	fail := func(code int, cusromErr, origErr error) {
+		logError(r, cusromErr, origErr)
+		failure = cusromErr
+		w.WriteHeader(code)
+		w.WriteJson(TruncateError{
+			Error:          cusromErr.Error(),
+			RequestId:      w.Header().Get(RequestIdHeader),
+			TruncateResult: *result,
+		})
+	}
+
+	// next is the key the following batch starts from, so that skipped
+	// nested buckets aren't gone through again
+	var next []byte
+	for {
+		deleted := 0
+		if err := restapi.update(r, func(tx *bolt.Tx, trail *auditTrail) error {
+			bucket := tx.Bucket([]byte(bucketName))
+			if bucket == nil {
+				return ErrBucketMissing
+			}
+
+			var keys, buckets [][]byte
+			c := bucket.Cursor()
+			k, v := truncate.seek(c)
+			if next != nil {
+				k, v = c.Seek(next)
+			}
+			for next = nil; !truncate.done(k); k, v = c.Next() {
+				if len(keys)+len(buckets) == truncate.BatchSize {
+					next = append([]byte(nil), k...)
+					break
+				}
+				if v == nil {
+					if truncate.Buckets {
+						buckets = append(buckets, append([]byte(nil), k...))
+					}
+				} else {
+					keys = append(keys, append([]byte(nil), k...))
+				}
+			}
+
+			for _, k := range keys {
+				trail.item(AuditDelete, bucketName, k, bucket.Get(k), nil)
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+				if err := deleteBlobChunks(tx, bucketName, string(k)); err != nil {
+					return err
+				}
+			}
+			for _, k := range buckets {
+				trail.item(AuditDeleteBucket, bucketName, k, nil, nil)
+				if err := bucket.DeleteBucket(k); err != nil {
+					return err
+				}
+			}
+			deleted = len(keys) + len(buckets)
+			traceTx(r, bucketName, deleted, 0)
+			return nil
+		}); err != nil {
+			switch err {
+			case ErrBucketMissing:
+				fail(http.StatusNotFound, err, nil)
+			default:
+				fail(http.StatusInternalServerError, ErrBucketTruncate, err)
+			}
+			return
+		}
+
+		if deleted > 0 {
+			result.Keys += deleted
+			result.Batches++
+			requestLogOf(r).logger.Info("truncating bucket", "bucket", bucketName, "keys", result.Keys, "batches", result.Batches)
+			restapi.truncations.progress(job, result)
+		}
+		if next == nil {
+			break
+		}
+	}
+	failure = nil
+	w.WriteJson(result)
+}
+
+// GetTruncation reports on the last truncation of the bucket.
+func (restapi *RestApi) GetTruncation(w rest.ResponseWriter, r *rest.Request) {
+	job, ok := restapi.truncations.get(r.PathParam("name"))
+	if !ok {
+		logError(r, ErrTruncateMissing, nil)
+		rest.Error(w, ErrTruncateMissing.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteJson(job)
+}
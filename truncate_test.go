@@ -0,0 +1,153 @@
+package boltapi_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBucketTruncateEndpoint(t *testing.T) {
+	Convey("testing bucket truncate endpoint", t, func() {
+		restapi, db := prepDB(t)
+
+		request := createRequest("POST", "/api/v1/buckets", map[string]string{"name": "bucket1"}, nil)
+		response := NewRecorder()
+		restapi.AddBucket(response, request)
+		So(response.Code, ShouldEqual, http.StatusOK)
+
+		for i := 0; i < 5; i++ {
+			for _, prefix := range []string{"apple", "orange"} {
+				payload := map[string]interface{}{"key": fmt.Sprintf("%s%d", prefix, i), "value": i}
+				request = createRequest("POST", "/api/v1/buckets/bucket1", payload, map[string]string{"name": "bucket1"})
+				response = NewRecorder()
+				restapi.AddBucketItem(response, request)
+				So(response.Code, ShouldEqual, http.StatusOK)
+			}
+		}
+
+		Convey("should be able to truncate bucket", func() {
+			request := createRequest("POST", "/api/v1/buckets/bucket1/truncate", map[string]int{"batchSize": 3}, map[string]string{"name": "bucket1"})
+			response := NewRecorder()
+			restapi.TruncateBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `{"Keys":10,"Batches":4}`)
+
+			request = createRequest("GET", "/api/v1/buckets/bucket1", nil, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.GetBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `[]`)
+		})
+
+		Convey("should cap the batch size", func() {
+			So(db.Update(func(tx *bolt.Tx) error {
+				bucket := tx.Bucket([]byte("bucket1"))
+				for i := 0; i < boltapi.MaxTruncateBatchSize; i++ {
+					if err := bucket.Put([]byte(fmt.Sprintf("pear%05d", i)), []byte("1")); err != nil {
+						return err
+					}
+				}
+				return nil
+			}), ShouldBeNil)
+
+			request := createRequest("POST", "/api/v1/buckets/bucket1/truncate", map[string]int{"batchSize": 1000000}, map[string]string{"name": "bucket1"})
+			response := NewRecorder()
+			restapi.TruncateBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, fmt.Sprintf(`{"Keys":%d,"Batches":2}`, boltapi.MaxTruncateBatchSize+10))
+		})
+
+		Convey("should leave nested buckets alone unless asked", func() {
+			So(db.Update(func(tx *bolt.Tx) error {
+				_, err := tx.Bucket([]byte("bucket1")).CreateBucket([]byte("orange9"))
+				return err
+			}), ShouldBeNil)
+			nested := func() bool {
+				exists := false
+				db.View(func(tx *bolt.Tx) error {
+					exists = tx.Bucket([]byte("bucket1")).Bucket([]byte("orange9")) != nil
+					return nil
+				})
+				return exists
+			}
+
+			request := createRequest("POST", "/api/v1/buckets/bucket1/truncate", map[string]interface{}{"prefix": "orange", "batchSize": 2}, map[string]string{"name": "bucket1"})
+			response := NewRecorder()
+			restapi.TruncateBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `{"Keys":5,"Batches":3}`)
+			So(nested(), ShouldBeTrue)
+
+			request = createRequest("POST", "/api/v1/buckets/bucket1/truncate", map[string]interface{}{"prefix": "orange", "buckets": true}, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.TruncateBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `{"Keys":1,"Batches":1}`)
+			So(nested(), ShouldBeFalse)
+		})
+
+		Convey("should report the last truncation", func() {
+			handler := restapi.GetHandler()
+			So(serve(handler, "GET", "/v1/buckets/bucket1/truncate", nil, nil).Code, ShouldEqual, http.StatusNotFound)
+
+			So(serve(handler, "POST", "/v1/buckets/bucket1/truncate", strings.NewReader(`{"batchSize": 4}`), nil).Code, ShouldEqual, http.StatusOK)
+			response := serve(handler, "GET", "/v1/buckets/bucket1/truncate", nil, nil)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			job := new(boltapi.Truncation)
+			So(json.Unmarshal(response.Body.Bytes(), job), ShouldBeNil)
+			So(job.Keys, ShouldEqual, 10)
+			So(job.Batches, ShouldEqual, 3)
+			So(job.Running, ShouldBeFalse)
+			So(job.Error, ShouldEqual, "")
+			So(job.Finished, ShouldNotBeNil)
+		})
+
+		Convey("should report what was deleted when it fails", func() {
+			So(db.Close(), ShouldBeNil)
+			readOnly, err := bolt.Open("./test.db", 0600, &bolt.Options{ReadOnly: true})
+			So(err, ShouldBeNil)
+			defer readOnly.Close()
+			restapi, err := boltapi.NewRestApi(readOnly)
+			So(err, ShouldBeNil)
+
+			request := createRequest("POST", "/api/v1/buckets/bucket1/truncate", nil, map[string]string{"name": "bucket1"})
+			response := NewRecorder()
+			restapi.TruncateBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusInternalServerError)
+			So(response.Body.String(), ShouldEqual, `{"Error":"error truncating bucket","Keys":0,"Batches":0}`)
+
+			request = createRequest("GET", "/api/v1/buckets/bucket1/truncate", nil, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.GetTruncation(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			job := new(boltapi.Truncation)
+			So(json.Unmarshal(response.Body.Bytes(), job), ShouldBeNil)
+			So(job.Running, ShouldBeFalse)
+			So(job.Error, ShouldEqual, boltapi.ErrBucketTruncate.Error())
+		})
+
+		Convey("should be able to truncate bucket by prefix", func() {
+			request := createRequest("POST", "/api/v1/buckets/bucket1/truncate", map[string]string{"prefix": "orange"}, map[string]string{"name": "bucket1"})
+			response := NewRecorder()
+			restapi.TruncateBucket(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `{"Keys":5,"Batches":1}`)
+
+			request = createRequest("POST", "/api/v1/buckets/bucket1/mget", map[string][]string{"keys": {"apple0", "orange0"}}, map[string]string{"name": "bucket1"})
+			response = NewRecorder()
+			restapi.MultiGetBucketItems(response, request)
+			So(response.Code, ShouldEqual, http.StatusOK)
+			So(response.Body.String(), ShouldEqual, `[{"Key":"apple0","Value":0,"Missing":false},{"Key":"orange0","Value":null,"Missing":true}]`)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+	})
+}
@@ -0,0 +1,21 @@
+package boltapi
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+// UIHandler serves the admin UI. The UI calls the API through ../api, so it
+// expects to be mounted next to it, e.g. /ui/ and /api/ as Serve does. On
+// a MultiApi it browses the database of its db query param.
+func UIHandler() http.Handler {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
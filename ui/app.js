@@ -0,0 +1,326 @@
+(function () {
+  "use strict";
+
+  var ROOT = "../api/v1";
+  var PAGE_SIZE = 50;
+
+  // multi-database servers serve the api of each database under
+  // /v1/dbs/<name>, picked with the db query param
+  var DB = new URLSearchParams(location.search).get("db");
+  var API = DB ? ROOT + "/dbs/" + encodeURIComponent(DB) : ROOT;
+
+  var state = {
+    bucket: null,
+    starts: [""],  // start key of every page visited so far
+    next: null,
+    items: [],
+    item: null     // item being edited, null when adding a new one
+  };
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  function bucketUrl(bucket, key) {
+    var url = API + "/buckets/" + encodeURIComponent(bucket);
+    return key === undefined ? url : url + "/" + encodeURIComponent(key);
+  }
+
+  function request(method, url, body) {
+    var opts = { method: method, headers: {} };
+    if (body !== undefined) {
+      opts.body = JSON.stringify(body);
+      opts.headers["Content-Type"] = "application/json";
+    }
+    return fetch(url, opts).then(function (res) {
+      return res.text().then(function (text) {
+        var data = text ? JSON.parse(text) : null;
+        if (!res.ok) {
+          throw new Error((data && data.Error) || res.statusText);
+        }
+        return { data: data, headers: res.headers };
+      });
+    });
+  }
+
+  // isBlob tells the manifests of blobs apart, their content being served
+  // by the blob endpoint rather than edited as a value
+  function isBlob(value) {
+    return value !== null && typeof value === "object" && value.Blob === true;
+  }
+
+  // isBucket tells nested buckets apart, listed with a null value since
+  // bolt stores none for them. They're shown but can't be edited.
+  function isBucket(value) {
+    return value === null;
+  }
+
+  // printable shows stored bytes as text, escaping those that aren't
+  // printable ASCII
+  function printable(bytes) {
+    var text = "";
+    for (var i = 0; i < bytes.length; i++) {
+      var b = bytes[i];
+      if ((b >= 0x20 && b < 0x7f) || b === 0x0a) {
+        text += String.fromCharCode(b);
+      } else {
+        text += "\\x" + (b < 0x10 ? "0" : "") + b.toString(16);
+      }
+    }
+    return text;
+  }
+
+  function showError(err) {
+    $("error").textContent = err ? err.message : "";
+  }
+
+  function openDatabase(name) {
+    location.search = "?db=" + encodeURIComponent(name);
+  }
+
+  // loadDatabases lists the databases of a multi-database server, opening
+  // the first one when none is picked yet. It resolves to false while the
+  // page is going away. Single-database servers have no listing.
+  function loadDatabases() {
+    return request("GET", ROOT + "/dbs").then(function (res) {
+      var names = res.data.map(function (database) {
+        return database.Name;
+      });
+      if (!DB && names.length > 0) {
+        openDatabase(names[0]);
+        return false;
+      }
+      var select = $("database");
+      names.forEach(function (name) {
+        var option = document.createElement("option");
+        option.value = name;
+        option.textContent = name;
+        option.selected = name === DB;
+        select.appendChild(option);
+      });
+      select.onchange = function () {
+        openDatabase(select.value);
+      };
+      $("databases").hidden = false;
+      return true;
+    }, function () {
+      return true;
+    });
+  }
+
+  function loadBuckets() {
+    return request("GET", API + "/buckets").then(function (res) {
+      var list = $("buckets");
+      list.textContent = "";
+      res.data.sort().forEach(function (name) {
+        var li = document.createElement("li");
+        li.textContent = name;
+        if (name === state.bucket) {
+          li.className = "active";
+        }
+        li.onclick = function () {
+          openBucket(name);
+        };
+        list.appendChild(li);
+      });
+    }).catch(showError);
+  }
+
+  function openBucket(name) {
+    state.bucket = name;
+    state.starts = [""];
+    $("prefix").value = "";
+    closeItem();
+    loadBuckets();
+    loadPage();
+  }
+
+  function loadPage() {
+    var start = state.starts[state.starts.length - 1];
+    var query = "?limit=" + PAGE_SIZE;
+    if (start) {
+      query += "&start=" + encodeURIComponent(start);
+    }
+    if ($("prefix").value) {
+      query += "&prefix=" + encodeURIComponent($("prefix").value);
+    }
+
+    return request("GET", bucketUrl(state.bucket) + query).then(function (res) {
+      var next = res.headers.get("X-Next-Key");
+      state.next = next ? decodeURIComponent(next.replace(/\+/g, " ")) : null;
+      // values come decoded as JSON, whatever the codec, compression or
+      // encryption of the bucket
+      state.items = res.data.map(function (item) {
+        return { key: item.Key, value: item.Value };
+      });
+      renderBucket();
+      showError(null);
+    }).catch(showError);
+  }
+
+  function renderBucket() {
+    $("bucket").hidden = false;
+    $("bucket-name").textContent = state.bucket;
+    $("page").textContent = "Page " + state.starts.length;
+    $("prev").disabled = state.starts.length === 1;
+    $("next").disabled = state.next === null;
+
+    var tbody = $("items");
+    tbody.textContent = "";
+    state.items.forEach(function (item) {
+      var tr = document.createElement("tr");
+      var key = document.createElement("td");
+      key.textContent = item.key;
+      var value = document.createElement("td");
+      if (isBlob(item.value)) {
+        var link = document.createElement("a");
+        link.href = bucketUrl(state.bucket, item.key) + "/blob";
+        link.textContent = "(blob, " + item.value.Size + " bytes" +
+          (item.value.Complete ? "" : ", incomplete") + ")";
+        value.appendChild(link);
+      } else if (isBucket(item.value)) {
+        value.textContent = "(nested bucket)";
+        tr.className = "readonly";
+      } else {
+        value.textContent = JSON.stringify(item.value);
+        tr.onclick = function () {
+          openItem(item);
+        };
+      }
+      tr.appendChild(key);
+      tr.appendChild(value);
+      tbody.appendChild(tr);
+    });
+  }
+
+  function openItem(item) {
+    state.item = item;
+    $("item").hidden = false;
+    $("item-key").value = item ? item.key : "";
+    $("item-key").disabled = !!item;
+    $("delete-item").hidden = !item;
+    $("item-error").textContent = "";
+
+    $("item-value").value = item ? JSON.stringify(item.value, null, 2) : "";
+    setView("pretty");
+  }
+
+  // loadRaw shows the value of the open item as the bucket stores it,
+  // encoded, compressed or encrypted
+  function loadRaw() {
+    var item = state.item;
+    $("item-raw").textContent = "";
+    if (!item) {
+      return;
+    }
+    fetch(bucketUrl(state.bucket, item.key) + "?raw=1").then(function (res) {
+      if (!res.ok) {
+        throw new Error(res.statusText);
+      }
+      return res.arrayBuffer();
+    }).then(function (buffer) {
+      // another item may have been opened meanwhile
+      if (state.item === item) {
+        $("item-raw").textContent = printable(new Uint8Array(buffer));
+      }
+    }).catch(function (err) {
+      $("item-error").textContent = err.message;
+    });
+  }
+
+  function closeItem() {
+    state.item = null;
+    $("item").hidden = true;
+  }
+
+  function setView(view) {
+    document.querySelector("input[name=view][value=" + view + "]").checked = true;
+    $("item-value").hidden = view !== "pretty";
+    $("item-raw").hidden = view !== "raw";
+    if (view === "raw") {
+      loadRaw();
+    }
+  }
+
+  function saveItem() {
+    var value;
+    try {
+      value = JSON.parse($("item-value").value);
+    } catch (err) {
+      $("item-error").textContent = "Invalid JSON: " + err.message;
+      return;
+    }
+
+    var key = $("item-key").value;
+    var saved = state.item
+      ? request("PUT", bucketUrl(state.bucket, key), value)
+      : request("POST", bucketUrl(state.bucket), { key: key, value: value });
+    saved.then(function () {
+      closeItem();
+      loadPage();
+    }).catch(function (err) {
+      $("item-error").textContent = err.message;
+    });
+  }
+
+  function deleteItem() {
+    if (!confirm("Delete " + state.item.key + "?")) {
+      return;
+    }
+    request("DELETE", bucketUrl(state.bucket, state.item.key)).then(function () {
+      closeItem();
+      loadPage();
+    }).catch(function (err) {
+      $("item-error").textContent = err.message;
+    });
+  }
+
+  $("new-bucket").onsubmit = function (e) {
+    e.preventDefault();
+    var name = e.target.elements.name.value;
+    request("POST", API + "/buckets", { name: name }).then(function () {
+      e.target.reset();
+      openBucket(name);
+    }).catch(showError);
+  };
+  $("delete-bucket").onclick = function () {
+    if (!confirm("Delete bucket " + state.bucket + " and all its items?")) {
+      return;
+    }
+    request("DELETE", bucketUrl(state.bucket)).then(function () {
+      state.bucket = null;
+      $("bucket").hidden = true;
+      closeItem();
+      loadBuckets();
+    }).catch(showError);
+  };
+  $("prefix").oninput = function () {
+    state.starts = [""];
+    loadPage();
+  };
+  $("prev").onclick = function () {
+    state.starts.pop();
+    loadPage();
+  };
+  $("next").onclick = function () {
+    state.starts.push(state.next);
+    loadPage();
+  };
+  $("new-item").onclick = function () {
+    openItem(null);
+  };
+  $("save-item").onclick = saveItem;
+  $("delete-item").onclick = deleteItem;
+  $("close-item").onclick = closeItem;
+  Array.prototype.forEach.call(document.querySelectorAll("input[name=view]"), function (input) {
+    input.onchange = function () {
+      setView(input.value);
+    };
+  });
+
+  loadDatabases().then(function (ready) {
+    if (ready) {
+      loadBuckets();
+    }
+  });
+})();
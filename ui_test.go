@@ -0,0 +1,29 @@
+package boltapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/marconi/boltapi"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUIHandler(t *testing.T) {
+	Convey("testing ui handler", t, func() {
+		handler := http.StripPrefix("/ui", boltapi.UIHandler())
+
+		Convey("should serve the ui files", func() {
+			for _, path := range []string{"/ui/", "/ui/app.js", "/ui/style.css"} {
+				response := httptest.NewRecorder()
+				handler.ServeHTTP(response, httptest.NewRequest("GET", path, nil))
+				So(response.Code, ShouldEqual, http.StatusOK)
+			}
+
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, httptest.NewRequest("GET", "/ui/", nil))
+			So(strings.Contains(response.Body.String(), "<title>BoltDB admin</title>"), ShouldBeTrue)
+		})
+	})
+}